@@ -0,0 +1,32 @@
+package api
+
+import (
+	"github.com/chauvm/timetravel/retention"
+	"github.com/chauvm/timetravel/service"
+	"github.com/gorilla/mux"
+)
+
+// AdminAPI serves the maintenance endpoints, it is mounted under /api/v2/admin.
+type AdminAPI struct {
+	compactor *retention.Compactor
+	holds     service.LegalHoldService
+	tenants   service.TenantService
+	// backups is nil when the record service cannot be backed up online
+	backups service.BackupService
+}
+
+func NewAdminAPI(compactor *retention.Compactor, holds service.LegalHoldService, tenants service.TenantService, backups service.BackupService) *AdminAPI {
+	return &AdminAPI{compactor, holds, tenants, backups}
+}
+
+// generates all admin routes
+func (a *AdminAPI) CreateRoutes(routes *mux.Router) {
+	routes.Path("/compact").HandlerFunc(a.PostCompact).Methods("POST").Name("admin.post_compact")
+	routes.Path("/records/{id}/hold").HandlerFunc(a.GetLegalHold).Methods("GET").Name("admin.get_legal_hold")
+	routes.Path("/records/{id}/hold").HandlerFunc(a.PostLegalHold).Methods("POST").Name("admin.post_legal_hold")
+	routes.Path("/records/{id}/hold").HandlerFunc(a.DeleteLegalHold).Methods("DELETE").Name("admin.delete_legal_hold")
+	// the tenant is the one of the request, see ResolveTenant
+	routes.Path("/tenant/export").HandlerFunc(a.GetTenantExport).Methods("GET").Name("admin.get_tenant_export")
+	routes.Path("/tenant").HandlerFunc(a.DeleteTenant).Methods("DELETE").Name("admin.delete_tenant")
+	routes.Path("/backup").HandlerFunc(a.PostBackup).Methods("POST").Name("admin.post_backup")
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/tenant"
+)
+
+// tenantExport is every version of every record of a tenant
+type tenantExport struct {
+	Tenant  string          `json:"tenant"`
+	Records []entity.Record `json:"records"`
+}
+
+// admin GET /tenant/export
+// GetTenantExport returns every version of every record of the tenant of the request.
+func (a *AdminAPI) GetTenantExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	records, err := a.tenants.ExportTenant(ctx)
+	if err != nil {
+		writeServiceProblem(w, r, err)
+		return
+	}
+
+	err = writeJSON(w, tenantExport{Tenant: tenant.ID(ctx), Records: records}, http.StatusOK)
+	logError(err)
+}
+
+// admin DELETE /tenant
+// DeleteTenant removes every record of the tenant of the request. Nothing is removed
+// while any of them is under legal hold, and the history of the holds is always kept.
+func (a *AdminAPI) DeleteTenant(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	deleted, err := a.tenants.DeleteTenant(ctx)
+	if err != nil {
+		writeServiceProblem(w, r, err)
+		return
+	}
+
+	err = writeJSON(w, map[string]interface{}{"tenant": tenant.ID(ctx), "deleted": deleted}, http.StatusOK)
+	logError(err)
+}
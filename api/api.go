@@ -1,6 +1,7 @@
 package api
 
 import (
+	"github.com/chauvm/timetravel/rating"
 	"github.com/chauvm/timetravel/service"
 	"github.com/gorilla/mux"
 )
@@ -15,23 +16,31 @@ func NewAPI(records service.RecordService) *API {
 
 // generates all api routes
 func (a *API) CreateRoutes(routes *mux.Router) {
-	routes.Path("/records/{id}").HandlerFunc(a.GetRecords).Methods("GET")
-	routes.Path("/records/{id}").HandlerFunc(a.PostRecords).Methods("POST")
+	routes.Path("/records/{id}").HandlerFunc(a.GetRecords).Methods("GET").Name("v1.get_record")
+	routes.Path("/records/{id}").HandlerFunc(a.PostRecords).Methods("POST").Name("v1.post_record")
 }
 
 type APIV2 struct {
 	records service.RecordService
+	raters  *rating.Registry
 }
 
 func NewAPIV2(records service.RecordService) *APIV2 {
-	return &APIV2{records}
+	return &APIV2{records, rating.DefaultRegistry}
 }
 
 // generates all api routes
 func (a *APIV2) CreateRoutes(routes *mux.Router) {
-	routes.Path("/records/{id}").HandlerFunc(a.GetRecords).Methods("GET")
-	routes.Path("/records/{id}").HandlerFunc(a.PostRecords).Methods("POST")
+	routes.Path("/records/{id}").HandlerFunc(a.GetRecords).Methods("GET").Name("v2.get_record")
+	routes.Path("/records/{id}").HandlerFunc(a.PostRecords).Methods("POST").Name("v2.post_record")
 	// new endpoints compared to v1
-	routes.Path("/records/{id}/versions").HandlerFunc(a.GetVersions).Methods("GET")
-	routes.Path("/records/{id}/{version}").HandlerFunc(a.GetRecordAtVersion).Methods("GET")
+	routes.Path("/records/{id}/versions").HandlerFunc(a.GetVersions).Methods("GET").Name("v2.get_versions")
+	routes.Path("/records/{id}/rate").HandlerFunc(a.GetRate).Methods("GET").Name("v2.get_rate")
+	routes.Path("/records/{id}/history").HandlerFunc(a.GetRecordHistory).Methods("GET").Name("v2.get_record_history")
+	routes.Path("/records/{id}/at").HandlerFunc(a.GetRecordAtTime).Methods("GET").Name("v2.get_record_at_time")
+	routes.Path("/records/{id}/{version}").HandlerFunc(a.GetRecordAtVersion).Methods("GET").Name("v2.get_record_at_version")
+	routes.Path("/reports/retroactive").HandlerFunc(a.GetRetroactiveReport).Methods("GET").Name("v2.get_retroactive_report")
+	routes.Path("/graphql").HandlerFunc(a.PostGraphQL).Methods("POST").Name("v2.post_graphql")
+	// public, like the health checks
+	routes.Path("/openapi.json").HandlerFunc(a.GetOpenAPI).Methods("GET")
 }
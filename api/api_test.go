@@ -2,53 +2,168 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/chauvm/timetravel/auth"
 	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/health"
+	"github.com/chauvm/timetravel/metrics"
+	"github.com/chauvm/timetravel/retention"
 	"github.com/chauvm/timetravel/service"
+	"github.com/chauvm/timetravel/tenant"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 )
 
+// authenticator and adminKey are reset by setUp, makeRequest sends adminKey
+var authenticator *auth.Authenticator
+var adminKey string
+
+// versionCounts is reset by setUp, refresh it to read the versions per record
+var versionCounts *metrics.HistogramSnapshot
+
+// backupDir is a new temporary directory on every setUp, POST /api/v2/admin/backup writes there
+var backupDir string
+
+// limits are small enough for the tests to reach each of them
+var limits = service.Limits{
+	MaxBodyBytes:   1024,
+	MaxKeys:        8,
+	MaxKeyLength:   16,
+	MaxValueLength: 64,
+	MaxRecordBytes: 256,
+	MaxVersions:    20,
+}
+
 func setUp() *mux.Router {
 	// sql test db
 	db, err := database.CreateConnectionUnitTests()
 	if err != nil {
 		panic(err)
 	}
+	authenticator = auth.NewAuthenticator(db)
+	adminKey, err = authenticator.Mint(context.Background(), "admin@example.com", auth.ROLE_ADMIN, "")
+	if err != nil {
+		panic(err)
+	}
 
 	router := mux.NewRouter()
+	router.Use(InstrumentRequests)
+	router.Use(Authenticate(authenticator))
+	router.Use(ResolveTenant)
+	versionCounts = database.RegisterMetrics(metrics.Default, db, database.DATABASE_FILE_UNIT_TEST)
+	router.Path("/metrics").Handler(metrics.Default.Handler()).Methods("GET")
 	// v2
-	persistentService := service.NewPersistentRecordService(db)
+	persistentService := service.NewPersistentRecordService(db, limits)
+
+	// keep only the current state of every record, so compaction is observable right away
+	compactor := retention.NewCompactor(&persistentService, []retention.Policy{{Then: retention.THIN_ALL}})
 
 	newAPI := NewAPI(&persistentService)
 	newAPIV2 := NewAPIV2(&persistentService)
+	backupDir, err = os.MkdirTemp("", "timetravel-backups")
+	if err != nil {
+		panic(err)
+	}
+	backups := service.NewPersistentBackupService(db, database.DATABASE_FILE_UNIT_TEST, backupDir)
+	adminAPI := NewAdminAPI(compactor, &persistentService, &persistentService, backups)
 
 	apiRouteV1 := router.PathPrefix("/api/v1").Subrouter()
 	apiRouteV2 := router.PathPrefix("/api/v2").Subrouter()
 
+	checker := health.NewChecker(health.DEFAULT_TIMEOUT,
+		health.Check{Name: "database", Run: func(ctx context.Context) error { return database.Ping(ctx, db) }},
+		health.Check{Name: "migrations", Run: func(ctx context.Context) error { return database.CheckMigrations(ctx, db) }},
+	)
+	healthAPI := NewHealthAPI(checker)
+	healthAPI.CreateRoutes(apiRouteV1)
+	healthAPI.CreateRoutes(apiRouteV2)
+
 	newAPI.CreateRoutes(apiRouteV1)
 	newAPIV2.CreateRoutes(apiRouteV2)
+	adminAPI.CreateRoutes(apiRouteV2.PathPrefix("/admin").Subrouter())
 
 	return router
 }
 
 func makeRequest(router *mux.Router, req *http.Request) *httptest.ResponseRecorder {
+	return makeRequestAs(router, req, adminKey)
+}
+
+// makeRequestAs sends the request with the api key, or without any if it is empty
+func makeRequestAs(router *mux.Router, req *http.Request, key string) *httptest.ResponseRecorder {
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
 	rr := httptest.NewRecorder()
-	router.ServeHTTP(rr, req)
+	// like the server, LogRequests wraps the whole router
+	LogRequests(router).ServeHTTP(rr, req)
 
 	return rr
 }
 
+// assertProblem checks the response is an application/problem+json error with the code
+func assertProblem(t *testing.T, rr *httptest.ResponseRecorder, statusCode int, code string) Problem {
+	var problem Problem
+	assert.Equal(t, statusCode, rr.Code)
+	assert.Equal(t, PROBLEM_CONTENT_TYPE, rr.Header().Get("Content-Type"))
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+	assert.Equal(t, statusCode, problem.Status)
+	assert.Equal(t, code, problem.Code)
+	return problem
+}
+
 // GET /api/v1/records/{id}
+// every sentinel of the services is reported with its own status and code, and only
+// its message: the wrapped driver error is not sent
+func TestServiceProblems(t *testing.T) {
+	driverErr := errors.New("sqlite: disk I/O error at page 7")
+	cases := []struct {
+		err        error
+		statusCode int
+		code       string
+	}{
+		{service.ErrRecordDoesNotExist, 404, CODE_RECORD_NOT_FOUND},
+		{service.ErrRecordIDInvalid, 400, CODE_INVALID_ID},
+		{service.ErrRecordAlreadyExists, 409, CODE_RECORD_ALREADY_EXISTS},
+		{service.ErrRecordConflict, 409, CODE_RECORD_CONFLICT},
+		{service.ErrRecordOnHold, 409, CODE_RECORD_ON_HOLD},
+		{service.ErrUnavailable, 503, CODE_UNAVAILABLE},
+		{service.ErrConstraintViolation, 422, CODE_CONSTRAINT_VIOLATION},
+		{service.ErrStorageCorrupt, 500, CODE_STORAGE_CORRUPT},
+		{errors.New("unexpected"), 500, CODE_INTERNAL},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest("GET", "/api/v2/records/1", nil)
+		rr := httptest.NewRecorder()
+		writeServiceProblem(rr, req, fmt.Errorf("%w: %w", c.err, driverErr))
+		problem := assertProblem(t, rr, c.statusCode, c.code)
+		assert.NotContains(t, problem.Detail, driverErr.Error(), c.code)
+	}
+}
+
 func TestGetRecordsV1(t *testing.T) {
 	router := setUp()
 	req, _ := http.NewRequest("GET", "/api/v1/records/1", nil)
 	rr := makeRequest(router, req)
+	// v1 keeps 400 for a missing record, for compatibility, v2 answers 404
 	assert.Equal(t, 400, rr.Code)
 	assert.Equal(t, "{\"error\":\"record of id 1 does not exist\"}\n", rr.Body.String())
+	req, _ = http.NewRequest("GET", "/api/v2/records/1", nil)
+	rr = makeRequest(router, req)
+	assertProblem(t, rr, 404, CODE_RECORD_NOT_FOUND)
 }
 
 // TODO 2: fix POST v1 to return as is
@@ -101,9 +216,17 @@ func TestGetRecordsV2(t *testing.T) {
 	router := setUp()
 	// get a record not yet exist
 	req, _ := http.NewRequest("GET", "/api/v2/records/1", nil)
+	req.Header.Set(REQUEST_ID_HEADER, "req-1")
 	rr := makeRequest(router, req)
-	assert.Equal(t, 400, rr.Code)
-	assert.Equal(t, "{\"error\":\"record of id 1 does not exist\"}\n", rr.Body.String())
+	problem := assertProblem(t, rr, 404, CODE_RECORD_NOT_FOUND)
+	assert.Equal(t, "urn:timetravel:problem:record_not_found", problem.Type)
+	assert.Equal(t, "/api/v2/records/1", problem.Instance)
+	assert.Equal(t, "req-1", problem.RequestID)
+
+	// invalid ids are rejected before the lookup
+	req, _ = http.NewRequest("GET", "/api/v2/records/abc", nil)
+	rr = makeRequest(router, req)
+	assertProblem(t, rr, 400, CODE_INVALID_ID)
 }
 
 func TestPostRecordsV2(t *testing.T) {
@@ -148,6 +271,25 @@ func TestPostRecordsV2(t *testing.T) {
 	assert.Equal(t, "{\"id\":1,\"data\":{\"status\":\"ok\"}}\n", rr5.Body.String())
 }
 
+func TestPostRecordsPreconditions(t *testing.T) {
+	router := setUp()
+
+	req, _ := http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"hello":"world"}`)))
+	req.Header.Set("If-Match", "*")
+	rr := makeRequest(router, req)
+	assertProblem(t, rr, 412, CODE_RECORD_NOT_FOUND)
+
+	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"hello":"world"}`)))
+	req.Header.Set("If-None-Match", "*")
+	rr = makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+
+	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"hello":"again"}`)))
+	req.Header.Set("If-None-Match", "*")
+	rr = makeRequest(router, req)
+	assertProblem(t, rr, 412, CODE_RECORD_ALREADY_EXISTS)
+}
+
 func TestGetVersions(t *testing.T) {
 	router := setUp()
 	// a non-existing record should return an empty list without throwing an error
@@ -175,8 +317,7 @@ func TestGetRecordAtVersion(t *testing.T) {
 	// a non-existing record should return an error
 	req, _ := http.NewRequest("GET", "/api/v2/records/1/1", nil)
 	rr := makeRequest(router, req)
-	assert.Equal(t, 400, rr.Code)
-	assert.Equal(t, "{\"error\":\"Unable to retrieve record of id 1 at version 1\"}\n", rr.Body.String())
+	assertProblem(t, rr, 404, CODE_RECORD_NOT_FOUND)
 
 	// create a couple of versions of a record
 	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"hello":"world"}`)))
@@ -202,3 +343,749 @@ func TestGetRecordAtVersion(t *testing.T) {
 	assert.Equal(t, 200, rr3.Code)
 	assert.Equal(t, "{\"id\":1,\"data\":{\"status\":\"ok\"}}\n", rr3.Body.String())
 }
+
+func TestGetRate(t *testing.T) {
+	router := setUp()
+	// a non-existing record cannot be rated
+	req, _ := http.NewRequest("GET", "/api/v2/records/1/rate?version=1", nil)
+	rr := makeRequest(router, req)
+	assertProblem(t, rr, 404, CODE_RECORD_NOT_FOUND)
+
+	// create a couple of versions of a record
+	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"state":"CA"}`)))
+	makeRequest(router, req)
+	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"employees":"2"}`)))
+	makeRequest(router, req)
+
+	// at a version
+	req1, _ := http.NewRequest("GET", "/api/v2/records/1/rate?version=1", nil)
+	rr1 := makeRequest(router, req1)
+	assert.Equal(t, 200, rr1.Code)
+	assert.Equal(t, "{\"id\":1,\"version\":1,\"premium\":{\"total\":750,\"breakdown\":[{\"name\":\"base\",\"amount\":500},{\"name\":\"state=CA\",\"amount\":250}]}}\n", rr1.Body.String())
+
+	// at a time, after the latest version
+	at := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	req2, _ := http.NewRequest("GET", "/api/v2/records/1/rate?rater=example&at="+at, nil)
+	rr2 := makeRequest(router, req2)
+	assert.Equal(t, 200, rr2.Code)
+	assert.Contains(t, rr2.Body.String(), "\"version\":2,\"premium\":{\"total\":990")
+
+	// across a window
+	from := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	req3, _ := http.NewRequest("GET", "/api/v2/records/1/rate?from="+from+"&to="+at, nil)
+	rr3 := makeRequest(router, req3)
+	assert.Equal(t, 200, rr3.Code)
+	assert.Contains(t, rr3.Body.String(), "\"periods\":[")
+
+	// unknown raters and missing parameters are rejected
+	req4, _ := http.NewRequest("GET", "/api/v2/records/1/rate?rater=nope&version=1", nil)
+	rr4 := makeRequest(router, req4)
+	problem := assertProblem(t, rr4, 400, CODE_RATER_NOT_FOUND)
+	assert.Equal(t, "rater \"nope\" does not exist", problem.Detail)
+
+	req5, _ := http.NewRequest("GET", "/api/v2/records/1/rate", nil)
+	rr5 := makeRequest(router, req5)
+	assertProblem(t, rr5, 400, CODE_INVALID_PARAMETER)
+	// a value that is not a finite number is a problem, not a premium that cannot be encoded
+	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"employees":"NaN"}`)))
+	makeRequest(router, req)
+	req6, _ := http.NewRequest("GET", "/api/v2/records/1/rate?version=3", nil)
+	rr6 := makeRequest(router, req6)
+	assertProblem(t, rr6, 422, CODE_INVALID_FIELD)
+}
+
+func TestGetRetroactiveReport(t *testing.T) {
+	router := setUp()
+	req, _ := http.NewRequest("GET", "/api/v2/reports/retroactive", nil)
+	rr := makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+	assert.Equal(t, "{\"data\":[]}\n", rr.Body.String())
+
+	// a change reported on time, then one reported 4 months late
+	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"address":"old"}`)))
+	makeRequest(router, req)
+	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"address":"old","zip":"94103"}`)))
+	makeRequest(router, req)
+	occurredAt := time.Now().AddDate(0, -4, 0).UTC().Format("2006-01-02")
+	req, _ = http.NewRequest("POST", "/api/v2/records/1?occurred_at="+occurredAt, bytes.NewBuffer([]byte(`{"address":"new"}`)))
+	rr = makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+
+	req1, _ := http.NewRequest("GET", "/api/v2/reports/retroactive?threshold_days=30", nil)
+	rr1 := makeRequest(router, req1)
+	assert.Equal(t, 200, rr1.Code)
+	assert.Contains(t, rr1.Body.String(), "\"id\":1,\"version\":3,\"occurred_at\":\""+occurredAt+"T00:00:00Z\"")
+	// before is the version the late change replaced, not the one in force when it occurred
+	assert.Contains(t, rr1.Body.String(), "\"before\":{\"address\":\"old\",\"zip\":\"94103\"},\"after\":{\"address\":\"new\",\"zip\":\"94103\"}")
+
+	// the lag is under a larger threshold
+	req2, _ := http.NewRequest("GET", "/api/v2/reports/retroactive?threshold_days=200", nil)
+	rr2 := makeRequest(router, req2)
+	assert.Equal(t, 200, rr2.Code)
+	assert.Equal(t, "{\"data\":[]}\n", rr2.Body.String())
+
+	// changes cannot occur in the future
+	future := time.Now().AddDate(0, 1, 0).UTC().Format("2006-01-02")
+	req3, _ := http.NewRequest("POST", "/api/v2/records/1?occurred_at="+future, bytes.NewBuffer([]byte(`{"address":"newer"}`)))
+	rr3 := makeRequest(router, req3)
+	assert.Equal(t, 400, rr3.Code)
+}
+
+func TestLegalHold(t *testing.T) {
+	router := setUp()
+	hold := `{"actor":"legal@example.com","reason":"claim 1234"}`
+
+	// a non-existing record cannot be held
+	req, _ := http.NewRequest("POST", "/api/v2/admin/records/1/hold", bytes.NewBuffer([]byte(hold)))
+	rr := makeRequest(router, req)
+	assertProblem(t, rr, 404, CODE_RECORD_NOT_FOUND)
+
+	// create a couple of versions of a record and hold it
+	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"hello":"world"}`)))
+	makeRequest(router, req)
+	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"hello":"world 2"}`)))
+	makeRequest(router, req)
+
+	req, _ = http.NewRequest("POST", "/api/v2/admin/records/1/hold", bytes.NewBuffer([]byte(`{"actor":"legal@example.com"}`)))
+	rr = makeRequest(router, req)
+	assertProblem(t, rr, 400, CODE_LEGAL_HOLD_REASON_MISSING)
+
+	// the actor is the authenticated key, the actor of the body is ignored
+	req, _ = http.NewRequest("POST", "/api/v2/admin/records/1/hold", bytes.NewBuffer([]byte(hold)))
+	rr = makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+	assert.Contains(t, rr.Body.String(), "\"record_id\":1,\"placed_by\":\"admin@example.com\",\"reason\":\"claim 1234\"")
+
+	req, _ = http.NewRequest("POST", "/api/v2/admin/records/1/hold", bytes.NewBuffer([]byte(hold)))
+	rr = makeRequest(router, req)
+	assertProblem(t, rr, 409, CODE_LEGAL_HOLD_EXISTS)
+
+	// compaction leaves the held record alone
+	req, _ = http.NewRequest("POST", "/api/v2/admin/compact", nil)
+	rr = makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+	assert.Equal(t, "{\"dry_run\":false,\"removed\":[],\"total\":0,\"held\":[1]}\n", rr.Body.String())
+
+	// updates still append versions
+	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"hello":"world 3"}`)))
+	rr = makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+
+	// release the hold, compaction then goes through
+	req, _ = http.NewRequest("DELETE", "/api/v2/admin/records/1/hold", bytes.NewBuffer([]byte(`{"actor":"legal@example.com","reason":"settled"}`)))
+	rr = makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+	// a hold that is not there is not found
+	req, _ = http.NewRequest("DELETE", "/api/v2/admin/records/1/hold", bytes.NewBuffer([]byte(`{"actor":"legal@example.com","reason":"settled"}`)))
+	rr = makeRequest(router, req)
+	assertProblem(t, rr, 404, CODE_LEGAL_HOLD_NOT_FOUND)
+
+	req, _ = http.NewRequest("POST", "/api/v2/admin/compact?dry_run=true", nil)
+	rr = makeRequest(router, req)
+	assert.Equal(t, "{\"dry_run\":true,\"removed\":[{\"id\":1,\"versions\":[1,2]}],\"total\":2,\"held\":[]}\n", rr.Body.String())
+
+	req, _ = http.NewRequest("POST", "/api/v2/admin/compact", nil)
+	rr = makeRequest(router, req)
+	assert.Equal(t, "{\"dry_run\":false,\"removed\":[{\"id\":1,\"versions\":[1,2]}],\"total\":2,\"held\":[]}\n", rr.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/v2/records/1/versions", nil)
+	rr = makeRequest(router, req)
+	assert.Equal(t, "{\"data\":[3]}\n", rr.Body.String())
+
+	// both hold events are kept
+	req, _ = http.NewRequest("GET", "/api/v2/admin/records/1/hold", nil)
+	rr = makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+	assert.Contains(t, rr.Body.String(), "\"hold\":null")
+	assert.Contains(t, rr.Body.String(), "\"action\":\"placed\"")
+	assert.Contains(t, rr.Body.String(), "\"action\":\"released\",\"actor\":\"admin@example.com\",\"reason\":\"settled\"")
+	assert.NotContains(t, rr.Body.String(), "legal@example.com")
+}
+
+func TestLegalHoldWithoutAuthentication(t *testing.T) {
+	db, err := database.CreateConnectionUnitTests()
+	assert.NoError(t, err)
+	records := service.NewPersistentRecordService(db, limits)
+	assert.NoError(t, records.CreateRecord(context.Background(), entity.Record{ID: 1, Version: 1, Data: map[string]string{}}))
+	routes := mux.NewRouter()
+	NewAdminAPI(nil, &records, &records, nil).CreateRoutes(routes)
+
+	// with no principal, the actor of the body is the only one there is
+	req, _ := http.NewRequest("POST", "/records/1/hold", bytes.NewBuffer([]byte(`{"reason":"claim 1234"}`)))
+	assertProblem(t, makeRequestAs(routes, req, ""), 400, CODE_LEGAL_HOLD_REASON_MISSING)
+	req, _ = http.NewRequest("POST", "/records/1/hold", bytes.NewBuffer([]byte(`{"actor":"legal@example.com","reason":"claim 1234"}`)))
+	rr := makeRequestAs(routes, req, "")
+	assert.Equal(t, 200, rr.Code)
+	assert.Contains(t, rr.Body.String(), "\"placed_by\":\"legal@example.com\"")
+}
+
+func TestLogRequests(t *testing.T) {
+	var out bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	router := setUp()
+
+	// the request id of the client is propagated
+	req, _ := http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"name":"Jane Doe"}`)))
+	req.Header.Set(REQUEST_ID_HEADER, "req-1")
+	rr := makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+	assert.Equal(t, "req-1", rr.Header().Get(REQUEST_ID_HEADER))
+	assert.Contains(t, out.String(), "\"request_id\":\"req-1\",\"method\":\"POST\",\"route\":\"/api/v2/records/{id}\",\"status\":200,")
+	assert.Contains(t, out.String(), "\"record_id\":\"1\"")
+	// record payloads are redacted, down to the database layer
+	assert.Contains(t, out.String(), "\"msg\":\"insert record\",\"request_id\":\"req-1\"")
+	assert.NotContains(t, out.String(), "Jane Doe")
+
+	// otherwise one is assigned
+	out.Reset()
+	req, _ = http.NewRequest("GET", "/api/v2/records/1/1", nil)
+	req.Header.Set(REQUEST_ID_HEADER, "not a valid id\n")
+	rr = makeRequest(router, req)
+	id := rr.Header().Get(REQUEST_ID_HEADER)
+	assert.Len(t, id, 32)
+	assert.Contains(t, out.String(), "\"request_id\":\""+id+"\",\"method\":\"GET\",\"route\":\"/api/v2/records/{id}/{version}\",\"status\":200,")
+	assert.Contains(t, out.String(), "\"version\":\"1\"")
+
+	// requests no route matches are logged and get a request id too
+	out.Reset()
+	req, _ = http.NewRequest("GET", "/api/v2/nope", nil)
+	rr = makeRequest(router, req)
+	assert.Equal(t, 404, rr.Code)
+	id = rr.Header().Get(REQUEST_ID_HEADER)
+	assert.Len(t, id, 32)
+	assert.Contains(t, out.String(), "\"request_id\":\""+id+"\",\"method\":\"GET\",\"route\":\"/api/v2/nope\",\"status\":404,")
+	out.Reset()
+	req, _ = http.NewRequest("DELETE", "/api/v2/records/1", nil)
+	rr = makeRequest(router, req)
+	assert.Equal(t, 405, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get(REQUEST_ID_HEADER))
+	assert.Contains(t, out.String(), "\"method\":\"DELETE\",\"route\":\"/api/v2/records/1\",\"status\":405,")
+}
+
+func TestMetrics(t *testing.T) {
+	router := setUp()
+	req, _ := http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"hello":"world"}`)))
+	makeRequest(router, req)
+	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"hello":"world 2"}`)))
+	makeRequest(router, req)
+	req, _ = http.NewRequest("GET", "/api/v2/records/1/1", nil)
+	makeRequest(router, req)
+
+	// the versions per record are only read when refreshed, not on every scrape
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	rr := makeRequest(router, req)
+	assert.Contains(t, rr.Body.String(), "timetravel_record_versions_count 0\n")
+	assert.NoError(t, versionCounts.Refresh(context.Background()))
+
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	rr = makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, "timetravel_http_requests_total{method=\"POST\",route=\"/api/v2/records/{id}\",status=\"200\"}")
+	assert.Contains(t, body, "timetravel_http_request_duration_seconds_bucket{method=\"GET\",route=\"/api/v2/records/{id}/{version}\",status=\"200\",le=\"+Inf\"}")
+	assert.Contains(t, body, "timetravel_record_lookups_total{kind=\"version\"}")
+	assert.Contains(t, body, "timetravel_db_query_duration_seconds_count{op=\"insert_record\"}")
+	assert.Contains(t, body, "timetravel_db_open_connections ")
+	// only this test's database is counted: one record with two versions
+	assert.Contains(t, body, "timetravel_record_versions_bucket{le=\"1\"} 0\ntimetravel_record_versions_bucket{le=\"2\"} 1\n")
+}
+
+func TestHealth(t *testing.T) {
+	router := setUp()
+	req, _ := http.NewRequest("GET", "/api/v1/health/live", nil)
+	rr := makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+	assert.Equal(t, "{\"ok\":true,\"status\":\"ok\"}\n", rr.Body.String())
+
+	for _, path := range []string{"/api/v1/health", "/api/v2/health/ready"} {
+		req, _ = http.NewRequest("GET", path, nil)
+		rr = makeRequest(router, req)
+		assert.Equal(t, 200, rr.Code)
+		var report health.Report
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+		assert.True(t, report.OK)
+		assert.Equal(t, health.STATUS_OK, report.Components["database"].Status)
+		assert.Equal(t, health.STATUS_OK, report.Components["migrations"].Status)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	router := setUp()
+	ctx := context.Background()
+	readerKey, err := authenticator.Mint(ctx, "reader@example.com", auth.ROLE_READER, "")
+	assert.NoError(t, err)
+	writerKey, err := authenticator.Mint(ctx, "writer@example.com", auth.ROLE_WRITER, "")
+	assert.NoError(t, err)
+	auditorKey, err := authenticator.Mint(ctx, "auditor@example.com", auth.ROLE_AUDITOR, "")
+	assert.NoError(t, err)
+
+	// without a key, in the format of each api version
+	req, _ := http.NewRequest("GET", "/api/v2/records/1", nil)
+	rr := makeRequestAs(router, req, "")
+	assertProblem(t, rr, 401, CODE_UNAUTHENTICATED)
+	assert.Equal(t, "Bearer", rr.Header().Get("WWW-Authenticate"))
+	req, _ = http.NewRequest("GET", "/api/v1/records/1", nil)
+	rr = makeRequestAs(router, req, "tt_unknown")
+	assert.Equal(t, 401, rr.Code)
+	assert.Equal(t, "{\"error\":\"missing, unknown or revoked api key\"}\n", rr.Body.String())
+
+	// health checks are public
+	req, _ = http.NewRequest("GET", "/api/v2/health/live", nil)
+	rr = makeRequestAs(router, req, "")
+	assert.Equal(t, 200, rr.Code)
+
+	// writers write, and are recorded as the author of the version
+	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"hello":"world"}`)))
+	rr = makeRequestAs(router, req, readerKey)
+	assertProblem(t, rr, 403, CODE_FORBIDDEN)
+	req, _ = http.NewRequest("POST", "/api/v1/records/1", bytes.NewBuffer([]byte(`{"hello":"world"}`)))
+	req.Header.Set(API_KEY_HEADER, writerKey)
+	rr = makeRequestAs(router, req, "")
+	assert.Equal(t, 200, rr.Code)
+	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"hello":"world 2"}`)))
+	rr = makeRequestAs(router, req, adminKey)
+	assert.Equal(t, 200, rr.Code)
+
+	// readers only see the current state, auditors can travel in time
+	req, _ = http.NewRequest("GET", "/api/v2/records/1", nil)
+	rr = makeRequestAs(router, req, readerKey)
+	assert.Equal(t, 200, rr.Code)
+	req, _ = http.NewRequest("GET", "/api/v2/records/1/1", nil)
+	rr = makeRequestAs(router, req, readerKey)
+	assertProblem(t, rr, 403, CODE_FORBIDDEN)
+	req, _ = http.NewRequest("GET", "/api/v2/records/1/1", nil)
+	rr = makeRequestAs(router, req, auditorKey)
+	assert.Equal(t, 200, rr.Code)
+	req, _ = http.NewRequest("POST", "/api/v2/admin/compact", nil)
+	rr = makeRequestAs(router, req, auditorKey)
+	assertProblem(t, rr, 403, CODE_FORBIDDEN)
+
+	// revoked keys are rejected
+	assert.NoError(t, authenticator.Revoke(ctx, "writer@example.com"))
+	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"hello":"world 3"}`)))
+	rr = makeRequestAs(router, req, writerKey)
+	assertProblem(t, rr, 401, CODE_UNAUTHENTICATED)
+
+	db, err := database.CreateConnection(database.Options{File: database.DATABASE_FILE_UNIT_TEST, JournalMode: "DELETE", Synchronous: "FULL"})
+	assert.NoError(t, err)
+	defer db.Close()
+	history, err := database.GetRecordHistory(ctx, db, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "writer@example.com", history[0].Author)
+	assert.Equal(t, "admin@example.com", history[1].Author)
+}
+
+func TestLimits(t *testing.T) {
+	router := setUp()
+	post := func(path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, bytes.NewBuffer([]byte(body)))
+		return makeRequest(router, req)
+	}
+	long := strings.Repeat("x", 65)
+
+	rr := post("/api/v2/records/1", `{"padding":"`+strings.Repeat("x", 1024)+`"}`)
+	assertProblem(t, rr, 413, CODE_BODY_TOO_LARGE)
+	rr = post("/api/v1/records/1", `{"padding":"`+strings.Repeat("x", 1024)+`"}`)
+	assert.Equal(t, 413, rr.Code)
+	assert.Equal(t, "{\"error\":\"request body is too large: at most 1024 bytes are allowed\"}\n", rr.Body.String())
+
+	rr = post("/api/v2/records/1", `{"a":"1","b":"2","c":"3","d":"4","e":"5","f":"6","g":"7","h":"8","i":"9"}`)
+	problem := assertProblem(t, rr, 422, CODE_TOO_MANY_KEYS)
+	assert.Equal(t, "update has too many keys: 9 keys, at most 8 are allowed", problem.Detail)
+	rr = post("/api/v2/records/1", `{"`+strings.Repeat("k", 17)+`":"1"}`)
+	assertProblem(t, rr, 422, CODE_KEY_TOO_LONG)
+	rr = post("/api/v2/records/1", `{"a":"`+long+`"}`)
+	assertProblem(t, rr, 422, CODE_VALUE_TOO_LONG)
+
+	// a record grows past its size limit across updates, each within the limits
+	value := strings.Repeat("x", 60)
+	rr = post("/api/v2/records/1", `{"a":"`+value+`","b":"`+value+`","c":"`+value+`"}`)
+	assert.Equal(t, 200, rr.Code)
+	rr = post("/api/v2/records/1", `{"d":"`+value+`","e":"`+value+`"}`)
+	assertProblem(t, rr, 413, CODE_RECORD_TOO_LARGE)
+	// deleting keys keeps the record within the limit
+	rr = post("/api/v2/records/1", `{"a":null,"d":"`+value+`"}`)
+	assert.Equal(t, 200, rr.Code)
+
+	for version := 3; version <= limits.MaxVersions; version++ {
+		rr = post("/api/v2/records/1", fmt.Sprintf(`{"a":"%d"}`, version))
+		assert.Equal(t, 200, rr.Code)
+	}
+	rr = post("/api/v2/records/1", `{"a":"one too many"}`)
+	assertProblem(t, rr, 422, CODE_TOO_MANY_VERSIONS)
+}
+
+func TestTenants(t *testing.T) {
+	router := setUp()
+	ctx := context.Background()
+	acmeKey, err := authenticator.Mint(ctx, "writer@acme.example.com", auth.ROLE_WRITER, "acme")
+	assert.NoError(t, err)
+	globexKey, err := authenticator.Mint(ctx, "writer@globex.example.com", auth.ROLE_WRITER, "globex")
+	assert.NoError(t, err)
+
+	// record ids are unique per tenant
+	req, _ := http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"owner":"acme"}`)))
+	rr := makeRequestAs(router, req, acmeKey)
+	assert.Equal(t, 200, rr.Code)
+	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"owner":"globex"}`)))
+	rr = makeRequestAs(router, req, globexKey)
+	assert.Equal(t, 200, rr.Code)
+
+	req, _ = http.NewRequest("GET", "/api/v2/records/1", nil)
+	rr = makeRequestAs(router, req, acmeKey)
+	assert.Equal(t, 200, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"owner":"acme"`)
+	req, _ = http.NewRequest("GET", "/api/v1/records/1", nil)
+	rr = makeRequestAs(router, req, globexKey)
+	assert.Equal(t, 200, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"owner":"globex"`)
+
+	// admin keys bound to no tenant pick one with the header, the default tenant otherwise
+	req, _ = http.NewRequest("GET", "/api/v2/records/1", nil)
+	rr = makeRequest(router, req)
+	assertProblem(t, rr, 404, CODE_RECORD_NOT_FOUND)
+	req, _ = http.NewRequest("GET", "/api/v2/records/1", nil)
+	req.Header.Set(TENANT_HEADER, "acme")
+	rr = makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"owner":"acme"`)
+
+	// keys bound to a tenant cannot reach another one
+	req, _ = http.NewRequest("GET", "/api/v2/records/1", nil)
+	req.Header.Set(TENANT_HEADER, "globex")
+	rr = makeRequestAs(router, req, acmeKey)
+	assertProblem(t, rr, 403, CODE_FORBIDDEN)
+
+	// keys bound to no tenant act on the default one, only admins pick another
+	writerKey, err := authenticator.Mint(ctx, "writer@example.com", auth.ROLE_WRITER, "")
+	assert.NoError(t, err)
+	req, _ = http.NewRequest("GET", "/api/v2/records/1", nil)
+	req.Header.Set(TENANT_HEADER, "acme")
+	rr = makeRequestAs(router, req, writerKey)
+	assertProblem(t, rr, 403, CODE_FORBIDDEN)
+	req, _ = http.NewRequest("GET", "/api/v2/records/1", nil)
+	req.Header.Set(TENANT_HEADER, tenant.DEFAULT)
+	rr = makeRequestAs(router, req, writerKey)
+	assertProblem(t, rr, 404, CODE_RECORD_NOT_FOUND)
+	req, _ = http.NewRequest("GET", "/api/v2/records/1", nil)
+	req.Header.Set(TENANT_HEADER, "acme/../globex")
+	rr = makeRequest(router, req)
+	assertProblem(t, rr, 400, CODE_INVALID_TENANT)
+
+	// a tenant is exported and deleted on its own, not while any of its records is held
+	req, _ = http.NewRequest("GET", "/api/v2/admin/tenant/export", nil)
+	req.Header.Set(TENANT_HEADER, "globex")
+	rr = makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+	var export tenantExport
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &export))
+	assert.Equal(t, "globex", export.Tenant)
+	assert.Len(t, export.Records, 1)
+	assert.Equal(t, "globex", export.Records[0].Data["owner"])
+
+	hold := `{"actor":"legal@example.com","reason":"case 42"}`
+	req, _ = http.NewRequest("POST", "/api/v2/admin/records/1/hold", bytes.NewBuffer([]byte(hold)))
+	req.Header.Set(TENANT_HEADER, "acme")
+	rr = makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+	req, _ = http.NewRequest("DELETE", "/api/v2/admin/tenant", nil)
+	req.Header.Set(TENANT_HEADER, "acme")
+	rr = makeRequest(router, req)
+	assertProblem(t, rr, 409, CODE_RECORD_ON_HOLD)
+
+	req, _ = http.NewRequest("DELETE", "/api/v2/admin/records/1/hold", bytes.NewBuffer([]byte(hold)))
+	req.Header.Set(TENANT_HEADER, "acme")
+	rr = makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+	req, _ = http.NewRequest("DELETE", "/api/v2/admin/tenant", nil)
+	req.Header.Set(TENANT_HEADER, "acme")
+	rr = makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+	assert.Equal(t, "{\"deleted\":1,\"tenant\":\"acme\"}\n", rr.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/v2/records/1", nil)
+	rr = makeRequestAs(router, req, acmeKey)
+	assertProblem(t, rr, 404, CODE_RECORD_NOT_FOUND)
+	req, _ = http.NewRequest("GET", "/api/v2/records/1", nil)
+	rr = makeRequestAs(router, req, globexKey)
+	assert.Equal(t, 200, rr.Code)
+}
+
+func TestBackup(t *testing.T) {
+	router := setUp()
+	t.Cleanup(func() { os.RemoveAll(backupDir) })
+
+	req, _ := http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"hello":"world"}`)))
+	rr := makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+	req, _ = http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(`{"hello":"again"}`)))
+	rr = makeRequest(router, req)
+	assert.Equal(t, 200, rr.Code)
+
+	req, _ = http.NewRequest("POST", "/api/v2/admin/backup", nil)
+	rr = makeRequest(router, req)
+	assert.Equal(t, 201, rr.Code)
+	var backup entity.Backup
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &backup))
+	assert.Equal(t, backupDir, filepath.Dir(backup.File))
+	assert.True(t, strings.HasPrefix(filepath.Base(backup.File), "rainbow_test-"))
+	assert.NotEmpty(t, backup.TakenAt)
+	assert.Equal(t, 2, backup.Rows["records"])
+	assert.Equal(t, 1, backup.Rows["api_keys"])
+
+	// the backup is a database of its own
+	verified, err := database.VerifyDatabase(context.Background(), backup.File)
+	assert.NoError(t, err)
+	assert.Equal(t, backup.Rows, verified.Rows)
+
+	// record services without online backups
+	adminAPI := NewAdminAPI(nil, nil, nil, nil)
+	routes := mux.NewRouter()
+	adminAPI.CreateRoutes(routes)
+	req, _ = http.NewRequest("POST", "/backup", nil)
+	rr = makeRequestAs(routes, req, "")
+	assertProblem(t, rr, 501, CODE_NOT_IMPLEMENTED)
+}
+
+// the OpenAPI document lists every route of the router and nothing else
+// graphQLResponse is the body of a POST /api/v2/graphql response
+type graphQLResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+// postGraphQL sends the query as key, and decodes the response
+func postGraphQL(t *testing.T, router *mux.Router, key string, query string, variables map[string]interface{}) (*httptest.ResponseRecorder, graphQLResponse) {
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	assert.NoError(t, err)
+	req, _ := http.NewRequest("POST", "/api/v2/graphql", bytes.NewBuffer(body))
+	rr := makeRequestAs(router, req, key)
+	var response graphQLResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	return rr, response
+}
+
+func TestGraphQL(t *testing.T) {
+	router := setUp()
+	for _, body := range []string{`{"status":"quoted"}`, `{"status":"bound","premium":"100"}`, `{"premium":"120"}`, `{"status":null}`} {
+		req, _ := http.NewRequest("POST", "/api/v2/records/1", bytes.NewBuffer([]byte(body)))
+		assert.Equal(t, 200, makeRequest(router, req).Code)
+	}
+
+	// the record, its last versions and the changes of a field in one round trip
+	rr, response := postGraphQL(t, router, adminKey, `{
+		record(id: 1) {
+			id version value(key: "premium")
+			data { key value }
+			versions(last: 2) { version value(key: "premium") }
+			fieldChanges(key: "status") { version from to }
+		}
+	}`, nil)
+	assert.Equal(t, 200, rr.Code)
+	assert.Empty(t, response.Errors)
+	assert.Equal(t, map[string]interface{}{
+		"id":      float64(1),
+		"version": float64(4),
+		"value":   "120",
+		"data":    []interface{}{map[string]interface{}{"key": "premium", "value": "120"}},
+		"versions": []interface{}{
+			map[string]interface{}{"version": float64(4), "value": "120"},
+			map[string]interface{}{"version": float64(3), "value": "120"},
+		},
+		"fieldChanges": []interface{}{
+			map[string]interface{}{"version": float64(4), "from": "bound", "to": nil},
+			map[string]interface{}{"version": float64(2), "from": "quoted", "to": "bound"},
+			map[string]interface{}{"version": float64(1), "from": nil, "to": "quoted"},
+		},
+	}, response.Data["record"])
+
+	// the next page, and versions as of a past version
+	_, response = postGraphQL(t, router, adminKey, `query($before: Int) {
+		record(id: 1, version: 3) { version versions(last: 2, before: $before) { version } }
+	}`, map[string]interface{}{"before": 3})
+	assert.Empty(t, response.Errors)
+	assert.Equal(t, map[string]interface{}{
+		"version":  float64(3),
+		"versions": []interface{}{map[string]interface{}{"version": float64(2)}, map[string]interface{}{"version": float64(1)}},
+	}, response.Data["record"])
+
+	// as of a time, records that do not exist are null
+	at := time.Now().UTC().Add(time.Hour).Format(time.RFC3339)
+	_, response = postGraphQL(t, router, adminKey, `query($at: String) { records(ids: [1, 2], at: $at) { version } }`, map[string]interface{}{"at": at})
+	assert.Empty(t, response.Errors)
+	assert.Equal(t, []interface{}{map[string]interface{}{"version": float64(4)}, nil}, response.Data["records"])
+
+	// invalid arguments are reported with the problem code
+	_, response = postGraphQL(t, router, adminKey, `{ record(id: 1) { versions(last: 1000) { version } } }`, nil)
+	assert.Equal(t, 1, len(response.Errors))
+	assert.Equal(t, CODE_INVALID_PARAMETER, response.Errors[0].Extensions["code"])
+	_, response = postGraphQL(t, router, adminKey, `{ record(id: 1, version: 1, at: "2024-01-01T00:00:00Z") { id } }`, nil)
+	assert.Equal(t, 1, len(response.Errors))
+	assert.Equal(t, CODE_INVALID_PARAMETER, response.Errors[0].Extensions["code"])
+
+	// readers only see the current state
+	readerKey, err := authenticator.Mint(context.Background(), "reader@example.com", auth.ROLE_READER, "")
+	assert.NoError(t, err)
+	_, response = postGraphQL(t, router, readerKey, `{ record(id: 1) { version } }`, nil)
+	assert.Empty(t, response.Errors)
+	_, response = postGraphQL(t, router, readerKey, `{ record(id: 1) { versions { version } } }`, nil)
+	assert.Equal(t, 1, len(response.Errors))
+	assert.Equal(t, CODE_FORBIDDEN, response.Errors[0].Extensions["code"])
+
+	// queries that do not parse, and bodies that are not json
+	rr, response = postGraphQL(t, router, adminKey, `{ record(id: 1) { nope } }`, nil)
+	assert.Equal(t, 400, rr.Code)
+	assert.Equal(t, 1, len(response.Errors))
+	req, _ := http.NewRequest("POST", "/api/v2/graphql", bytes.NewBuffer([]byte(`{`)))
+	assertProblem(t, makeRequest(router, req), 400, CODE_INVALID_INPUT)
+}
+
+func TestGraphQLComplexity(t *testing.T) {
+	router := setUp()
+
+	// every version of many records is refused before it runs, literal or variable
+	rr, response := postGraphQL(t, router, adminKey, `query($last: Int = 100) {
+		records(ids: [1, 2, 3, 4, 5, 6, 7, 8, 9, 10]) { versions(last: $last) { version data { key value } } }
+	}`, nil)
+	assert.Equal(t, 400, rr.Code)
+	assert.Nil(t, response.Data)
+	assert.Equal(t, 1, len(response.Errors))
+	assert.Equal(t, CODE_QUERY_TOO_COMPLEX, response.Errors[0].Extensions["code"])
+
+	_, response = postGraphQL(t, router, adminKey, `query($ids: [Int!]!) { records(ids: $ids) { ...changes } }
+		fragment changes on Record { fieldChanges(key: "status", last: 50) { from to } }`, map[string]interface{}{"ids": []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}})
+	assert.Equal(t, 1, len(response.Errors))
+	assert.Equal(t, CODE_QUERY_TOO_COMPLEX, response.Errors[0].Extensions["code"])
+
+	// a page of a few records is not
+	rr, response = postGraphQL(t, router, adminKey, `{ records(ids: [1, 2]) { versions(last: 20) { version data { key value } } } }`, nil)
+	assert.Equal(t, 200, rr.Code)
+	assert.Empty(t, response.Errors)
+}
+
+// countingRecords counts the versions read through the record service
+type countingRecords struct {
+	service.RecordService
+	histories int
+	versions  int
+}
+
+func (c *countingRecords) GetRecordHistory(ctx context.Context, id int) ([]entity.Record, error) {
+	c.histories++
+	return c.RecordService.GetRecordHistory(ctx, id)
+}
+
+func (c *countingRecords) GetRecordAtVersion(ctx context.Context, id int, version int) (entity.Record, error) {
+	c.versions++
+	return c.RecordService.GetRecordAtVersion(ctx, id, version)
+}
+
+func TestGraphQLReads(t *testing.T) {
+	ctx := context.Background()
+	records := &countingRecords{RecordService: service.NewInMemoryRecordService(service.DefaultLimits())}
+	assert.NoError(t, records.CreateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"status": "quoted"}}))
+	for i := 0; i < 100; i++ {
+		premium := fmt.Sprint(i)
+		_, err := records.UpdateRecord(ctx, 1, map[string]*string{"premium": &premium})
+		assert.NoError(t, err)
+	}
+	ids := make([]int, GRAPHQL_MAX_PAGE_SIZE)
+	for i := range ids {
+		ids[i] = 1
+	}
+
+	// the last version of many records reads one version of each, not their histories
+	result := runGraphQL(ctx, records, graphQLRequest{
+		Query:     `query($ids: [Int!]!) { records(ids: $ids) { versions(last: 2) { version } } }`,
+		Variables: map[string]interface{}{"ids": ids},
+	})
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, 0, records.histories)
+	assert.Equal(t, len(ids), records.versions)
+
+	// the changes of a field read every version, too many of them are refused as the query runs
+	result = runGraphQL(ctx, records, graphQLRequest{
+		Query:     `query($ids: [Int!]!) { records(ids: $ids) { fieldChanges(key: "status", last: 1) { version } } }`,
+		Variables: map[string]interface{}{"ids": ids},
+	})
+	if assert.NotEmpty(t, result.Errors) {
+		assert.Equal(t, CODE_QUERY_TOO_COMPLEX, result.Errors[0].Extensions["code"])
+	}
+	assert.Less(t, records.histories, len(ids))
+
+	records.histories = 0
+	result = runGraphQL(ctx, records, graphQLRequest{
+		Query: `{ record(id: 1) { fieldChanges(key: "status", last: 1) { version } } }`,
+	})
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, 1, records.histories)
+}
+
+func TestOpenAPI(t *testing.T) {
+	router := setUp()
+	req, _ := http.NewRequest("GET", "/api/v2/openapi.json", nil)
+	rr := makeRequestAs(router, req, "")
+	assert.Equal(t, 200, rr.Code)
+
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &spec))
+	assert.Equal(t, "3.0.3", spec.OpenAPI)
+
+	registered := map[string]bool{}
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil { // a subrouter prefix
+			return nil
+		}
+		for _, method := range methods {
+			method = strings.ToLower(method)
+			registered[method+" "+path] = true
+			operation, ok := spec.Paths[path][method]
+			if !assert.True(t, ok, "%s %s is missing from openapi.json", method, path) {
+				continue
+			}
+			if name := route.GetName(); name != "" {
+				var op struct {
+					OperationID string `json:"operationId"`
+				}
+				assert.NoError(t, json.Unmarshal(operation, &op))
+				assert.Equal(t, name, op.OperationID, "%s %s", method, path)
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	for path, item := range spec.Paths {
+		for method := range item {
+			if method == "parameters" {
+				continue
+			}
+			assert.True(t, registered[method+" "+path], "%s %s is in openapi.json but not routed", method, path)
+		}
+	}
+}
+
+// every named route requires a permission
+func TestRoutePermissions(t *testing.T) {
+	router := setUp()
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if name := route.GetName(); name != "" {
+			_, ok := routePermissions[name]
+			assert.True(t, ok, name)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/chauvm/timetravel/auth"
+	"github.com/chauvm/timetravel/logging"
+	"github.com/gorilla/mux"
+)
+
+// API_KEY_HEADER carries the api key, as an alternative to Authorization: Bearer <key>
+const API_KEY_HEADER = "X-API-Key"
+
+// problem codes of authentication and authorization
+const (
+	CODE_UNAUTHENTICATED = "unauthenticated"
+	CODE_FORBIDDEN       = "forbidden"
+)
+
+// routePermissions is the permission each named route requires. Routes without a name,
+// such as the health checks and metrics, are public.
+var routePermissions = map[string]string{
+	"v1.get_record":  auth.PERMISSION_READ,
+	"v1.post_record": auth.PERMISSION_WRITE,
+
+	"v2.get_record":             auth.PERMISSION_READ,
+	"v2.post_record":            auth.PERMISSION_WRITE,
+	"v2.get_versions":           auth.PERMISSION_TIME_TRAVEL,
+	"v2.get_rate":               auth.PERMISSION_TIME_TRAVEL,
+	"v2.get_record_at_version":  auth.PERMISSION_TIME_TRAVEL,
+	"v2.get_record_history":     auth.PERMISSION_TIME_TRAVEL,
+	"v2.get_record_at_time":     auth.PERMISSION_TIME_TRAVEL,
+	"v2.get_retroactive_report": auth.PERMISSION_TIME_TRAVEL,
+	// the resolvers of past versions check time_travel themselves
+	"v2.post_graphql": auth.PERMISSION_READ,
+
+	"admin.post_compact":      auth.PERMISSION_ADMIN,
+	"admin.get_legal_hold":    auth.PERMISSION_ADMIN,
+	"admin.post_legal_hold":   auth.PERMISSION_ADMIN,
+	"admin.delete_legal_hold": auth.PERMISSION_ADMIN,
+	"admin.get_tenant_export": auth.PERMISSION_ADMIN,
+	"admin.delete_tenant":     auth.PERMISSION_ADMIN,
+	"admin.post_backup":       auth.PERMISSION_ADMIN,
+}
+
+// Authenticate requires an api key whose role grants the permission of the route, and
+// puts its principal in the request context. It is a mux middleware, register it with
+// router.Use before ResolveTenant.
+func Authenticate(authenticator *auth.Authenticator) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := ""
+			if route := mux.CurrentRoute(r); route != nil {
+				name = route.GetName()
+			}
+			if name == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// a named route missing from the table is denied rather than left open
+			permission, known := routePermissions[name]
+
+			principal, err := authenticator.Authenticate(r.Context(), apiKey(r))
+			if errors.Is(err, auth.ErrUnauthenticated) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeAuthError(w, r, name, CODE_UNAUTHENTICATED, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				writeServiceProblem(w, r, err)
+				return
+			}
+			if !known || !auth.Allowed(principal.Role, permission) {
+				logging.FromContext(r.Context()).Warn("permission denied", "principal", principal.Name, "role", principal.Role, "route", name)
+				writeAuthError(w, r, name, CODE_FORBIDDEN, "the role "+principal.Role+" may not "+permission, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// apiKey returns the key sent with the request, if any
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get(API_KEY_HEADER); key != "" {
+		return key
+	}
+	if value := r.Header.Get("Authorization"); strings.HasPrefix(value, "Bearer ") {
+		return strings.TrimPrefix(value, "Bearer ")
+	}
+	return ""
+}
+
+// writeAuthError keeps the error format of the route's api version
+func writeAuthError(w http.ResponseWriter, r *http.Request, routeName string, code string, detail string, statusCode int) {
+	var err error
+	if strings.HasPrefix(routeName, "v1.") {
+		err = writeError(w, detail, statusCode)
+	} else {
+		err = writeProblem(w, r, code, detail, statusCode)
+	}
+	logError(err)
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chauvm/timetravel/rating"
+	"github.com/gorilla/mux"
+)
+
+// v2 GET /records/{id}/rate
+// GetRate evaluates a rater against the record, either
+//   - at a version: ?version=3
+//   - at a point in time: ?at=2024-03-01T00:00:00Z
+//   - across the exposure periods of a window: ?from=2024-01-01T00:00:00Z&to=2025-01-01T00:00:00Z
+//
+// The rater defaults to the example rater, another one can be picked with ?rater=name.
+func (a *APIV2) GetRate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	idNumber, err := strconv.ParseInt(id, 10, 32)
+
+	if err != nil || idNumber <= 0 {
+		err := writeProblem(w, r, CODE_INVALID_ID, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	raterName := query.Get("rater")
+	if raterName == "" {
+		raterName = rating.DEFAULT_RATER
+	}
+	rater, err := a.raters.Get(raterName)
+	if err != nil {
+		err := writeProblem(w, r, CODE_RATER_NOT_FOUND, fmt.Sprintf("rater %q does not exist", raterName), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	var result interface{}
+	switch {
+	case query.Get("version") != "":
+		versionNumber, errParse := strconv.ParseInt(query.Get("version"), 10, 32)
+		if errParse != nil || versionNumber <= 0 {
+			err := writeProblem(w, r, CODE_INVALID_VERSION, "invalid version; version must be a positive number", http.StatusBadRequest)
+			logError(err)
+			return
+		}
+		result, err = rating.AtVersion(ctx, a.records, rater, int(idNumber), int(versionNumber))
+	case query.Get("at") != "":
+		at, errParse := time.Parse(time.RFC3339, query.Get("at"))
+		if errParse != nil {
+			err := writeProblem(w, r, CODE_INVALID_PARAMETER, "invalid at; at must be an RFC 3339 timestamp", http.StatusBadRequest)
+			logError(err)
+			return
+		}
+		result, err = rating.AtTime(ctx, a.records, rater, int(idNumber), at)
+	case query.Get("from") != "" || query.Get("to") != "":
+		from, errFrom := time.Parse(time.RFC3339, query.Get("from"))
+		to, errTo := time.Parse(time.RFC3339, query.Get("to"))
+		if errFrom != nil || errTo != nil || !to.After(from) {
+			err := writeProblem(w, r, CODE_INVALID_PARAMETER, "invalid window; from and to must be RFC 3339 timestamps with from before to", http.StatusBadRequest)
+			logError(err)
+			return
+		}
+		result, err = rating.OverWindow(ctx, a.records, rater, int(idNumber), from, to)
+	default:
+		err := writeProblem(w, r, CODE_INVALID_PARAMETER, "one of version, at, or from and to is required", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	if err != nil {
+		writeServiceProblem(w, r, err)
+		return
+	}
+
+	err = writeJSON(w, result, http.StatusOK)
+	logError(err)
+}
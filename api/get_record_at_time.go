@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// v2 GET /records/{id}/at?time=2024-03-01T00:00:00Z
+// GetRecordAtTime retrieves the record as it was at the time, i.e. its latest version
+// recorded at or before it.
+func (a *APIV2) GetRecordAtTime(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	idNumber, err := strconv.ParseInt(id, 10, 32)
+
+	if err != nil || idNumber <= 0 {
+		err := writeProblem(w, r, CODE_INVALID_ID, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	at, err := time.Parse(time.RFC3339, r.URL.Query().Get("time"))
+	if err != nil {
+		err := writeProblem(w, r, CODE_INVALID_PARAMETER, "invalid time; time must be an RFC 3339 timestamp", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	record, err := a.records.GetRecordAtTime(ctx, int(idNumber), at)
+	if err != nil {
+		writeServiceProblem(w, r, err)
+		return
+	}
+
+	returnedRecord := record.GetExternalRecord()
+
+	err = writeJSON(w, returnedRecord, http.StatusOK)
+	logError(err)
+}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"fmt"
 	"net/http"
 	"strconv"
 
@@ -19,7 +18,7 @@ func (a *APIV2) GetRecordAtVersion(w http.ResponseWriter, r *http.Request) {
 	idNumber, err := strconv.ParseInt(id, 10, 32)
 
 	if err != nil || idNumber <= 0 {
-		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		err := writeProblem(w, r, CODE_INVALID_ID, "invalid id; id must be a positive number", http.StatusBadRequest)
 		logError(err)
 		return
 	}
@@ -27,7 +26,7 @@ func (a *APIV2) GetRecordAtVersion(w http.ResponseWriter, r *http.Request) {
 	versionNumber, err := strconv.ParseInt(version, 10, 32)
 
 	if err != nil || versionNumber <= 0 {
-		err := writeError(w, "invalid version; version must be a positive number", http.StatusBadRequest)
+		err := writeProblem(w, r, CODE_INVALID_VERSION, "invalid version; version must be a positive number", http.StatusBadRequest)
 		logError(err)
 		return
 	}
@@ -38,8 +37,7 @@ func (a *APIV2) GetRecordAtVersion(w http.ResponseWriter, r *http.Request) {
 		int(versionNumber),
 	)
 	if err != nil {
-		err := writeError(w, fmt.Sprintf("Unable to retrieve record of id %d at version %d", idNumber, versionNumber), http.StatusBadRequest)
-		logError(err)
+		writeServiceProblem(w, r, err)
 		return
 	}
 
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// v2 GET /records/{id}/history
+// GetRecordHistory lists every version of the record, oldest first, with when and by whom
+// it was recorded.
+func (a *APIV2) GetRecordHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	idNumber, err := strconv.ParseInt(id, 10, 32)
+
+	if err != nil || idNumber <= 0 {
+		err := writeProblem(w, r, CODE_INVALID_ID, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	history, err := a.records.GetRecordHistory(ctx, int(idNumber))
+	if err != nil {
+		writeServiceProblem(w, r, err)
+		return
+	}
+	if len(history) == 0 {
+		err := writeProblem(w, r, CODE_RECORD_NOT_FOUND, "record with that id does not exist", http.StatusNotFound)
+		logError(err)
+		return
+	}
+
+	response := map[string]interface{}{"data": history}
+
+	err = writeJSON(w, response, http.StatusOK)
+	logError(err)
+}
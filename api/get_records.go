@@ -1,10 +1,12 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 
+	"github.com/chauvm/timetravel/service"
 	"github.com/gorilla/mux"
 )
 
@@ -26,10 +28,14 @@ func (a *API) GetRecords(w http.ResponseWriter, r *http.Request) {
 		ctx,
 		int(idNumber),
 	)
-	if err != nil {
+	// v1 clients expect 400 for a missing record, v2 answers 404
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
 		err := writeError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusBadRequest)
 		logError(err)
 		return
+	} else if err != nil {
+		writeServiceError(w, err)
+		return
 	}
 
 	returnedRecord := record.GetExternalRecord()
@@ -46,7 +52,7 @@ func (a *APIV2) GetRecords(w http.ResponseWriter, r *http.Request) {
 	idNumber, err := strconv.ParseInt(id, 10, 32)
 
 	if err != nil || idNumber <= 0 {
-		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		err := writeProblem(w, r, CODE_INVALID_ID, "invalid id; id must be a positive number", http.StatusBadRequest)
 		logError(err)
 		return
 	}
@@ -56,8 +62,7 @@ func (a *APIV2) GetRecords(w http.ResponseWriter, r *http.Request) {
 		int(idNumber),
 	)
 	if err != nil {
-		err := writeError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusBadRequest)
-		logError(err)
+		writeServiceProblem(w, r, err)
 		return
 	}
 
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chauvm/timetravel/service"
+)
+
+// changes reported less than this many days late are not retroactive adjustments
+const DEFAULT_THRESHOLD_DAYS = 30
+
+// v2 GET /reports/retroactive
+// GetRetroactiveReport lists every version whose change occurred more than
+// ?threshold_days (default 30) before it was recorded, with the state billed on
+// during the gap and the state that should have been.
+func (a *APIV2) GetRetroactiveReport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	thresholdDays := DEFAULT_THRESHOLD_DAYS
+	if value := r.URL.Query().Get("threshold_days"); value != "" {
+		days, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || days < 0 {
+			err := writeProblem(w, r, CODE_INVALID_PARAMETER, "invalid threshold_days; threshold_days must be a non-negative number", http.StatusBadRequest)
+			logError(err)
+			return
+		}
+		thresholdDays = int(days)
+	}
+
+	reporter, ok := a.records.(service.RetroactiveReporter)
+	if !ok {
+		err := writeProblem(w, r, CODE_NOT_IMPLEMENTED, "retroactive reports are not supported by this record service", http.StatusNotImplemented)
+		logError(err)
+		return
+	}
+
+	changes, err := reporter.GetRetroactiveChanges(ctx, time.Duration(thresholdDays)*24*time.Hour)
+	if err != nil {
+		writeServiceProblem(w, r, err)
+		return
+	}
+
+	response := map[string]interface{}{"data": changes}
+
+	err = writeJSON(w, response, http.StatusOK)
+	logError(err)
+}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"fmt"
 	"net/http"
 	"strconv"
 
@@ -18,7 +17,7 @@ func (a *APIV2) GetVersions(w http.ResponseWriter, r *http.Request) {
 	idNumber, err := strconv.ParseInt(id, 10, 32)
 
 	if err != nil || idNumber <= 0 {
-		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		err := writeProblem(w, r, CODE_INVALID_ID, "invalid id; id must be a positive number", http.StatusBadRequest)
 		logError(err)
 		return
 	}
@@ -28,8 +27,7 @@ func (a *APIV2) GetVersions(w http.ResponseWriter, r *http.Request) {
 		int(idNumber),
 	)
 	if err != nil {
-		err := writeError(w, fmt.Sprintf("Unable to retrieve versions for record %d", idNumber), http.StatusBadRequest)
-		logError(err)
+		writeServiceProblem(w, r, err)
 		return
 	}
 
@@ -0,0 +1,546 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chauvm/timetravel/auth"
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/logging"
+	"github.com/chauvm/timetravel/service"
+	"github.com/graphql-go/graphql"
+)
+
+// The GraphQL schema of POST /api/v2/graphql, fetching records, their versions and the
+// changes of a field in one round trip:
+//
+//	type Query {
+//	  record(id: Int!, version: Int, at: String): Record
+//	  records(ids: [Int!]!, at: String): [Record]!
+//	}
+//	type Record {
+//	  id: Int!, version: Int!, timestamp: String!, occurredAt: String, author: String
+//	  data: [Entry!]!, value(key: String!): String
+//	  versions(last: Int = 10, before: Int): [Version!]!
+//	  fieldChanges(key: String!, last: Int = 10, before: Int): [FieldChange!]!
+//	}
+//	type Version { version: Int!, timestamp: String!, occurredAt: String, author: String, data: [Entry!]!, value(key: String!): String }
+//	type FieldChange { key: String!, version: Int!, timestamp: String!, author: String, from: String, to: String }
+//	type Entry { key: String!, value: String! }
+//
+// A record that does not exist, or did not at the time asked for, is null. Reading a past
+// version, versions or field changes needs the time_travel permission, as over HTTP.
+// versions reads the versions of its page, fieldChanges every version of the record, and a
+// query reading more than MAX_VERSIONS_READ of them fails.
+
+// GRAPHQL_DEFAULT_PAGE_SIZE is the number of versions or changes returned without last
+const GRAPHQL_DEFAULT_PAGE_SIZE = 10
+
+// GRAPHQL_MAX_PAGE_SIZE bounds last, and the ids of a records query
+const GRAPHQL_MAX_PAGE_SIZE = 100
+
+// graphQLRecord is the source of a Record, a record as of one of its versions
+type graphQLRecord struct {
+	records service.RecordService
+	record  entity.Record
+
+	// the numbers of the versions are listed once, by the first field that needs them
+	numbersOnce sync.Once
+	numbers     []int
+	numbersErr  error
+
+	// the history is read once, by the first fieldChanges
+	historyOnce sync.Once
+	history     []entity.Record
+	historyErr  error
+}
+
+// versionNumbers returns the numbers of the versions of the record up to this one, newest first
+func (g *graphQLRecord) versionNumbers(ctx context.Context) ([]int, error) {
+	g.numbersOnce.Do(func() {
+		numbers, err := g.records.GetRecordVersions(ctx, g.record.ID)
+		if err != nil {
+			g.numbersErr = err
+			return
+		}
+		g.numbers = make([]int, 0, len(numbers))
+		for _, number := range numbers {
+			if number <= g.record.Version {
+				g.numbers = append(g.numbers, number)
+			}
+		}
+	})
+	return g.numbers, g.numbersErr
+}
+
+// version returns the version of the record, counting it against the versions the query may read
+func (g *graphQLRecord) version(ctx context.Context, number int) (entity.Record, error) {
+	if number == g.record.Version {
+		return g.record, nil
+	}
+	if err := chargeVersions(ctx, 1); err != nil {
+		return entity.Record{}, err
+	}
+	return g.records.GetRecordAtVersion(ctx, g.record.ID, number)
+}
+
+// versions returns the versions of the record up to this one, oldest first. It reads every
+// version, and counts them against the versions the query may read before it does.
+func (g *graphQLRecord) versions(ctx context.Context) ([]entity.Record, error) {
+	g.historyOnce.Do(func() {
+		numbers, err := g.versionNumbers(ctx)
+		if err != nil {
+			g.historyErr = err
+			return
+		}
+		if err := chargeVersions(ctx, len(numbers)); err != nil {
+			g.historyErr = err
+			return
+		}
+		history, err := g.records.GetRecordHistory(ctx, g.record.ID)
+		if err != nil {
+			g.historyErr = err
+			return
+		}
+		g.history = make([]entity.Record, 0, len(history))
+		for _, version := range history {
+			if version.Version <= g.record.Version {
+				g.history = append(g.history, version)
+			}
+		}
+	})
+	return g.history, g.historyErr
+}
+
+// graphQLReads counts the versions a query has read, see MAX_VERSIONS_READ
+type graphQLReads struct {
+	mu   sync.Mutex
+	read int
+}
+
+type contextKey int
+
+const readsKey contextKey = iota
+
+// withGraphQLReads returns a context counting the versions the query reads
+func withGraphQLReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readsKey, &graphQLReads{})
+}
+
+// chargeVersions counts versions the query is about to read, and fails if that would be
+// more than MAX_VERSIONS_READ
+func chargeVersions(ctx context.Context, n int) error {
+	reads, ok := ctx.Value(readsKey).(*graphQLReads)
+	if !ok {
+		return nil
+	}
+	reads.mu.Lock()
+	defer reads.mu.Unlock()
+	if reads.read+n > MAX_VERSIONS_READ {
+		return graphQLError{CODE_QUERY_TOO_COMPLEX, fmt.Sprintf("query too complex; it reads more than %d versions, ask for fewer changes or records", MAX_VERSIONS_READ)}
+	}
+	reads.read += n
+	return nil
+}
+
+// graphQLFieldChange is a FieldChange, From is nil where the key was absent, To where it was removed
+type graphQLFieldChange struct {
+	Key       string  `json:"key"`
+	Version   int     `json:"version"`
+	Timestamp string  `json:"timestamp"`
+	Author    *string `json:"author"`
+	From      *string `json:"from"`
+	To        *string `json:"to"`
+}
+
+// graphQLEntry is a key and its value
+type graphQLEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// graphQLError is reported with the problem code as its code extension
+type graphQLError struct {
+	code    string
+	message string
+}
+
+func (e graphQLError) Error() string {
+	return e.message
+}
+
+func (e graphQLError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.code}
+}
+
+// graphQLServiceError reports an error returned by a service like writeServiceProblem does
+func graphQLServiceError(ctx context.Context, err error) error {
+	e := lookupServiceError(err)
+	if e.statusCode >= 500 {
+		logging.FromContext(ctx).Error("graphql resolver failed", "error", err)
+	}
+	return graphQLError{e.code, e.detail(err)}
+}
+
+// graphQLResolveError reports a query that read too many versions as it is, and any other
+// error as an error of the service
+func graphQLResolveError(ctx context.Context, err error) error {
+	var queryErr graphQLError
+	if errors.As(err, &queryErr) {
+		return queryErr
+	}
+	return graphQLServiceError(ctx, err)
+}
+
+// requirePermission checks the role of the principal, if authentication is on
+func requirePermission(ctx context.Context, permission string) error {
+	if principal, ok := auth.PrincipalFrom(ctx); ok && !auth.Allowed(principal.Role, permission) {
+		return graphQLError{CODE_FORBIDDEN, "the role " + principal.Role + " may not " + permission}
+	}
+	return nil
+}
+
+var graphQLEntryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Entry",
+	Fields: graphql.Fields{
+		"key":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"value": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+	},
+})
+
+var graphQLFieldChangeType = graphql.NewObject(graphql.ObjectConfig{
+	Name:        "FieldChange",
+	Description: "A version that set, changed or removed a key.",
+	Fields: graphql.Fields{
+		"key":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"version":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"timestamp": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"author":    &graphql.Field{Type: graphql.String},
+		"from":      &graphql.Field{Type: graphql.String, Description: "The value before, null if the key was absent."},
+		"to":        &graphql.Field{Type: graphql.String, Description: "The value after, null if the key was removed."},
+	},
+})
+
+// versionFields are the fields a Record and a Version share, resolved by get from their source
+func versionFields(get func(source interface{}) entity.Record) graphql.Fields {
+	return graphql.Fields{
+		"version": &graphql.Field{
+			Type:    graphql.NewNonNull(graphql.Int),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) { return get(p.Source).Version, nil },
+		},
+		"timestamp": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.String),
+			Description: "When the version was recorded, RFC 3339.",
+			Resolve:     func(p graphql.ResolveParams) (interface{}, error) { return get(p.Source).Timestamp, nil },
+		},
+		"occurredAt": &graphql.Field{
+			Type:        graphql.String,
+			Description: "When the client reported the change actually happened, if it did.",
+			Resolve:     func(p graphql.ResolveParams) (interface{}, error) { return optional(get(p.Source).OccurredAt), nil },
+		},
+		"author": &graphql.Field{
+			Type:    graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) { return optional(get(p.Source).Author), nil },
+		},
+		"data": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphQLEntryType))),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return entries(get(p.Source).Data), nil
+			},
+		},
+		"value": &graphql.Field{
+			Type:        graphql.String,
+			Description: "The value of a key, null if it is absent.",
+			Args: graphql.FieldConfigArgument{
+				"key": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				value, ok := get(p.Source).Data[p.Args["key"].(string)]
+				if !ok {
+					return nil, nil
+				}
+				return value, nil
+			},
+		},
+	}
+}
+
+var graphQLVersionType = graphql.NewObject(graphql.ObjectConfig{
+	Name:   "Version",
+	Fields: versionFields(func(source interface{}) entity.Record { return source.(entity.Record) }),
+})
+
+// pageArguments are the arguments of the paginated fields, newest first: the last items
+// recorded before a version
+var pageArguments = graphql.FieldConfigArgument{
+	"last": &graphql.ArgumentConfig{
+		Type:         graphql.Int,
+		DefaultValue: GRAPHQL_DEFAULT_PAGE_SIZE,
+		Description:  "How many items to return, at most 100.",
+	},
+	"before": &graphql.ArgumentConfig{
+		Type:        graphql.Int,
+		Description: "Only return items of versions before this one, the version of the last item of the previous page.",
+	},
+}
+
+var graphQLRecordType = graphql.NewObject(graphql.ObjectConfig{
+	Name:        "Record",
+	Description: "A record as of one of its versions.",
+	Fields: (graphql.FieldsThunk)(func() graphql.Fields {
+		fields := versionFields(func(source interface{}) entity.Record { return source.(*graphQLRecord).record })
+		fields["id"] = &graphql.Field{
+			Type:    graphql.NewNonNull(graphql.Int),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source.(*graphQLRecord).record.ID, nil },
+		}
+		fields["versions"] = &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphQLVersionType))),
+			Description: "The versions of the record up to this one, newest first.",
+			Args:        pageArguments,
+			Resolve:     resolveVersions,
+		}
+		fieldChangesArguments := graphql.FieldConfigArgument{
+			"key": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+		}
+		for name, argument := range pageArguments {
+			fieldChangesArguments[name] = argument
+		}
+		fields["fieldChanges"] = &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphQLFieldChangeType))),
+			Description: "The versions up to this one that set, changed or removed the key, newest first.",
+			Args:        fieldChangesArguments,
+			Resolve:     resolveFieldChanges,
+		}
+		return fields
+	}),
+})
+
+var graphQLQueryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Query",
+	Fields: graphql.Fields{
+		"record": &graphql.Field{
+			Type:        graphQLRecordType,
+			Description: "The record, latest unless a version or a time is given.",
+			Args: graphql.FieldConfigArgument{
+				"id":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				"version": &graphql.ArgumentConfig{Type: graphql.Int},
+				"at":      &graphql.ArgumentConfig{Type: graphql.String, Description: "RFC 3339, the version in force at the time."},
+			},
+			Resolve: resolveRecord,
+		},
+		"records": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.NewList(graphQLRecordType)),
+			Description: "The records in the order of the ids, at most 100, latest unless a time is given.",
+			Args: graphql.FieldConfigArgument{
+				"ids": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int)))},
+				"at":  &graphql.ArgumentConfig{Type: graphql.String, Description: "RFC 3339, the versions in force at the time."},
+			},
+			Resolve: resolveRecords,
+		},
+	},
+})
+
+// graphQLSchema is the schema of every query, the root value of a query is the record service
+var graphQLSchema = mustGraphQLSchema()
+
+func mustGraphQLSchema() graphql.Schema {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: graphQLQueryType})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+func resolveRecord(p graphql.ResolveParams) (interface{}, error) {
+	version, hasVersion := p.Args["version"].(int)
+	at, hasAt := p.Args["at"].(string)
+	if hasVersion && hasAt {
+		return nil, graphQLError{CODE_INVALID_PARAMETER, "invalid arguments; pass a version or a time, not both"}
+	}
+	if hasVersion && version <= 0 {
+		return nil, graphQLError{CODE_INVALID_VERSION, "invalid version; version must be a positive number"}
+	}
+	if hasVersion {
+		return lookupRecord(p.Context, p.Source.(service.RecordService), p.Args["id"].(int), version, nil)
+	}
+	return lookupRecordAt(p, p.Args["id"].(int), at, hasAt)
+}
+
+func resolveRecords(p graphql.ResolveParams) (interface{}, error) {
+	ids := p.Args["ids"].([]interface{})
+	if len(ids) > GRAPHQL_MAX_PAGE_SIZE {
+		return nil, graphQLError{CODE_INVALID_PARAMETER, "invalid ids; at most 100 records are fetched at once"}
+	}
+	at, hasAt := p.Args["at"].(string)
+	records := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		record, err := lookupRecordAt(p, id.(int), at, hasAt)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// lookupRecordAt looks the record up as of the time, if there is one
+func lookupRecordAt(p graphql.ResolveParams, id int, at string, hasAt bool) (interface{}, error) {
+	if !hasAt {
+		return lookupRecord(p.Context, p.Source.(service.RecordService), id, 0, nil)
+	}
+	atTime, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return nil, graphQLError{CODE_INVALID_PARAMETER, "invalid at; at must be an RFC 3339 timestamp"}
+	}
+	return lookupRecord(p.Context, p.Source.(service.RecordService), id, 0, &atTime)
+}
+
+// lookupRecord returns the record at the version, or at the time, or else the latest, nil
+// if there is none
+func lookupRecord(ctx context.Context, records service.RecordService, id int, version int, at *time.Time) (interface{}, error) {
+	if id <= 0 {
+		return nil, graphQLError{CODE_INVALID_ID, "invalid id; id must be a positive number"}
+	}
+	var record entity.Record
+	var err error
+	switch {
+	case version > 0:
+		if err := requirePermission(ctx, auth.PERMISSION_TIME_TRAVEL); err != nil {
+			return nil, err
+		}
+		record, err = records.GetRecordAtVersion(ctx, id, version)
+	case at != nil:
+		if err := requirePermission(ctx, auth.PERMISSION_TIME_TRAVEL); err != nil {
+			return nil, err
+		}
+		record, err = records.GetRecordAtTime(ctx, id, *at)
+	default:
+		record, err = records.GetRecord(ctx, id)
+	}
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, graphQLServiceError(ctx, err)
+	}
+	return &graphQLRecord{records: records, record: record}, nil
+}
+
+func resolveVersions(p graphql.ResolveParams) (interface{}, error) {
+	if err := requirePermission(p.Context, auth.PERMISSION_TIME_TRAVEL); err != nil {
+		return nil, err
+	}
+	last, before, err := pageArgs(p)
+	if err != nil {
+		return nil, err
+	}
+	source := p.Source.(*graphQLRecord)
+	numbers, err := source.versionNumbers(p.Context)
+	if err != nil {
+		return nil, graphQLServiceError(p.Context, err)
+	}
+	// only the versions of the page are read
+	page := []entity.Record{}
+	for _, number := range numbers {
+		if len(page) == last {
+			break
+		}
+		if number >= before {
+			continue
+		}
+		version, err := source.version(p.Context, number)
+		if errors.Is(err, service.ErrRecordDoesNotExist) {
+			// compacted since the versions were listed
+			continue
+		}
+		if err != nil {
+			return nil, graphQLResolveError(p.Context, err)
+		}
+		page = append(page, version)
+	}
+	return page, nil
+}
+
+func resolveFieldChanges(p graphql.ResolveParams) (interface{}, error) {
+	if err := requirePermission(p.Context, auth.PERMISSION_TIME_TRAVEL); err != nil {
+		return nil, err
+	}
+	last, before, err := pageArgs(p)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := p.Source.(*graphQLRecord).versions(p.Context)
+	if err != nil {
+		return nil, graphQLResolveError(p.Context, err)
+	}
+	changes := fieldChanges(versions, p.Args["key"].(string))
+	page := []graphQLFieldChange{}
+	for i := len(changes) - 1; i >= 0 && len(page) < last; i-- {
+		if changes[i].Version < before {
+			page = append(page, changes[i])
+		}
+	}
+	return page, nil
+}
+
+// pageArgs checks last and before, before defaults to past every version
+func pageArgs(p graphql.ResolveParams) (int, int, error) {
+	last, _ := p.Args["last"].(int)
+	if last < 1 || last > GRAPHQL_MAX_PAGE_SIZE {
+		return 0, 0, graphQLError{CODE_INVALID_PARAMETER, "invalid last; last must be between 1 and 100"}
+	}
+	before, ok := p.Args["before"].(int)
+	if !ok {
+		return last, int(^uint(0) >> 1), nil
+	}
+	if before <= 0 {
+		return 0, 0, graphQLError{CODE_INVALID_VERSION, "invalid before; before must be a positive version"}
+	}
+	return last, before, nil
+}
+
+// fieldChanges lists the versions that set, changed or removed the key, oldest first
+func fieldChanges(versions []entity.Record, key string) []graphQLFieldChange {
+	changes := []graphQLFieldChange{}
+	var previous *string
+	for _, version := range versions {
+		var current *string
+		if value, ok := version.Data[key]; ok {
+			current = &value
+		}
+		if (previous == nil) != (current == nil) || previous != nil && *previous != *current {
+			changes = append(changes, graphQLFieldChange{
+				Key:       key,
+				Version:   version.Version,
+				Timestamp: version.Timestamp,
+				Author:    optional(version.Author),
+				From:      previous,
+				To:        current,
+			})
+		}
+		previous = current
+	}
+	return changes
+}
+
+// entries lists the keys and values of the data, sorted by key
+func entries(data map[string]string) []graphQLEntry {
+	list := make([]graphQLEntry, 0, len(data))
+	for key, value := range data {
+		list = append(list, graphQLEntry{key, value})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
+// optional is nil for "", for nullable fields
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/chauvm/timetravel/health"
+	"github.com/gorilla/mux"
+)
+
+// HealthAPI serves the liveness and readiness probes, it is mounted under /api/v1 and /api/v2.
+type HealthAPI struct {
+	checker *health.Checker
+}
+
+func NewHealthAPI(checker *health.Checker) *HealthAPI {
+	return &HealthAPI{checker}
+}
+
+// generates the health routes
+func (a *HealthAPI) CreateRoutes(routes *mux.Router) {
+	routes.Path("/health").HandlerFunc(a.GetReady).Methods("GET")
+	routes.Path("/health/live").HandlerFunc(a.GetLive).Methods("GET")
+	routes.Path("/health/ready").HandlerFunc(a.GetReady).Methods("GET")
+}
+
+// GET /health/live
+// GetLive reports the process is up and serving, it does not check any component.
+func (a *HealthAPI) GetLive(w http.ResponseWriter, r *http.Request) {
+	err := writeJSON(w, map[string]interface{}{"ok": true, "status": health.STATUS_OK}, http.StatusOK)
+	logError(err)
+}
+
+// GET /health, GET /health/ready
+// GetReady checks the database, the migrations and the disk headroom, and reports each one.
+// It is a 503 when any of them fails or the server is shutting down.
+func (a *HealthAPI) GetReady(w http.ResponseWriter, r *http.Request) {
+	report := a.checker.Ready(r.Context())
+	statusCode := http.StatusOK
+	if !report.OK {
+		statusCode = http.StatusServiceUnavailable
+	}
+	err := writeJSON(w, report, statusCode)
+	logError(err)
+}
@@ -3,14 +3,20 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+
+	"github.com/chauvm/timetravel/service"
 )
 
 var (
 	ErrInternal = errors.New("internal error")
 )
 
+// RETRY_AFTER_SECONDS is sent with 503 responses, SQLite locks are short-lived
+const RETRY_AFTER_SECONDS = "1"
+
 // logs an error if it's not nil
 func logError(err error) {
 	if err != nil {
@@ -35,3 +41,40 @@ func writeError(w http.ResponseWriter, message string, statusCode int) error {
 		statusCode,
 	)
 }
+
+// writeServiceError writes an error returned by the record service with the matching status.
+// Errors the client cannot act on are logged and written as a generic 500.
+func writeServiceError(w http.ResponseWriter, err error) {
+	e := lookupServiceError(err)
+	if e.statusCode >= http.StatusInternalServerError {
+		logError(err)
+	}
+	if e.statusCode == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", RETRY_AFTER_SECONDS)
+	}
+	errInWriting := writeError(w, e.detail(err), e.statusCode)
+	logError(errInWriting)
+}
+
+// decodeUpdates reads the json updates of a create or update, no larger than the body limit
+// of the record service, if it has one
+func decodeUpdates(w http.ResponseWriter, r *http.Request, records service.RecordService) (map[string]*string, error) {
+	var updates map[string]*string
+	err := decodeJSON(w, r, records, &updates)
+	return updates, err
+}
+
+// decodeJSON reads the json body into out, no larger than the body limit of the record
+// service, if it has one
+func decodeJSON(w http.ResponseWriter, r *http.Request, records service.RecordService, out interface{}) error {
+	if limited, ok := records.(service.LimitedService); ok && limited.Limits().MaxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(limited.Limits().MaxBodyBytes))
+	}
+
+	err := json.NewDecoder(r.Body).Decode(out)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("%w: at most %d bytes are allowed", service.ErrBodyTooLarge, tooLarge.Limit)
+	}
+	return err
+}
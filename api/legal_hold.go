@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/chauvm/timetravel/auth"
+	"github.com/chauvm/timetravel/service"
+	"github.com/gorilla/mux"
+)
+
+// legalHoldRequest says who places or releases a hold and why. The actor is only read from
+// the body when authentication is disabled, otherwise it is the name of the principal, so
+// callers cannot put another name in the audit trail.
+type legalHoldRequest struct {
+	Actor  string `json:"actor"`
+	Reason string `json:"reason"`
+}
+
+// admin POST /records/{id}/hold
+// PostLegalHold places the record under legal hold.
+func (a *AdminAPI) PostLegalHold(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	idNumber, body, ok := parseLegalHoldRequest(w, r)
+	if !ok {
+		return
+	}
+
+	hold, err := a.holds.PlaceLegalHold(ctx, idNumber, body.Actor, body.Reason)
+	if err != nil {
+		writeServiceProblem(w, r, err)
+		return
+	}
+
+	err = writeJSON(w, hold, http.StatusOK)
+	logError(err)
+}
+
+// admin DELETE /records/{id}/hold
+// DeleteLegalHold releases the legal hold on the record.
+func (a *AdminAPI) DeleteLegalHold(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	idNumber, body, ok := parseLegalHoldRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.holds.ReleaseLegalHold(ctx, idNumber, body.Actor, body.Reason)
+	if err != nil {
+		writeServiceProblem(w, r, err)
+		return
+	}
+
+	err = writeJSON(w, map[string]bool{"ok": true}, http.StatusOK)
+	logError(err)
+}
+
+// admin GET /records/{id}/hold
+// GetLegalHold returns the current hold on the record, if any, and every hold event.
+func (a *AdminAPI) GetLegalHold(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 32)
+
+	if err != nil || idNumber <= 0 {
+		err := writeProblem(w, r, CODE_INVALID_ID, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	response := map[string]interface{}{"hold": nil}
+	hold, err := a.holds.GetLegalHold(ctx, int(idNumber))
+	if err == nil {
+		response["hold"] = hold
+	} else if !errors.Is(err, service.ErrLegalHoldDoesNotExist) {
+		writeServiceProblem(w, r, err)
+		return
+	}
+
+	events, err := a.holds.GetLegalHoldEvents(ctx, int(idNumber))
+	if err != nil {
+		writeServiceProblem(w, r, err)
+		return
+	}
+	response["events"] = events
+
+	err = writeJSON(w, response, http.StatusOK)
+	logError(err)
+}
+
+func parseLegalHoldRequest(w http.ResponseWriter, r *http.Request) (int, legalHoldRequest, bool) {
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 32)
+
+	if err != nil || idNumber <= 0 {
+		err := writeProblem(w, r, CODE_INVALID_ID, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return 0, legalHoldRequest{}, false
+	}
+
+	var body legalHoldRequest
+	err = json.NewDecoder(r.Body).Decode(&body)
+
+	if err != nil {
+		err := writeProblem(w, r, CODE_INVALID_INPUT, "invalid input; could not parse json", http.StatusBadRequest)
+		logError(err)
+		return 0, legalHoldRequest{}, false
+	}
+	if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+		body.Actor = principal.Name
+	}
+	return int(idNumber), body, true
+}
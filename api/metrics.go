@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chauvm/timetravel/metrics"
+)
+
+var httpRequests = metrics.Default.NewCounterVec("timetravel_http_requests_total",
+	"HTTP requests served, by route template and status.", "method", "route", "status")
+var httpRequestDuration = metrics.Default.NewHistogramVec("timetravel_http_request_duration_seconds",
+	"Latency of the HTTP requests, by route template and status.", metrics.DURATION_BUCKETS, "method", "route", "status")
+
+// InstrumentRequests counts requests and observes their latency. It is a mux middleware
+// so requests are labelled by route template, register it with router.Use.
+func InstrumentRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		route := routeTemplate(r)
+		status := strconv.Itoa(recorder.statusCode)
+		httpRequests.Inc(r.Method, route, status)
+		httpRequestDuration.Observe(time.Since(start).Seconds(), r.Method, route, status)
+	})
+}
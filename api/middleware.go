@@ -0,0 +1,79 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/chauvm/timetravel/logging"
+	"github.com/gorilla/mux"
+)
+
+// LogRequests assigns an X-Request-ID, or propagates the one sent by the client, and
+// logs one line per request. Wrap the whole router with it, not router.Use, so requests no
+// route matches are logged too; the router is matched again for the route template and the
+// record id and version.
+func LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		r = r.WithContext(logging.WithRequestID(r.Context(), id))
+
+		recorder := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		route, vars := matchRoute(next, r)
+		attrs := []any{
+			"method", r.Method,
+			"route", route,
+			"status", recorder.statusCode,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if recordID, ok := vars["id"]; ok {
+			attrs = append(attrs, "record_id", recordID)
+		}
+		if version, ok := vars["version"]; ok {
+			attrs = append(attrs, "version", version)
+		} else if version := r.URL.Query().Get("version"); version != "" {
+			attrs = append(attrs, "version", version)
+		}
+		logging.FromContext(r.Context()).Info("request", attrs...)
+	})
+}
+
+// matchRoute returns the path template and the variables of the route of the router the
+// request matches, and the path when there is none
+func matchRoute(handler http.Handler, r *http.Request) (string, map[string]string) {
+	var match mux.RouteMatch
+	if router, ok := handler.(*mux.Router); ok && router.Match(r, &match) && match.Route != nil {
+		if template, err := match.Route.GetPathTemplate(); err == nil {
+			return template, match.Vars
+		}
+	}
+	return r.URL.Path, nil
+}
+
+// routeTemplate returns the path template of the matched route, so requests for
+// different records are logged under the same route
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
+
+// statusRecorder remembers the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (s *statusRecorder) WriteHeader(statusCode int) {
+	s.statusCode = statusCode
+	s.ResponseWriter.WriteHeader(statusCode)
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec is the OpenAPI 3 document of every route, TestOpenAPI keeps it in sync with the router
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// v2 GET /openapi.json
+// GetOpenAPI serves the OpenAPI 3 document of the v1, v2 and admin apis.
+func (a *APIV2) GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write(openAPISpec)
+	logError(err)
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+)
+
+// admin POST /backup
+// PostBackup copies the whole database, every tenant, to a new timestamped file while the
+// server keeps serving, and describes the verified copy.
+func (a *AdminAPI) PostBackup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if a.backups == nil {
+		err := writeProblem(w, r, CODE_NOT_IMPLEMENTED, "online backups are not supported by this record service", http.StatusNotImplemented)
+		logError(err)
+		return
+	}
+
+	backup, err := a.backups.Backup(ctx)
+	if err != nil {
+		writeServiceProblem(w, r, err)
+		return
+	}
+
+	err = writeJSON(w, backup, http.StatusCreated)
+	logError(err)
+}
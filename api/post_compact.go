@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// admin POST /compact
+// PostCompact enforces the retention policies on the tenant of the request now and reports
+// the versions removed.
+// With ?dry_run=true nothing is removed, the report lists what would be.
+func (a *AdminAPI) PostCompact(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			err := writeProblem(w, r, CODE_INVALID_PARAMETER, "invalid dry_run; dry_run must be true or false", http.StatusBadRequest)
+			logError(err)
+			return
+		}
+		dryRun = parsed
+	}
+
+	report, err := a.compactor.Compact(ctx, dryRun)
+	if err != nil {
+		writeServiceProblem(w, r, err)
+		return
+	}
+
+	err = writeJSON(w, report, http.StatusOK)
+	logError(err)
+}
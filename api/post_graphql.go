@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/chauvm/timetravel/service"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/location"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// MAX_QUERY_COMPLEXITY bounds the complexity of a GraphQL query, see queryComplexity
+const MAX_QUERY_COMPLEXITY = 1000
+
+// MAX_VERSIONS_READ bounds the versions a GraphQL query reads. versions only reads the page
+// it returns, but fieldChanges reads every version of the record, which queryComplexity
+// cannot know before the query runs, so they are counted as it does.
+const MAX_VERSIONS_READ = 10000
+
+// graphQLRequest is the body of a GraphQL query
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// v2 POST /graphql
+// PostGraphQL runs a GraphQL query of records, their versions and the changes of a field in
+// one round trip, see graphQLSchema. The response is 200 if the query ran, errors of fields
+// included, and 400 if it could not, like when it is more complex than MAX_QUERY_COMPLEXITY.
+func (a *APIV2) PostGraphQL(w http.ResponseWriter, r *http.Request) {
+	var request graphQLRequest
+	err := decodeJSON(w, r, a.records, &request)
+	if errors.Is(err, service.ErrBodyTooLarge) {
+		writeServiceProblem(w, r, err)
+		return
+	}
+	if err != nil || request.Query == "" {
+		err := writeProblem(w, r, CODE_INVALID_INPUT, "invalid input; expected a json object with a query", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	result := runGraphQL(r.Context(), a.records, request)
+	statusCode := http.StatusOK
+	if result.Data == nil {
+		statusCode = http.StatusBadRequest
+	}
+	err = writeJSON(w, result, statusCode)
+	logError(err)
+}
+
+// runGraphQL parses, validates and runs the query, unless it is too complex
+func runGraphQL(ctx context.Context, records service.RecordService, request graphQLRequest) *graphql.Result {
+	document, err := parser.Parse(parser.ParseParams{Source: request.Query})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	validation := graphql.ValidateDocument(&graphQLSchema, document, nil)
+	if !validation.IsValid {
+		return &graphql.Result{Errors: validation.Errors}
+	}
+	complexity := queryComplexity(document, request.OperationName, request.Variables)
+	if complexity > MAX_QUERY_COMPLEXITY {
+		return &graphql.Result{Errors: []gqlerrors.FormattedError{{
+			Message:    fmt.Sprintf("query too complex; its complexity is %d, at most %d is allowed, ask for fewer versions or records", complexity, MAX_QUERY_COMPLEXITY),
+			Locations:  []location.SourceLocation{},
+			Extensions: map[string]interface{}{"code": CODE_QUERY_TOO_COMPLEX},
+		}}}
+	}
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        graphQLSchema,
+		Root:          records,
+		AST:           document,
+		OperationName: request.OperationName,
+		Args:          request.Variables,
+		Context:       withGraphQLReads(ctx),
+	})
+}
+
+// queryComplexity estimates how many values the operation resolves, before it runs. A field
+// costs 1 plus the cost of its selections, times the number of items it lists: last for
+// versions and fieldChanges, the ids for records. The cost of an operation that is not
+// named, in a document with several, is that of the most complex.
+func queryComplexity(document *ast.Document, operationName string, variables map[string]interface{}) int {
+	fragments := map[string]*ast.FragmentDefinition{}
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	complexity := 0
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok || operationName != "" && (operation.Name == nil || operation.Name.Value != operationName) {
+			continue
+		}
+		c := complexityCounter{fragments: fragments, variables: map[string]interface{}{}}
+		for _, definition := range operation.VariableDefinitions {
+			name := definition.Variable.Name.Value
+			if value, ok := variables[name]; ok {
+				c.variables[name] = value
+			} else if definition.DefaultValue != nil {
+				c.variables[name] = definition.DefaultValue
+			}
+		}
+		if cost := c.selections(operation.SelectionSet); cost > complexity {
+			complexity = cost
+		}
+	}
+	return complexity
+}
+
+// complexityCounter counts the complexity of the selections of an operation
+type complexityCounter struct {
+	fragments map[string]*ast.FragmentDefinition
+	// variables are the values of the request, or else the default ast.Value
+	variables map[string]interface{}
+}
+
+func (c complexityCounter) selections(set *ast.SelectionSet) int {
+	if set == nil {
+		return 0
+	}
+	cost := 0
+	for _, selection := range set.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			cost = saturatingAdd(cost, saturatingAdd(1, saturatingMultiply(c.items(selection), c.selections(selection.SelectionSet))))
+		case *ast.InlineFragment:
+			cost = saturatingAdd(cost, c.selections(selection.SelectionSet))
+		case *ast.FragmentSpread:
+			// validation has refused cycles of fragments
+			if fragment, ok := c.fragments[selection.Name.Value]; ok {
+				cost = saturatingAdd(cost, c.selections(fragment.SelectionSet))
+			}
+		}
+	}
+	return cost
+}
+
+// items is how many items the field lists, the most it may when it depends on an argument
+// that is not known
+func (c complexityCounter) items(field *ast.Field) int {
+	switch field.Name.Value {
+	case "versions", "fieldChanges":
+		last, ok := c.argument(field, "last")
+		if !ok {
+			return GRAPHQL_DEFAULT_PAGE_SIZE
+		}
+		n, ok := intValue(last)
+		if !ok || n > GRAPHQL_MAX_PAGE_SIZE {
+			return GRAPHQL_MAX_PAGE_SIZE
+		}
+		return max(n, 1)
+	case "records":
+		ids, ok := c.argument(field, "ids")
+		if !ok {
+			return GRAPHQL_MAX_PAGE_SIZE
+		}
+		switch ids := ids.(type) {
+		case *ast.ListValue:
+			return max(len(ids.Values), 1)
+		case []interface{}:
+			return max(len(ids), 1)
+		}
+		// a single id is coerced to a list of one
+		return 1
+	}
+	return 1
+}
+
+// argument is the value of the argument of the field, resolving variables
+func (c complexityCounter) argument(field *ast.Field, name string) (interface{}, bool) {
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != name {
+			continue
+		}
+		if variable, ok := argument.Value.(*ast.Variable); ok {
+			value, ok := c.variables[variable.Name.Value]
+			return value, ok
+		}
+		return argument.Value, true
+	}
+	return nil, false
+}
+
+// intValue reads an int literal, or a number variable, decoded from json as a float64
+func intValue(value interface{}) (int, bool) {
+	switch value := value.(type) {
+	case *ast.IntValue:
+		n, err := strconv.Atoi(value.Value)
+		return n, err == nil
+	case float64:
+		if value < math.MinInt32 || value > math.MaxInt32 {
+			return 0, false
+		}
+		return int(value), true
+	}
+	return 0, false
+}
+
+// saturatingAdd and saturatingMultiply cap at math.MaxInt32, so deep queries cannot overflow
+func saturatingAdd(a int, b int) int {
+	if a > math.MaxInt32-b {
+		return math.MaxInt32
+	}
+	return a + b
+}
+
+func saturatingMultiply(a int, b int) int {
+	if b != 0 && a > math.MaxInt32/b {
+		return math.MaxInt32
+	}
+	return a * b
+}
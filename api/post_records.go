@@ -1,13 +1,12 @@
 package api
 
 import (
-	"encoding/json"
 	"errors"
-	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/logging"
 	"github.com/chauvm/timetravel/service"
 	"github.com/gorilla/mux"
 )
@@ -26,9 +25,11 @@ func (a *API) PostRecords(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var body map[string]*string
-	err = json.NewDecoder(r.Body).Decode(&body)
-
+	body, err := decodeUpdates(w, r, a.records)
+	if errors.Is(err, service.ErrBodyTooLarge) {
+		writeServiceError(w, err)
+		return
+	}
 	if err != nil {
 		err := writeError(w, "invalid input; could not parse json", http.StatusBadRequest)
 		logError(err)
@@ -41,7 +42,12 @@ func (a *API) PostRecords(w http.ResponseWriter, r *http.Request) {
 		int(idNumber),
 	)
 
-	if !errors.Is(err, service.ErrRecordDoesNotExist) { // record exists
+	if err != nil && !errors.Is(err, service.ErrRecordDoesNotExist) {
+		writeServiceError(w, err)
+		return
+	}
+
+	if err == nil { // record exists
 		record, err = a.records.UpdateRecord(ctx, int(idNumber), body)
 	} else { // record does not exist
 
@@ -61,9 +67,7 @@ func (a *API) PostRecords(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err != nil {
-		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
-		logError(err)
-		logError(errInWriting)
+		writeServiceError(w, err)
 		return
 	}
 
@@ -74,43 +78,71 @@ func (a *API) PostRecords(w http.ResponseWriter, r *http.Request) {
 }
 
 // v2 POST /records/{id}
-// if the record exists, the record is updated.
-// if the record doesn't exist, the record is created.
+// if the record exists, the record is updated, unless If-None-Match: * is sent.
+// if the record doesn't exist, the record is created, unless If-Match: * is sent.
 func (a *APIV2) PostRecords(w http.ResponseWriter, r *http.Request) {
-	log.Print("PostRecords v2")
 	ctx := r.Context()
 	id := mux.Vars(r)["id"]
 	idNumber, err := strconv.ParseInt(id, 10, 32)
 
 	if err != nil || idNumber <= 0 {
-		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		err := writeProblem(w, r, CODE_INVALID_ID, "invalid id; id must be a positive number", http.StatusBadRequest)
 		logError(err)
 		return
 	}
 
-	var body map[string]*string
-	err = json.NewDecoder(r.Body).Decode(&body)
-
+	body, err := decodeUpdates(w, r, a.records)
+	if errors.Is(err, service.ErrBodyTooLarge) {
+		writeServiceProblem(w, r, err)
+		return
+	}
 	if err != nil {
-		err := writeError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		err := writeProblem(w, r, CODE_INVALID_INPUT, "invalid input; could not parse json", http.StatusBadRequest)
 		logError(err)
 		return
 	}
 
+	// the client may report when the change actually happened, if it is reported late
+	if value := r.URL.Query().Get("occurred_at"); value != "" {
+		occurredAt, err := service.ParseOccurredAt(value)
+		if err != nil {
+			err := writeProblem(w, r, CODE_INVALID_PARAMETER, "invalid occurred_at; occurred_at must be an RFC 3339 timestamp or a date, not in the future", http.StatusBadRequest)
+			logError(err)
+			return
+		}
+		ctx = service.WithOccurredAt(ctx, occurredAt)
+	}
+
 	// first retrieve the record
 	record, err := a.records.GetRecord(
 		ctx,
 		int(idNumber),
 	)
-	log.Printf("PostRecords v2: record: %v", record)
 
-	if !errors.Is(err, service.ErrRecordDoesNotExist) { // record exists
+	if err != nil && !errors.Is(err, service.ErrRecordDoesNotExist) {
+		writeServiceProblem(w, r, err)
+		return
+	}
+
+	// If-None-Match: * only creates the record, If-Match: * only updates it
+	if err == nil && r.Header.Get("If-None-Match") == "*" {
+		err := writeProblem(w, r, CODE_RECORD_ALREADY_EXISTS, "record already exists; If-None-Match: * only creates records", http.StatusPreconditionFailed)
+		logError(err)
+		return
+	}
+	if err != nil && r.Header.Get("If-Match") == "*" {
+		err := writeProblem(w, r, CODE_RECORD_NOT_FOUND, "record does not exist; If-Match: * only updates records", http.StatusPreconditionFailed)
+		logError(err)
+		return
+	}
+
+	if err == nil { // record exists
 		record, err = a.records.UpdateRecord(ctx, int(idNumber), body)
 		// TODO: add a new row for the new version
 
 		// TODO: approach 2.3: save the accumulated_data in the row with version divisible by 10
 	} else { // record does not exist
-		log.Print("PostRecords v2: record does not exist")
+		logging.FromContext(ctx).Debug("record does not exist, creating it", "record_id", idNumber)
 
 		// exclude the delete updates
 		recordMap := map[string]string{}
@@ -131,9 +163,7 @@ func (a *APIV2) PostRecords(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err != nil {
-		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
-		logError(err)
-		logError(errInWriting)
+		writeServiceProblem(w, r, err)
 		return
 	}
 
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/chauvm/timetravel/logging"
+	"github.com/chauvm/timetravel/rating"
+	"github.com/chauvm/timetravel/service"
+)
+
+// PROBLEM_CONTENT_TYPE is the media type of the v2 error responses, see RFC 7807
+const PROBLEM_CONTENT_TYPE = "application/problem+json"
+
+// PROBLEM_TYPE_PREFIX followed by the code is the type of a problem
+const PROBLEM_TYPE_PREFIX = "urn:timetravel:problem:"
+
+// REQUEST_ID_HEADER carries the id of the request, it is echoed in problems
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// Stable, machine-readable problem codes. Clients branch on these, never rename one.
+const (
+	CODE_INVALID_ID                = "invalid_id"
+	CODE_INVALID_VERSION           = "invalid_version"
+	CODE_INVALID_INPUT             = "invalid_input"
+	CODE_INVALID_PARAMETER         = "invalid_parameter"
+	CODE_INVALID_FIELD             = "invalid_field"
+	CODE_RECORD_NOT_FOUND          = "record_not_found"
+	CODE_RECORD_ALREADY_EXISTS     = "record_already_exists"
+	CODE_RECORD_CONFLICT           = "record_conflict"
+	CODE_RECORD_ON_HOLD            = "record_on_hold"
+	CODE_RATER_NOT_FOUND           = "rater_not_found"
+	CODE_LEGAL_HOLD_EXISTS         = "legal_hold_exists"
+	CODE_LEGAL_HOLD_NOT_FOUND      = "legal_hold_not_found"
+	CODE_LEGAL_HOLD_REASON_MISSING = "legal_hold_reason_missing"
+	CODE_BODY_TOO_LARGE            = "body_too_large"
+	CODE_RECORD_TOO_LARGE          = "record_too_large"
+	CODE_TOO_MANY_KEYS             = "too_many_keys"
+	CODE_KEY_TOO_LONG              = "key_too_long"
+	CODE_VALUE_TOO_LONG            = "value_too_long"
+	CODE_TOO_MANY_VERSIONS         = "too_many_versions"
+	CODE_QUERY_TOO_COMPLEX         = "query_too_complex"
+	CODE_CONSTRAINT_VIOLATION      = "constraint_violation"
+	CODE_STORAGE_CORRUPT           = "storage_corrupt"
+	CODE_NOT_IMPLEMENTED           = "not_implemented"
+	CODE_UNAVAILABLE               = "unavailable"
+	CODE_INTERNAL                  = "internal"
+)
+
+// Problem is an RFC 7807 problem details object, extended with a code and the request id.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// serviceError is how an error returned by a service is reported to clients
+type serviceError struct {
+	err        error
+	statusCode int
+	code       string
+}
+
+// serviceErrors maps every sentinel error of the services, the first match wins.
+// Errors not listed here are internal errors.
+var serviceErrors = []serviceError{
+	{service.ErrRecordDoesNotExist, http.StatusNotFound, CODE_RECORD_NOT_FOUND},
+	{service.ErrRecordIDInvalid, http.StatusBadRequest, CODE_INVALID_ID},
+	{service.ErrRecordAlreadyExists, http.StatusConflict, CODE_RECORD_ALREADY_EXISTS},
+	{service.ErrRecordConflict, http.StatusConflict, CODE_RECORD_CONFLICT},
+	{service.ErrRecordOnHold, http.StatusConflict, CODE_RECORD_ON_HOLD},
+	{service.ErrLegalHoldExists, http.StatusConflict, CODE_LEGAL_HOLD_EXISTS},
+	{service.ErrLegalHoldDoesNotExist, http.StatusNotFound, CODE_LEGAL_HOLD_NOT_FOUND},
+	{service.ErrLegalHoldReasonMissing, http.StatusBadRequest, CODE_LEGAL_HOLD_REASON_MISSING},
+	{service.ErrBodyTooLarge, http.StatusRequestEntityTooLarge, CODE_BODY_TOO_LARGE},
+	{service.ErrRecordTooLarge, http.StatusRequestEntityTooLarge, CODE_RECORD_TOO_LARGE},
+	{service.ErrTooManyKeys, http.StatusUnprocessableEntity, CODE_TOO_MANY_KEYS},
+	{service.ErrKeyTooLong, http.StatusUnprocessableEntity, CODE_KEY_TOO_LONG},
+	{service.ErrValueTooLong, http.StatusUnprocessableEntity, CODE_VALUE_TOO_LONG},
+	{service.ErrTooManyVersions, http.StatusUnprocessableEntity, CODE_TOO_MANY_VERSIONS},
+	{service.ErrUnavailable, http.StatusServiceUnavailable, CODE_UNAVAILABLE},
+	{service.ErrConstraintViolation, http.StatusUnprocessableEntity, CODE_CONSTRAINT_VIOLATION},
+	{service.ErrStorageCorrupt, http.StatusInternalServerError, CODE_STORAGE_CORRUPT},
+	{rating.ErrRaterDoesNotExist, http.StatusBadRequest, CODE_RATER_NOT_FOUND},
+	{rating.ErrInvalidField, http.StatusUnprocessableEntity, CODE_INVALID_FIELD},
+	{rating.ErrWindowInvalid, http.StatusBadRequest, CODE_INVALID_PARAMETER},
+}
+
+// the messages of the limit errors say which limit was exceeded and nothing internal,
+// they are sent whole rather than only the message of the sentinel
+var detailedErrors = []error{
+	service.ErrBodyTooLarge,
+	service.ErrRecordTooLarge,
+	service.ErrTooManyKeys,
+	service.ErrKeyTooLong,
+	service.ErrValueTooLong,
+	service.ErrTooManyVersions,
+}
+
+// detail is the message sent to clients for err, reported as e
+func (e serviceError) detail(err error) string {
+	for _, detailed := range detailedErrors {
+		if errors.Is(err, detailed) {
+			return err.Error()
+		}
+	}
+	return e.err.Error()
+}
+
+// lookupServiceError finds how err is reported, defaulting to an internal error
+func lookupServiceError(err error) serviceError {
+	for _, e := range serviceErrors {
+		if errors.Is(err, e.err) {
+			return e
+		}
+	}
+	return serviceError{ErrInternal, http.StatusInternalServerError, CODE_INTERNAL}
+}
+
+// requestID returns the id LogRequests assigned to the request, or else the one the
+// client sent, if any
+func requestID(r *http.Request) string {
+	if id := logging.RequestID(r.Context()); id != "" {
+		return id
+	}
+	return r.Header.Get(REQUEST_ID_HEADER)
+}
+
+// writeProblem writes an application/problem+json error response.
+func writeProblem(w http.ResponseWriter, r *http.Request, code string, detail string, statusCode int) error {
+	logging.FromContext(r.Context()).Info("response errored", "code", code, "detail", detail)
+	problem := Problem{
+		Type:      PROBLEM_TYPE_PREFIX + code,
+		Title:     http.StatusText(statusCode),
+		Status:    statusCode,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: requestID(r),
+	}
+	w.Header().Set("Content-Type", PROBLEM_CONTENT_TYPE)
+	w.WriteHeader(statusCode)
+	return json.NewEncoder(w).Encode(problem)
+}
+
+// writeServiceProblem writes an error returned by a service as a problem.
+// Only the message of the sentinel is sent, the wrapped driver error is logged, but for
+// the limit errors.
+func writeServiceProblem(w http.ResponseWriter, r *http.Request, err error) {
+	e := lookupServiceError(err)
+	if e.statusCode >= http.StatusInternalServerError {
+		logging.FromContext(r.Context()).Error("request failed", "error", err)
+	}
+	if e.statusCode == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", RETRY_AFTER_SECONDS)
+	}
+	errInWriting := writeProblem(w, r, e.code, e.detail(err), e.statusCode)
+	logError(errInWriting)
+}
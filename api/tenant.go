@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/chauvm/timetravel/auth"
+	"github.com/chauvm/timetravel/logging"
+	"github.com/chauvm/timetravel/tenant"
+	"github.com/gorilla/mux"
+)
+
+// TENANT_HEADER picks the tenant a request acts on, for keys allowed any tenant
+const TENANT_HEADER = "X-Tenant-ID"
+
+// CODE_INVALID_TENANT is the problem code of a malformed tenant header
+const CODE_INVALID_TENANT = "invalid_tenant"
+
+// ResolveTenant puts the tenant the request acts on in its context, see
+// auth.Principal.ResolveTenant: the tenant of the api key, tenant.DEFAULT for keys bound to
+// none, and only keys allowed any tenant pick one with the X-Tenant-ID header. Without
+// authentication the header picks it. It is a mux middleware, register it with router.Use
+// after Authenticate, if any.
+func ResolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := ""
+		if route := mux.CurrentRoute(r); route != nil {
+			name = route.GetName()
+		}
+		requested := r.Header.Get(TENANT_HEADER)
+		if requested != "" && !tenant.Valid(requested) {
+			writeAuthError(w, r, name, CODE_INVALID_TENANT, tenant.ErrTenantInvalid.Error(), http.StatusBadRequest)
+			return
+		}
+
+		id := requested
+		if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+			resolved, err := principal.ResolveTenant(requested)
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("tenant denied", "principal", principal.Name, "tenant", requested)
+				writeAuthError(w, r, name, CODE_FORBIDDEN, "the api key may not act on tenant "+requested, http.StatusForbidden)
+				return
+			}
+			id = resolved
+		}
+		if id == "" {
+			id = tenant.DEFAULT
+		}
+
+		next.ServeHTTP(w, r.WithContext(tenant.WithID(r.Context(), id)))
+	})
+}
@@ -0,0 +1,223 @@
+// Package auth authenticates API keys and decides what the role of a key may do.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/tenant"
+)
+
+var ErrUnauthenticated = errors.New("missing, unknown or revoked api key")
+var ErrRoleInvalid = errors.New("role must be one of reader, writer, auditor, admin")
+var ErrKeyNameInvalid = errors.New("api key name is required")
+var ErrKeyNameTaken = errors.New("an api key with that name already exists")
+var ErrKeyDoesNotExist = errors.New("api key does not exist or is already revoked")
+var ErrTenantForbidden = errors.New("the api key may not act on that tenant")
+
+// roles of the principals
+const (
+	ROLE_READER = "reader"
+	// ROLE_AUDITOR reads the history of records, but cannot change them
+	ROLE_AUDITOR = "auditor"
+	ROLE_WRITER  = "writer"
+	ROLE_ADMIN   = "admin"
+)
+
+// permissions required by the routes
+const (
+	// read the current state of records
+	PERMISSION_READ = "read"
+	// create and update records
+	PERMISSION_WRITE = "write"
+	// read past versions, history, rates over time and reports
+	PERMISSION_TIME_TRAVEL = "time_travel"
+	// compaction and legal holds
+	PERMISSION_ADMIN = "admin"
+	// act on any tenant, for keys bound to none
+	PERMISSION_ANY_TENANT = "any_tenant"
+)
+
+var rolePermissions = map[string][]string{
+	ROLE_READER:  {PERMISSION_READ},
+	ROLE_AUDITOR: {PERMISSION_READ, PERMISSION_TIME_TRAVEL},
+	ROLE_WRITER:  {PERMISSION_READ, PERMISSION_WRITE},
+	ROLE_ADMIN:   {PERMISSION_READ, PERMISSION_WRITE, PERMISSION_TIME_TRAVEL, PERMISSION_ADMIN, PERMISSION_ANY_TENANT},
+}
+
+// KEY_PREFIX starts every key, so leaked keys are easy to search for
+const KEY_PREFIX = "tt_"
+
+// Allowed reports whether the role grants the permission.
+func Allowed(role string, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal is who a request is made on behalf of.
+type Principal struct {
+	Name string
+	Role string
+	// Tenant is the tenant the key is bound to, empty for none, see ResolveTenant
+	Tenant string
+}
+
+// ResolveTenant returns the tenant the principal acts on when it asks for requested, empty
+// if it asks for none. A key bound to no tenant acts on tenant.DEFAULT, unless its role
+// grants PERMISSION_ANY_TENANT; any other tenant is ErrTenantForbidden.
+func (p Principal) ResolveTenant(requested string) (string, error) {
+	bound := p.Tenant
+	if bound == "" && Allowed(p.Role, PERMISSION_ANY_TENANT) {
+		bound = requested
+	}
+	if bound == "" {
+		bound = tenant.DEFAULT
+	}
+	if requested != "" && requested != bound {
+		return "", fmt.Errorf("%w: %s", ErrTenantForbidden, requested)
+	}
+	return bound, nil
+}
+
+type contextKey int
+
+const principalKey contextKey = iota
+
+// WithPrincipal returns a context carrying the authenticated principal.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// PrincipalFrom returns the authenticated principal of the request, if any.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey).(Principal)
+	return principal, ok
+}
+
+// HashKey returns what is stored of a key, the hex encoded sha256 of it.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newKey returns a random 256 bit key
+func newKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return KEY_PREFIX + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// KeyStore keeps the hashes of the api keys, it fails with the errors of the database package.
+type KeyStore interface {
+	InsertAPIKey(ctx context.Context, name string, hash string, role string, tenantID string) error
+	GetAPIKeyByHash(ctx context.Context, hash string) (*entity.APIKey, error)
+	RevokeAPIKey(ctx context.Context, name string) error
+	GetAPIKeys(ctx context.Context) ([]entity.APIKey, error)
+}
+
+// sqliteKeys is the KeyStore of a SQLite database
+type sqliteKeys struct {
+	db *sql.DB
+}
+
+func (k sqliteKeys) InsertAPIKey(ctx context.Context, name string, hash string, role string, tenantID string) error {
+	return database.InsertAPIKey(ctx, k.db, name, hash, role, tenantID)
+}
+
+func (k sqliteKeys) GetAPIKeyByHash(ctx context.Context, hash string) (*entity.APIKey, error) {
+	return database.GetAPIKeyByHash(ctx, k.db, hash)
+}
+
+func (k sqliteKeys) RevokeAPIKey(ctx context.Context, name string) error {
+	return database.RevokeAPIKey(ctx, k.db, name)
+}
+
+func (k sqliteKeys) GetAPIKeys(ctx context.Context) ([]entity.APIKey, error) {
+	return database.GetAPIKeys(ctx, k.db)
+}
+
+// Authenticator checks API keys against the hashes stored in the database.
+type Authenticator struct {
+	keys KeyStore
+}
+
+// NewAuthenticator checks the keys stored in a SQLite database.
+func NewAuthenticator(db *sql.DB) *Authenticator {
+	return &Authenticator{sqliteKeys{db}}
+}
+
+// NewKeyStoreAuthenticator checks the keys stored in any other KeyStore.
+func NewKeyStoreAuthenticator(keys KeyStore) *Authenticator {
+	return &Authenticator{keys}
+}
+
+// Authenticate returns the principal the key belongs to.
+func (a *Authenticator) Authenticate(ctx context.Context, key string) (Principal, error) {
+	if !strings.HasPrefix(key, KEY_PREFIX) {
+		return Principal{}, ErrUnauthenticated
+	}
+	apiKey, err := a.keys.GetAPIKeyByHash(ctx, HashKey(key))
+	if errors.Is(err, database.ErrNotFound) {
+		return Principal{}, ErrUnauthenticated
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+	return Principal{Name: apiKey.Name, Role: apiKey.Role, Tenant: apiKey.Tenant}, nil
+}
+
+// Mint creates a key for the principal name with the role, bound to the tenant or, if it is
+// empty, to tenant.DEFAULT, unless the role grants PERMISSION_ANY_TENANT, see
+// Principal.ResolveTenant. The key is only ever returned here.
+func (a *Authenticator) Mint(ctx context.Context, name string, role string, tenantID string) (string, error) {
+	if name == "" {
+		return "", ErrKeyNameInvalid
+	}
+	if _, ok := rolePermissions[role]; !ok {
+		return "", fmt.Errorf("%w, got %q", ErrRoleInvalid, role)
+	}
+	if tenantID != "" && !tenant.Valid(tenantID) {
+		return "", fmt.Errorf("%w, got %q", tenant.ErrTenantInvalid, tenantID)
+	}
+	key, err := newKey()
+	if err != nil {
+		return "", err
+	}
+	err = a.keys.InsertAPIKey(ctx, name, HashKey(key), role, tenantID)
+	if errors.Is(err, database.ErrConflict) {
+		return "", ErrKeyNameTaken
+	}
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Revoke disables the key of the principal name for good.
+func (a *Authenticator) Revoke(ctx context.Context, name string) error {
+	err := a.keys.RevokeAPIKey(ctx, name)
+	if errors.Is(err, database.ErrAPIKeyDoesNotExist) {
+		return ErrKeyDoesNotExist
+	}
+	return err
+}
+
+// Keys lists every key, revoked ones included.
+func (a *Authenticator) Keys(ctx context.Context) ([]entity.APIKey, error) {
+	return a.keys.GetAPIKeys(ctx)
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/tenant"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowed(t *testing.T) {
+	assert.True(t, Allowed(ROLE_READER, PERMISSION_READ))
+	assert.False(t, Allowed(ROLE_READER, PERMISSION_TIME_TRAVEL))
+	assert.True(t, Allowed(ROLE_AUDITOR, PERMISSION_TIME_TRAVEL))
+	assert.False(t, Allowed(ROLE_AUDITOR, PERMISSION_WRITE))
+	assert.True(t, Allowed(ROLE_WRITER, PERMISSION_WRITE))
+	assert.False(t, Allowed(ROLE_WRITER, PERMISSION_ADMIN))
+	assert.True(t, Allowed(ROLE_ADMIN, PERMISSION_ADMIN))
+	assert.False(t, Allowed("", PERMISSION_READ))
+}
+
+func TestAuthenticator(t *testing.T) {
+	ctx := context.Background()
+	options := database.DefaultOptions()
+	options.File = filepath.Join(t.TempDir(), "rainbow.db")
+	db, err := database.CreateConnection(options)
+	assert.NoError(t, err)
+	defer db.Close()
+	a := NewAuthenticator(db)
+
+	key, err := a.Mint(ctx, "alice", ROLE_WRITER, "")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, KEY_PREFIX))
+
+	_, err = a.Mint(ctx, "alice", ROLE_READER, "")
+	assert.True(t, errors.Is(err, ErrKeyNameTaken))
+	_, err = a.Mint(ctx, "bob", "boss", "")
+	assert.True(t, errors.Is(err, ErrRoleInvalid))
+	_, err = a.Mint(ctx, "bob", ROLE_READER, "acme corp")
+	assert.True(t, errors.Is(err, tenant.ErrTenantInvalid))
+
+	// only the hash is stored
+	var stored string
+	assert.NoError(t, db.QueryRow("SELECT hash FROM api_keys WHERE name = 'alice'").Scan(&stored))
+	assert.Equal(t, HashKey(key), stored)
+	assert.NotContains(t, stored, key)
+
+	principal, err := a.Authenticate(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, Principal{Name: "alice", Role: ROLE_WRITER}, principal)
+	_, err = a.Authenticate(ctx, key+"x")
+	assert.True(t, errors.Is(err, ErrUnauthenticated))
+
+	assert.NoError(t, a.Revoke(ctx, "alice"))
+	_, err = a.Authenticate(ctx, key)
+	assert.True(t, errors.Is(err, ErrUnauthenticated))
+	assert.True(t, errors.Is(a.Revoke(ctx, "alice"), ErrKeyDoesNotExist))
+
+	// keys bound to a tenant authenticate as a principal of that tenant only
+	key, err = a.Mint(ctx, "carol", ROLE_READER, "acme")
+	assert.NoError(t, err)
+	principal, err = a.Authenticate(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, Principal{Name: "carol", Role: ROLE_READER, Tenant: "acme"}, principal)
+
+	keys, err := a.Keys(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+	assert.NotEmpty(t, keys[0].RevokedAt)
+	assert.Equal(t, "acme", keys[1].Tenant)
+}
+
+func TestResolveTenant(t *testing.T) {
+	bound := Principal{Name: "writer@acme.example.com", Role: ROLE_ADMIN, Tenant: "acme"}
+	unbound := Principal{Name: "writer@example.com", Role: ROLE_WRITER}
+	admin := Principal{Name: "admin@example.com", Role: ROLE_ADMIN}
+
+	cases := []struct {
+		principal Principal
+		requested string
+		tenant    string
+		err       error
+	}{
+		{bound, "", "acme", nil},
+		{bound, "acme", "acme", nil},
+		{bound, "globex", "", ErrTenantForbidden},
+		{unbound, "", tenant.DEFAULT, nil},
+		{unbound, tenant.DEFAULT, tenant.DEFAULT, nil},
+		{unbound, "acme", "", ErrTenantForbidden},
+		{admin, "", tenant.DEFAULT, nil},
+		{admin, "acme", "acme", nil},
+	}
+	for _, c := range cases {
+		resolved, err := c.principal.ResolveTenant(c.requested)
+		assert.Equal(t, c.tenant, resolved, c.principal.Name+" "+c.requested)
+		assert.True(t, errors.Is(err, c.err), c.principal.Name+" "+c.requested)
+	}
+}
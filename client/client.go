@@ -0,0 +1,214 @@
+// Package client calls the v2 api of a timetravel server. Client implements
+// service.RecordService, so code written against the service runs against a remote server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chauvm/timetravel/api"
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/service"
+)
+
+// defaults of a new client
+const (
+	DEFAULT_MAX_RETRIES = 3
+	DEFAULT_BACKOFF     = 100 * time.Millisecond
+	// MAX_BACKOFF caps the wait between two attempts, Retry-After included
+	MAX_BACKOFF = 5 * time.Second
+)
+
+var _ service.RecordService = (*Client)(nil)
+
+// Client calls a timetravel server. Set its fields before the first call.
+type Client struct {
+	// BaseURL is the address of the server, such as http://127.0.0.1:8000
+	BaseURL string
+	// APIKey is sent as a bearer token, if not empty
+	APIKey string
+	// Tenant is sent as X-Tenant-ID, if not empty, for keys not bound to a tenant
+	Tenant     string
+	HTTPClient *http.Client
+	// MaxRetries is how many times a request answered 503, or 409 for a concurrent
+	// update, is sent again
+	MaxRetries int
+	// Backoff is the wait before the first retry, it doubles on every retry
+	Backoff time.Duration
+}
+
+func NewClient(baseURL string, apiKey string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		APIKey:     apiKey,
+		HTTPClient: http.DefaultClient,
+		MaxRetries: DEFAULT_MAX_RETRIES,
+		Backoff:    DEFAULT_BACKOFF,
+	}
+}
+
+// GetRecord retrieves the current state of the record, only its id and data are known.
+func (c *Client) GetRecord(ctx context.Context, id int) (entity.Record, error) {
+	var record entity.Record
+	err := c.do(ctx, "GET", recordPath(id), nil, nil, &record)
+	return record, err
+}
+
+// CreateRecord creates the record, it fails with service.ErrRecordAlreadyExists if it exists.
+func (c *Client) CreateRecord(ctx context.Context, record entity.Record) error {
+	if record.ID <= 0 {
+		return service.ErrRecordIDInvalid
+	}
+	updates := make(map[string]*string, len(record.Data))
+	for key, value := range record.Data {
+		value := value
+		updates[key] = &value
+	}
+	return c.do(ctx, "POST", recordPath(record.ID), http.Header{"If-None-Match": {"*"}}, updates, nil)
+}
+
+// UpdateRecord sets the keys of the record, a nil value deletes the key. It fails with
+// service.ErrRecordDoesNotExist if the record does not exist. Only the id and data of
+// the record returned are known.
+func (c *Client) UpdateRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error) {
+	var record entity.Record
+	err := c.do(ctx, "POST", recordPath(id), http.Header{"If-Match": {"*"}}, updates, &record)
+	return record, err
+}
+
+// GetRecordVersions lists the versions kept of the record, newest first.
+func (c *Client) GetRecordVersions(ctx context.Context, id int) ([]int, error) {
+	var response struct {
+		Data []int `json:"data"`
+	}
+	err := c.do(ctx, "GET", recordPath(id)+"/versions", nil, nil, &response)
+	return response.Data, err
+}
+
+// GetRecordAtVersion retrieves the record as it was at the version, only its id and data are known.
+func (c *Client) GetRecordAtVersion(ctx context.Context, id int, version int) (entity.Record, error) {
+	var record entity.Record
+	err := c.do(ctx, "GET", recordPath(id)+"/"+strconv.Itoa(version), nil, nil, &record)
+	return record, err
+}
+
+// GetRecordAtTime retrieves the record as it was at the time, only its id and data are known.
+func (c *Client) GetRecordAtTime(ctx context.Context, id int, at time.Time) (entity.Record, error) {
+	var record entity.Record
+	query := url.Values{"time": {at.UTC().Format(time.RFC3339)}}
+	err := c.do(ctx, "GET", recordPath(id)+"/at?"+query.Encode(), nil, nil, &record)
+	return record, err
+}
+
+// GetRecordHistory retrieves every version of the record, oldest first.
+func (c *Client) GetRecordHistory(ctx context.Context, id int) ([]entity.Record, error) {
+	var response struct {
+		Data []entity.Record `json:"data"`
+	}
+	err := c.do(ctx, "GET", recordPath(id)+"/history", nil, nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+func recordPath(id int) string {
+	return "/api/v2/records/" + strconv.Itoa(id)
+}
+
+// do sends the request, retrying it while the server is busy or a concurrent update won,
+// and decodes the response into out, if not nil
+func (c *Client) do(ctx context.Context, method string, path string, header http.Header, in interface{}, out interface{}) error {
+	var body []byte
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = encoded
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		if in != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+c.APIKey)
+		}
+		if c.Tenant != "" {
+			req.Header.Set(api.TENANT_HEADER, c.Tenant)
+		}
+
+		res, err := c.HTTPClient.Do(req)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode < 300 {
+			err := decode(res, out)
+			res.Body.Close()
+			return err
+		}
+
+		apiErr := readError(res)
+		res.Body.Close()
+		if attempt >= c.MaxRetries || !apiErr.retryable() {
+			return apiErr
+		}
+		if err := sleep(ctx, c.wait(attempt, res.Header.Get("Retry-After"))); err != nil {
+			return err
+		}
+	}
+}
+
+// wait is how long to wait before retrying a request that failed attempt times: the
+// doubled backoff with jitter, or what the server asked for if longer, at most MAX_BACKOFF
+func (c *Client) wait(attempt int, retryAfter string) time.Duration {
+	wait := c.Backoff << attempt
+	wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && time.Duration(seconds)*time.Second > wait {
+		wait = time.Duration(seconds) * time.Second
+	}
+	if wait > MAX_BACKOFF {
+		wait = MAX_BACKOFF
+	}
+	return wait
+}
+
+// sleep waits for d, or returns the error of ctx if it is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func decode(res *http.Response, out interface{}) error {
+	if out == nil {
+		_, err := io.Copy(io.Discard, res.Body)
+		return err
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("client: decoding the response of %s %s: %w", res.Request.Method, res.Request.URL.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chauvm/timetravel/api"
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/service"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// newServer serves the v2 api of a fresh database, every request goes through wrap first
+func newServer(t *testing.T, wrap func(http.Handler) http.Handler) *httptest.Server {
+	options := database.DefaultOptions()
+	options.File = filepath.Join(t.TempDir(), "rainbow.db")
+	db, err := database.CreateConnection(options)
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	records := service.NewPersistentRecordService(db, service.DefaultLimits())
+
+	router := mux.NewRouter()
+	api.NewAPIV2(&records).CreateRoutes(router.PathPrefix("/api/v2").Subrouter())
+	server := httptest.NewServer(wrap(router))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func unwrapped(next http.Handler) http.Handler { return next }
+
+func value(s string) *string { return &s }
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	server := newServer(t, unwrapped)
+	c := NewClient(server.URL, "")
+
+	assert.NoError(t, c.CreateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"hello": "world"}}))
+	err := c.CreateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"hello": "again"}})
+	assert.ErrorIs(t, err, service.ErrRecordAlreadyExists)
+	assert.ErrorIs(t, c.CreateRecord(ctx, entity.Record{ID: 0}), service.ErrRecordIDInvalid)
+
+	record, err := c.UpdateRecord(ctx, 1, map[string]*string{"hello": nil, "status": value("ok")})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"status": "ok"}, record.Data)
+	_, err = c.UpdateRecord(ctx, 2, map[string]*string{"status": value("ok")})
+	assert.ErrorIs(t, err, service.ErrRecordDoesNotExist)
+
+	record, err = c.GetRecord(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.Record{ID: 1, Data: map[string]string{"status": "ok"}}, record)
+	_, err = c.GetRecord(ctx, 2)
+	assert.ErrorIs(t, err, service.ErrRecordDoesNotExist)
+	var apiErr *Error
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, 404, apiErr.StatusCode)
+	assert.Equal(t, api.CODE_RECORD_NOT_FOUND, apiErr.Problem.Code)
+
+	versions, err := c.GetRecordVersions(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, versions)
+	record, err = c.GetRecordAtVersion(ctx, 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"hello": "world"}, record.Data)
+
+	history, err := c.GetRecordHistory(ctx, 1)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, 1, history[0].Version)
+	assert.Equal(t, map[string]string{"status": "ok"}, history[1].Data)
+	assert.NotEmpty(t, history[1].Timestamp)
+	_, err = c.GetRecordHistory(ctx, 2)
+	assert.ErrorIs(t, err, service.ErrRecordDoesNotExist)
+
+	record, err = c.GetRecordAtTime(ctx, 1, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"status": "ok"}, record.Data)
+	_, err = c.GetRecordAtTime(ctx, 1, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.ErrorIs(t, err, service.ErrRecordDoesNotExist)
+}
+
+// failing answers the first n requests with the status and problem code
+func failing(n int32, status int, code string, attempts *int32) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(attempts, 1) <= n {
+				w.Header().Set("Content-Type", api.PROBLEM_CONTENT_TYPE)
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(`{"status":` + strconv.Itoa(status) + `,"code":"` + code + `"}`))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+
+	// busy, then fine
+	var attempts int32
+	c := NewClient(newServer(t, failing(2, 503, api.CODE_UNAVAILABLE, &attempts)).URL, "")
+	c.Backoff = time.Millisecond
+	assert.NoError(t, c.CreateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"hello": "world"}}))
+	assert.Equal(t, int32(3), attempts)
+
+	// a concurrent update won, the update is sent again
+	attempts = 0
+	c = NewClient(newServer(t, failing(1, 409, api.CODE_RECORD_CONFLICT, &attempts)).URL, "")
+	c.Backoff = time.Millisecond
+	assert.NoError(t, c.CreateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"hello": "world"}}))
+	assert.Equal(t, int32(2), attempts)
+
+	// other conflicts are final
+	attempts = 0
+	c = NewClient(newServer(t, failing(1, 409, api.CODE_RECORD_ON_HOLD, &attempts)).URL, "")
+	c.Backoff = time.Millisecond
+	_, err := c.UpdateRecord(ctx, 1, map[string]*string{"hello": nil})
+	assert.ErrorIs(t, err, service.ErrRecordOnHold)
+	assert.Equal(t, int32(1), attempts)
+
+	// giving up after MaxRetries
+	attempts = 0
+	c = NewClient(newServer(t, failing(100, 503, api.CODE_UNAVAILABLE, &attempts)).URL, "")
+	c.Backoff = time.Millisecond
+	_, err = c.GetRecord(ctx, 1)
+	assert.ErrorIs(t, err, service.ErrUnavailable)
+	assert.Equal(t, int32(DEFAULT_MAX_RETRIES+1), attempts)
+}
+
+func TestContextCancellation(t *testing.T) {
+	var attempts int32
+	c := NewClient(newServer(t, failing(100, 503, api.CODE_UNAVAILABLE, &attempts)).URL, "")
+	c.Backoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	_, err := c.GetRecord(ctx, 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(started), time.Second)
+	assert.Equal(t, int32(1), attempts)
+}
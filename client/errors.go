@@ -0,0 +1,72 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/chauvm/timetravel/api"
+	"github.com/chauvm/timetravel/auth"
+	"github.com/chauvm/timetravel/service"
+)
+
+// MAX_ERROR_BYTES bounds how much of an error response is read
+const MAX_ERROR_BYTES = 64 << 10
+
+// codeErrors are the errors of the service each problem code stands for, so callers
+// check client errors with errors.Is like they would service errors
+var codeErrors = map[string]error{
+	api.CODE_INVALID_ID:            service.ErrRecordIDInvalid,
+	api.CODE_RECORD_NOT_FOUND:      service.ErrRecordDoesNotExist,
+	api.CODE_RECORD_ALREADY_EXISTS: service.ErrRecordAlreadyExists,
+	api.CODE_RECORD_CONFLICT:       service.ErrRecordConflict,
+	api.CODE_RECORD_ON_HOLD:        service.ErrRecordOnHold,
+	api.CODE_BODY_TOO_LARGE:        service.ErrBodyTooLarge,
+	api.CODE_RECORD_TOO_LARGE:      service.ErrRecordTooLarge,
+	api.CODE_TOO_MANY_KEYS:         service.ErrTooManyKeys,
+	api.CODE_KEY_TOO_LONG:          service.ErrKeyTooLong,
+	api.CODE_VALUE_TOO_LONG:        service.ErrValueTooLong,
+	api.CODE_TOO_MANY_VERSIONS:     service.ErrTooManyVersions,
+	api.CODE_UNAVAILABLE:           service.ErrUnavailable,
+	api.CODE_UNAUTHENTICATED:       auth.ErrUnauthenticated,
+}
+
+// Error is an error response of the server.
+//
+// errors.Is matches it against the error of the service its code stands for, such as
+// service.ErrRecordDoesNotExist for record_not_found.
+type Error struct {
+	StatusCode int
+	Problem    api.Problem
+}
+
+func (e *Error) Error() string {
+	if e.Problem.Detail != "" {
+		return fmt.Sprintf("timetravel: %d %s: %s", e.StatusCode, e.Problem.Code, e.Problem.Detail)
+	}
+	return fmt.Sprintf("timetravel: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *Error) Is(target error) bool {
+	err, ok := codeErrors[e.Problem.Code]
+	return ok && err == target
+}
+
+// retryable reports whether sending the request again may succeed: the database was
+// busy, or a concurrent update of the record won. Neither changed anything.
+func (e *Error) retryable() bool {
+	return e.StatusCode == http.StatusServiceUnavailable ||
+		(e.StatusCode == http.StatusConflict && e.Problem.Code == api.CODE_RECORD_CONFLICT)
+}
+
+// readError reads the problem of an error response, responses that are not problems,
+// such as those of proxies, only keep their status
+func readError(res *http.Response) *Error {
+	e := &Error{StatusCode: res.StatusCode}
+	body, err := io.ReadAll(io.LimitReader(res.Body, MAX_ERROR_BYTES))
+	if err == nil {
+		_ = json.Unmarshal(body, &e.Problem)
+	}
+	return e
+}
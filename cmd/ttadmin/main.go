@@ -0,0 +1,379 @@
+// Command ttadmin administers a timetravel database: it mints, revokes and lists api keys,
+// backs up and restores the SQLite database.
+//
+//	ttadmin keys mint -name NAME -role reader|auditor|writer|admin [-tenant TENANT]
+//	ttadmin keys revoke -name NAME
+//	ttadmin keys list
+//	ttadmin backup [-dir DIR]
+//	ttadmin restore [-force] BACKUP
+//	ttadmin replica list [-dir DIR]
+//	ttadmin replica restore [-dir DIR] [-at TIME] [-force]
+//
+// A key minted without -tenant acts on the default tenant, an admin key on any tenant it
+// picks with the X-Tenant-ID header.
+// The database is the one the server is configured with, SQLite or Postgres, see the
+// config package, unless the SQLite file -database is given.
+//
+// backup is safe while the server runs, it writes to BACKUP_DIR unless -dir is given.
+// restore requires the server to be stopped. It verifies the backup and refuses one with
+// fewer rows than the database unless -force is given, the database replaced is kept
+// beside it as a .pre-restore file.
+//
+// replica restore rebuilds the database as it was at -at, an RFC 3339 time defaulting to
+// now, from the replica the server ships its write-ahead log to, REPLICA_DIR unless -dir
+// is given, then restores it like a backup.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chauvm/timetravel/auth"
+	"github.com/chauvm/timetravel/config"
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/postgres"
+	"github.com/chauvm/timetravel/replica"
+	"github.com/chauvm/timetravel/service"
+	"github.com/chauvm/timetravel/tenant"
+)
+
+const USAGE = `usage:
+  ttadmin keys mint -name NAME -role reader|auditor|writer|admin [-tenant TENANT]
+  ttadmin keys revoke -name NAME
+  ttadmin keys list
+  ttadmin backup [-database FILE] [-dir DIR]
+  ttadmin restore [-database FILE] [-force] BACKUP
+  ttadmin replica list [-dir DIR]
+  ttadmin replica restore [-database FILE] [-dir DIR] [-at TIME] [-force]
+`
+
+// exit codes of ttadmin
+const (
+	EXIT_OK = iota
+	// the command failed
+	EXIT_ERROR
+	// the command line is invalid
+	EXIT_USAGE
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, USAGE)
+		return EXIT_USAGE
+	}
+	switch args[0] {
+	case "keys":
+		return runKeys(args[1:], stdout, stderr)
+	case "backup":
+		return runBackup(args[1:], stdout, stderr)
+	case "restore":
+		return runRestore(args[1:], stdout, stderr)
+	case "replica":
+		return runReplica(args[1:], stdout, stderr)
+	}
+	fmt.Fprint(stderr, USAGE)
+	return EXIT_USAGE
+}
+
+func runKeys(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, USAGE)
+		return EXIT_USAGE
+	}
+	command := args[0]
+
+	flags := flag.NewFlagSet("ttadmin keys "+command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	databaseFile := flags.String("database", "", "path of the SQLite database file, defaults to the server's")
+	name := flags.String("name", "", "name of the principal the key belongs to")
+	role := flags.String("role", "", "one of reader, auditor, writer, admin")
+	tenantID := flags.String("tenant", "", "tenant the key is bound to, the default tenant if empty, any tenant for admin keys")
+	if err := flags.Parse(args[1:]); err != nil {
+		return EXIT_USAGE
+	}
+
+	cfg, err := config.Load(nil, config.ENV_FILES...)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXIT_USAGE
+	}
+	if *databaseFile != "" {
+		cfg.Store = config.STORE_SQLITE
+		cfg.Database.File = *databaseFile
+	}
+	authenticator, closeStore, err := openAuthenticator(cfg)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXIT_ERROR
+	}
+	defer closeStore()
+
+	ctx := context.Background()
+	switch command {
+	case "mint":
+		key, err := authenticator.Mint(ctx, *name, *role, *tenantID)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return EXIT_ERROR
+		}
+		fmt.Fprintf(stderr, "minted a %s key for %s, it is not shown again:\n", *role, *name)
+		fmt.Fprintln(stdout, key)
+	case "revoke":
+		if err := authenticator.Revoke(ctx, *name); err != nil {
+			fmt.Fprintln(stderr, err)
+			return EXIT_ERROR
+		}
+		fmt.Fprintf(stderr, "revoked the key of %s\n", *name)
+	case "list":
+		keys, err := authenticator.Keys(ctx)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return EXIT_ERROR
+		}
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tROLE\tTENANT\tCREATED\tREVOKED")
+		for _, key := range keys {
+			boundTo := key.Tenant
+			if boundTo == "" && auth.Allowed(key.Role, auth.PERMISSION_ANY_TENANT) {
+				boundTo = "*"
+			} else if boundTo == "" {
+				boundTo = tenant.DEFAULT
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", key.Name, key.Role, boundTo, key.CreatedAt, key.RevokedAt)
+		}
+		if err := w.Flush(); err != nil {
+			fmt.Fprintln(stderr, err)
+			return EXIT_ERROR
+		}
+	default:
+		fmt.Fprint(stderr, USAGE)
+		return EXIT_USAGE
+	}
+	return EXIT_OK
+}
+
+func runBackup(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("ttadmin backup", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	databaseFile := flags.String("database", "", "path of the SQLite database file, defaults to the server's")
+	dir := flags.String("dir", "", "directory the backup is written to, defaults to the server's BACKUP_DIR")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 {
+		return EXIT_USAGE
+	}
+
+	cfg, err := loadSQLiteConfig(*databaseFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXIT_USAGE
+	}
+	if *dir != "" {
+		cfg.BackupDir = *dir
+	}
+
+	// the server may be running, the backup never writes to its database
+	cfg.Database.ReadOnly = true
+	db, err := database.CreateConnection(cfg.Database)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXIT_ERROR
+	}
+	defer db.Close()
+
+	backup, err := service.NewPersistentBackupService(db, cfg.Database.File, cfg.BackupDir).Backup(context.Background())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXIT_ERROR
+	}
+	fmt.Fprintf(stderr, "backed up %s, %d bytes at schema version %d\n", cfg.Database.File, backup.Bytes, backup.SchemaVersion)
+	printRows(stderr, backup)
+	fmt.Fprintln(stdout, backup.File)
+	return EXIT_OK
+}
+
+func runRestore(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("ttadmin restore", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	databaseFile := flags.String("database", "", "path of the SQLite database file, defaults to the server's")
+	force := flags.Bool("force", false, "restore a backup with fewer rows than the database")
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprint(stderr, USAGE)
+		return EXIT_USAGE
+	}
+	backupFile := flags.Arg(0)
+
+	cfg, err := loadSQLiteConfig(*databaseFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXIT_USAGE
+	}
+
+	ctx := context.Background()
+	backup, err := database.VerifyDatabase(ctx, backupFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXIT_ERROR
+	}
+	fmt.Fprintf(stderr, "%s is valid, %d bytes at schema version %d\n", backupFile, backup.Bytes, backup.SchemaVersion)
+	printRows(stderr, backup)
+
+	previous, err := database.Restore(ctx, backupFile, cfg.Database.File, *force)
+	if errors.Is(err, database.ErrBackupBehind) {
+		fmt.Fprintf(stderr, "%v\nrestore it anyway with -force\n", err)
+		return EXIT_ERROR
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXIT_ERROR
+	}
+	fmt.Fprintf(stderr, "restored %s to %s\n", backupFile, cfg.Database.File)
+	if previous != "" {
+		fmt.Fprintf(stderr, "the database replaced is kept as:\n")
+		fmt.Fprintln(stdout, previous)
+	}
+	return EXIT_OK
+}
+
+func runReplica(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, USAGE)
+		return EXIT_USAGE
+	}
+	command := args[0]
+
+	flags := flag.NewFlagSet("ttadmin replica "+command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	databaseFile := flags.String("database", "", "path of the SQLite database file, defaults to the server's")
+	dir := flags.String("dir", "", "directory of the replica, defaults to the server's REPLICA_DIR")
+	at := flags.String("at", "", "RFC 3339 time to restore the database at, defaults to now")
+	force := flags.Bool("force", false, "restore a database with fewer rows than the current one")
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() > 0 {
+		return EXIT_USAGE
+	}
+
+	cfg, err := loadSQLiteConfig(*databaseFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXIT_USAGE
+	}
+	if *dir != "" {
+		cfg.ReplicaDir = *dir
+	}
+	if cfg.ReplicaDir == "" {
+		fmt.Fprintln(stderr, "no replica, set REPLICA_DIR or -dir")
+		return EXIT_USAGE
+	}
+
+	switch command {
+	case "list":
+		generations, err := replica.Generations(cfg.ReplicaDir)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return EXIT_ERROR
+		}
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "GENERATION\tFROM\tTO\tSEGMENTS")
+		for _, generation := range generations {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", generation.Name, generation.Start.Format(time.RFC3339Nano),
+				generation.End().Format(time.RFC3339Nano), len(generation.Segments))
+		}
+		if err := w.Flush(); err != nil {
+			fmt.Fprintln(stderr, err)
+			return EXIT_ERROR
+		}
+	case "restore":
+		restoreAt := time.Now()
+		if *at != "" {
+			restoreAt, err = time.Parse(time.RFC3339, *at)
+			if err != nil {
+				fmt.Fprintf(stderr, "invalid -at; it must be an RFC 3339 time: %v\n", err)
+				return EXIT_USAGE
+			}
+		}
+
+		rebuilt := cfg.Database.File + ".replica"
+		defer os.Remove(rebuilt)
+		point, err := replica.Restore(cfg.ReplicaDir, rebuilt, restoreAt)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return EXIT_ERROR
+		}
+		fmt.Fprintf(stderr, "rebuilt the database as of %s from generation %s and %d segments\n",
+			point.At.Format(time.RFC3339Nano), point.Generation, point.Segments)
+
+		previous, err := database.Restore(context.Background(), rebuilt, cfg.Database.File, *force)
+		if errors.Is(err, database.ErrBackupBehind) {
+			fmt.Fprintf(stderr, "%v\nrestore it anyway with -force\n", err)
+			return EXIT_ERROR
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return EXIT_ERROR
+		}
+		fmt.Fprintf(stderr, "restored %s\n", cfg.Database.File)
+		if previous != "" {
+			fmt.Fprintf(stderr, "the database replaced is kept as:\n")
+			fmt.Fprintln(stdout, previous)
+		}
+	default:
+		fmt.Fprint(stderr, USAGE)
+		return EXIT_USAGE
+	}
+	return EXIT_OK
+}
+
+// loadSQLiteConfig loads the configuration of the server, the SQLite file is overridden
+// unless databaseFile is empty. Only SQLite databases are backed up and restored by ttadmin.
+func loadSQLiteConfig(databaseFile string) (config.Config, error) {
+	cfg, err := config.Load(nil, config.ENV_FILES...)
+	if err != nil {
+		return cfg, err
+	}
+	if databaseFile != "" {
+		cfg.Store = config.STORE_SQLITE
+		cfg.Database.File = databaseFile
+	}
+	if cfg.Store != config.STORE_SQLITE {
+		return cfg, fmt.Errorf("store %s is not backed up by ttadmin, use the tools of the store or -database", cfg.Store)
+	}
+	return cfg, nil
+}
+
+// printRows lists the rows of the backup by table
+func printRows(w io.Writer, backup entity.Backup) {
+	for _, table := range database.BACKUP_TABLES {
+		fmt.Fprintf(w, "  %s: %d rows\n", table, backup.Rows[table])
+	}
+}
+
+// openAuthenticator opens the api keys of the store the server is configured with
+func openAuthenticator(cfg config.Config) (*auth.Authenticator, func() error, error) {
+	switch cfg.Store {
+	case config.STORE_POSTGRES:
+		db, err := postgres.Open(cfg.Postgres)
+		if err != nil {
+			return nil, nil, err
+		}
+		return auth.NewKeyStoreAuthenticator(postgres.NewKeyStore(db)), func() error { return postgres.Close(db) }, nil
+	case config.STORE_MEMORY:
+		return nil, nil, fmt.Errorf("store %s keeps no api keys", cfg.Store)
+	}
+	db, err := database.CreateConnection(cfg.Database)
+	if err != nil {
+		return nil, nil, err
+	}
+	return auth.NewAuthenticator(db), func() error { return database.Close(db) }, nil
+}
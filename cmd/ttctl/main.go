@@ -0,0 +1,349 @@
+// Command ttctl reads and updates records, either over HTTP against a running server or,
+// for offline forensics, straight from a database file opened read-only.
+//
+//	ttctl get      [flags] ID [-version N | -at TIME]
+//	ttctl put      [flags] ID [-set KEY=VALUE]... [-delete KEY]... [-file UPDATES.json]
+//	ttctl versions [flags] ID
+//	ttctl show     [flags] ID VERSION
+//	ttctl diff     [flags] ID FROM TO
+//	ttctl history  [flags] ID
+//
+// Every command takes -server URL, with the api key in -key or TTCTL_API_KEY, or
+// -database FILE, and -tenant.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chauvm/timetravel/client"
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/service"
+	"github.com/chauvm/timetravel/tenant"
+)
+
+const USAGE = `usage:
+  ttctl get      [flags] ID [-version N | -at TIME]
+  ttctl put      [flags] ID [-set KEY=VALUE]... [-delete KEY]... [-file UPDATES.json]
+  ttctl versions [flags] ID
+  ttctl show     [flags] ID VERSION
+  ttctl diff     [flags] ID FROM TO
+  ttctl history  [flags] ID
+
+flags: -server URL [-key KEY] | -database FILE, and -tenant TENANT
+`
+
+// API_KEY_ENV is read when -key is not given, so the key stays out of the shell history
+const API_KEY_ENV = "TTCTL_API_KEY"
+
+// exit codes of ttctl
+const (
+	EXIT_OK = iota
+	// the command failed
+	EXIT_ERROR
+	// the command line is invalid
+	EXIT_USAGE
+)
+
+var errUsage = errors.New("invalid command line")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// repeated collects the values of a flag given several times
+type repeated []string
+
+func (r *repeated) String() string     { return strings.Join(*r, ",") }
+func (r *repeated) Set(v string) error { *r = append(*r, v); return nil }
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) < 1 {
+		fmt.Fprint(stderr, USAGE)
+		return EXIT_USAGE
+	}
+	command := args[0]
+
+	flags := flag.NewFlagSet("ttctl "+command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	server := flags.String("server", "", "address of a running server, such as http://127.0.0.1:8000")
+	key := flags.String("key", os.Getenv(API_KEY_ENV), "api key sent to the server, defaults to $"+API_KEY_ENV)
+	databaseFile := flags.String("database", "", "path of a database file, opened read-only")
+	tenantID := flags.String("tenant", "", "tenant the records belong to")
+	version := flags.Int("version", 0, "get: the version to get")
+	at := flags.String("at", "", "get: the RFC 3339 time to get the record at")
+	var sets, deletes repeated
+	flags.Var(&sets, "set", "put: KEY=VALUE to set, repeatable")
+	flags.Var(&deletes, "delete", "put: KEY to delete, repeatable")
+	updatesFile := flags.String("file", "", "put: JSON file of the updates, null values delete keys")
+	if err := flags.Parse(args[1:]); err != nil {
+		return EXIT_USAGE
+	}
+
+	if (*server == "") == (*databaseFile == "") {
+		fmt.Fprintln(stderr, "exactly one of -server and -database is required")
+		return EXIT_USAGE
+	}
+	ctx := context.Background()
+	var records service.RecordService
+	if *server != "" {
+		c := client.NewClient(*server, *key)
+		c.Tenant = *tenantID
+		records = c
+	} else {
+		options := database.DefaultOptions()
+		options.File = *databaseFile
+		options.ReadOnly = true
+		db, err := database.CreateConnection(options)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return EXIT_ERROR
+		}
+		defer db.Close()
+		persistent := service.NewPersistentRecordService(db, service.DefaultLimits())
+		records = &persistent
+		if *tenantID != "" {
+			ctx = tenant.WithID(ctx, *tenantID)
+		}
+	}
+
+	var err error
+	switch command {
+	case "get":
+		err = get(ctx, records, flags.Args(), *version, *at, stdout)
+	case "put":
+		if *databaseFile != "" {
+			fmt.Fprintln(stderr, "put needs -server, -database opens the file read-only")
+			return EXIT_USAGE
+		}
+		err = put(ctx, records, flags.Args(), sets, deletes, *updatesFile, stdout)
+	case "versions":
+		err = versions(ctx, records, flags.Args(), stdout)
+	case "show":
+		err = show(ctx, records, flags.Args(), stdout)
+	case "diff":
+		err = diff(ctx, records, flags.Args(), stdout)
+	case "history":
+		err = history(ctx, records, flags.Args(), stdout)
+	default:
+		err = errUsage
+	}
+	if errors.Is(err, errUsage) {
+		fmt.Fprint(stderr, USAGE)
+		return EXIT_USAGE
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXIT_ERROR
+	}
+	return EXIT_OK
+}
+
+// parseInts parses exactly n positive integer arguments
+func parseInts(args []string, n int) ([]int, error) {
+	if len(args) != n {
+		return nil, errUsage
+	}
+	numbers := make([]int, 0, n)
+	for _, arg := range args {
+		number, err := strconv.Atoi(arg)
+		if err != nil || number <= 0 {
+			return nil, fmt.Errorf("%w: %q must be a positive number", errUsage, arg)
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, nil
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func get(ctx context.Context, records service.RecordService, args []string, version int, at string, w io.Writer) error {
+	ids, err := parseInts(args, 1)
+	if err != nil {
+		return err
+	}
+	var record entity.Record
+	switch {
+	case version > 0 && at != "":
+		return fmt.Errorf("%w: -version and -at are exclusive", errUsage)
+	case version > 0:
+		record, err = records.GetRecordAtVersion(ctx, ids[0], version)
+	case at != "":
+		t, errParse := time.Parse(time.RFC3339, at)
+		if errParse != nil {
+			return fmt.Errorf("%w: -at must be an RFC 3339 time", errUsage)
+		}
+		record, err = records.GetRecordAtTime(ctx, ids[0], t)
+	default:
+		record, err = records.GetRecord(ctx, ids[0])
+	}
+	if err != nil {
+		return err
+	}
+	return printJSON(w, record.GetExternalRecord())
+}
+
+func put(ctx context.Context, records service.RecordService, args []string, sets []string, deletes []string, file string, w io.Writer) error {
+	ids, err := parseInts(args, 1)
+	if err != nil {
+		return err
+	}
+	updates := map[string]*string{}
+	if file != "" {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(content, &updates); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	for _, set := range sets {
+		key, value, ok := strings.Cut(set, "=")
+		if !ok {
+			return fmt.Errorf("%w: -set %q must be KEY=VALUE", errUsage, set)
+		}
+		updates[key] = &value
+	}
+	for _, key := range deletes {
+		updates[key] = nil
+	}
+	if len(updates) == 0 {
+		return fmt.Errorf("%w: put needs -set, -delete or -file", errUsage)
+	}
+
+	record, err := records.UpdateRecord(ctx, ids[0], updates)
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
+		data := map[string]string{}
+		for key, value := range updates {
+			if value != nil {
+				data[key] = *value
+			}
+		}
+		record = entity.Record{ID: ids[0], Data: data, Updates: data, Version: 1}
+		err = records.CreateRecord(ctx, record)
+	}
+	if err != nil {
+		return err
+	}
+	return printJSON(w, record.GetExternalRecord())
+}
+
+func versions(ctx context.Context, records service.RecordService, args []string, w io.Writer) error {
+	ids, err := parseInts(args, 1)
+	if err != nil {
+		return err
+	}
+	versions, err := records.GetRecordVersions(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return service.ErrRecordDoesNotExist
+	}
+	for _, version := range versions {
+		fmt.Fprintln(w, version)
+	}
+	return nil
+}
+
+func show(ctx context.Context, records service.RecordService, args []string, w io.Writer) error {
+	numbers, err := parseInts(args, 2)
+	if err != nil {
+		return err
+	}
+	record, err := records.GetRecordAtVersion(ctx, numbers[0], numbers[1])
+	if err != nil {
+		return err
+	}
+	return printJSON(w, record.GetExternalRecord())
+}
+
+func diff(ctx context.Context, records service.RecordService, args []string, w io.Writer) error {
+	numbers, err := parseInts(args, 3)
+	if err != nil {
+		return err
+	}
+	from, err := records.GetRecordAtVersion(ctx, numbers[0], numbers[1])
+	if err != nil {
+		return fmt.Errorf("version %d: %w", numbers[1], err)
+	}
+	to, err := records.GetRecordAtVersion(ctx, numbers[0], numbers[2])
+	if err != nil {
+		return fmt.Errorf("version %d: %w", numbers[2], err)
+	}
+	for _, line := range changes(from.Data, to.Data) {
+		fmt.Fprintln(w, line)
+	}
+	return nil
+}
+
+// changes lists what differs from one version of the data to another, by key:
+// "+ key: value" for added keys, "- key: value" for removed ones and
+// "~ key: old -> new" for changed ones
+func changes(from map[string]string, to map[string]string) []string {
+	keys := make([]string, 0, len(from)+len(to))
+	for key := range from {
+		keys = append(keys, key)
+	}
+	for key := range to {
+		if _, ok := from[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, 0)
+	for _, key := range keys {
+		before, inFrom := from[key]
+		after, inTo := to[key]
+		switch {
+		case !inFrom:
+			lines = append(lines, fmt.Sprintf("+ %s: %s", key, after))
+		case !inTo:
+			lines = append(lines, fmt.Sprintf("- %s: %s", key, before))
+		case before != after:
+			lines = append(lines, fmt.Sprintf("~ %s: %s -> %s", key, before, after))
+		}
+	}
+	return lines
+}
+
+func history(ctx context.Context, records service.RecordService, args []string, w io.Writer) error {
+	ids, err := parseInts(args, 1)
+	if err != nil {
+		return err
+	}
+	versions, err := records.GetRecordHistory(ctx, ids[0])
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return service.ErrRecordDoesNotExist
+	}
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "VERSION\tRECORDED\tOCCURRED\tAUTHOR\tCHANGES")
+	previous := map[string]string{}
+	for _, version := range versions {
+		fmt.Fprintf(table, "%d\t%s\t%s\t%s\t%s\n", version.Version, version.Timestamp, version.OccurredAt, version.Author,
+			strings.Join(changes(previous, version.Data), "; "))
+		previous = version.Data
+	}
+	return table.Flush()
+}
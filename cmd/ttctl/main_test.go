@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chauvm/timetravel/api"
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/service"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func value(s string) *string { return &s }
+
+// newDatabase writes two versions of record 1 to a fresh database file
+func newDatabase(t *testing.T) string {
+	ctx := context.Background()
+	options := database.DefaultOptions()
+	options.File = filepath.Join(t.TempDir(), "rainbow.db")
+	db, err := database.CreateConnection(options)
+	assert.NoError(t, err)
+	records := service.NewPersistentRecordService(db, service.DefaultLimits())
+	data := map[string]string{"name": "alice", "plan": "basic"}
+	assert.NoError(t, records.CreateRecord(ctx, entity.Record{ID: 1, Data: data, Updates: data, Version: 1}))
+	_, err = records.UpdateRecord(ctx, 1, map[string]*string{"plan": value("premium"), "name": nil, "city": value("Paris")})
+	assert.NoError(t, err)
+	assert.NoError(t, database.Close(db))
+	return options.File
+}
+
+func ttctl(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestOffline(t *testing.T) {
+	file := newDatabase(t)
+
+	code, out, _ := ttctl("get", "-database", file, "1")
+	assert.Equal(t, EXIT_OK, code)
+	assert.JSONEq(t, `{"id":1,"data":{"city":"Paris","plan":"premium"}}`, out)
+	code, out, _ = ttctl("get", "-database", file, "-version", "1", "1")
+	assert.Equal(t, EXIT_OK, code)
+	assert.JSONEq(t, `{"id":1,"data":{"name":"alice","plan":"basic"}}`, out)
+
+	code, out, _ = ttctl("versions", "-database", file, "1")
+	assert.Equal(t, EXIT_OK, code)
+	assert.Equal(t, "2\n1\n", out)
+
+	code, out, _ = ttctl("diff", "-database", file, "1", "1", "2")
+	assert.Equal(t, EXIT_OK, code)
+	assert.Equal(t, "+ city: Paris\n- name: alice\n~ plan: basic -> premium\n", out)
+
+	code, out, _ = ttctl("history", "-database", file, "1")
+	assert.Equal(t, EXIT_OK, code)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "VERSION"))
+	assert.Contains(t, lines[2], "+ city: Paris; - name: alice; ~ plan: basic -> premium")
+
+	code, _, errOut := ttctl("show", "-database", file, "2", "1")
+	assert.Equal(t, EXIT_ERROR, code)
+	assert.Contains(t, errOut, "does not exist")
+
+	// the file is never written to
+	code, _, _ = ttctl("put", "-database", file, "-set", "plan=gold", "1")
+	assert.Equal(t, EXIT_USAGE, code)
+	code, _, _ = ttctl("get", "1")
+	assert.Equal(t, EXIT_USAGE, code)
+}
+
+func TestOverHTTP(t *testing.T) {
+	file := newDatabase(t)
+	options := database.DefaultOptions()
+	options.File = file
+	db, err := database.CreateConnection(options)
+	assert.NoError(t, err)
+	defer db.Close()
+	records := service.NewPersistentRecordService(db, service.DefaultLimits())
+	router := mux.NewRouter()
+	api.NewAPIV2(&records).CreateRoutes(router.PathPrefix("/api/v2").Subrouter())
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	code, out, _ := ttctl("put", "-server", server.URL, "-set", "plan=gold", "-delete", "city", "1")
+	assert.Equal(t, EXIT_OK, code)
+	assert.JSONEq(t, `{"id":1,"data":{"plan":"gold"}}`, out)
+	code, out, _ = ttctl("put", "-server", server.URL, "-set", "name=bob", "2")
+	assert.Equal(t, EXIT_OK, code)
+	assert.JSONEq(t, `{"id":2,"data":{"name":"bob"}}`, out)
+
+	code, out, _ = ttctl("diff", "-server", server.URL, "1", "2", "3")
+	assert.Equal(t, EXIT_OK, code)
+	assert.Equal(t, "- city: Paris\n~ plan: premium -> gold\n", out)
+}
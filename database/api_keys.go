@@ -0,0 +1,82 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/chauvm/timetravel/entity"
+)
+
+var ErrAPIKeyDoesNotExist = errors.New("api key does not exist or is revoked")
+
+// InsertAPIKey stores the hash of a new key, it fails with ErrConflict if the name is taken.
+// A key bound to no tenant, "", acts on the default tenant, or on any tenant if its role
+// grants auth.PERMISSION_ANY_TENANT.
+//
+// Keys are not data of a tenant, the api_keys queries are not scoped to the tenant of ctx.
+func InsertAPIKey(ctx context.Context, db *sql.DB, name string, hash string, role string, tenantID string) error {
+	defer observeQuery("insert_api_key", time.Now())
+	var boundTo interface{}
+	if tenantID != "" {
+		boundTo = tenantID
+	}
+	_, err := db.ExecContext(ctx, "INSERT INTO api_keys (name, hash, role, tenant, created_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)",
+		name, hash, role, boundTo)
+	return translateError(err)
+}
+
+// GetAPIKeyByHash returns the key with that hash unless it is revoked, or ErrNotFound.
+func GetAPIKeyByHash(ctx context.Context, db *sql.DB, hash string) (*entity.APIKey, error) {
+	defer observeQuery("get_api_key_by_hash", time.Now())
+	row := db.QueryRowContext(ctx, "SELECT name, role, tenant, created_at, revoked_at FROM api_keys WHERE hash = ? AND revoked_at IS NULL", hash)
+	return scanAPIKey(row)
+}
+
+// RevokeAPIKey revokes the key of that name for good.
+func RevokeAPIKey(ctx context.Context, db *sql.DB, name string) error {
+	defer observeQuery("revoke_api_key", time.Now())
+	res, err := db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE name = ? AND revoked_at IS NULL", name)
+	if err != nil {
+		return translateError(err)
+	}
+	if revoked, err := res.RowsAffected(); err != nil {
+		return translateError(err)
+	} else if revoked == 0 {
+		return ErrAPIKeyDoesNotExist
+	}
+	return nil
+}
+
+// GetAPIKeys returns every key, revoked ones included, oldest first.
+func GetAPIKeys(ctx context.Context, db *sql.DB) ([]entity.APIKey, error) {
+	defer observeQuery("get_api_keys", time.Now())
+	rows, err := db.QueryContext(ctx, "SELECT name, role, tenant, created_at, revoked_at FROM api_keys ORDER BY id ASC")
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	keys := make([]entity.APIKey, 0)
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, translateError(rows.Err())
+}
+
+func scanAPIKey(row rowScanner) (*entity.APIKey, error) {
+	key := entity.APIKey{}
+	var tenantID sql.NullString
+	var revokedAt sql.NullString
+	if err := row.Scan(&key.Name, &key.Role, &tenantID, &key.CreatedAt, &revokedAt); err != nil {
+		return nil, translateError(err)
+	}
+	key.Tenant = tenantID.String
+	key.RevokedAt = revokedAt.String
+	return &key, nil
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chauvm/timetravel/entity"
+)
+
+var ErrBackupInvalid = errors.New("backup is not a valid database")
+var ErrBackupBehind = errors.New("backup has fewer rows than the database it would replace")
+
+// BACKUP_TIME_FORMAT is the layout of the time in the name of a backup file, UTC
+const BACKUP_TIME_FORMAT string = "20060102T150405.000Z"
+
+// BACKUP_TABLES are the tables counted when a backup is verified
+var BACKUP_TABLES = []string{"records", "legal_holds", "legal_hold_events", "api_keys"}
+
+// BackupFile returns the path of a backup of the database file taken at the given time,
+// rainbow.db is backed up to dir/rainbow-20240102T030405.000Z.db.
+func BackupFile(dir string, file string, at time.Time) string {
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	return filepath.Join(dir, fmt.Sprintf("%s-%s.db", name, at.UTC().Format(BACKUP_TIME_FORMAT)))
+}
+
+// Backup writes a consistent copy of the database to a new file with VACUUM INTO, the
+// server may keep reading and writing meanwhile. The file must not exist yet.
+func Backup(ctx context.Context, db *sql.DB, file string) error {
+	defer observeQuery("backup", time.Now())
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("%w: %s", ErrConflict, file)
+	}
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", file); err != nil {
+		// do not leave a partial copy behind for a restore to pick up
+		os.Remove(file)
+		return translateError(err)
+	}
+	return nil
+}
+
+// VerifyDatabase opens the database file read-only and checks it could be served by this
+// build: it passes SQLite's integrity check and every migration of this build, and no
+// other, is applied. It reports the size, schema version and rows of the file.
+//
+// Every failure wraps ErrBackupInvalid.
+func VerifyDatabase(ctx context.Context, file string) (entity.Backup, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return entity.Backup{}, fmt.Errorf("%w: %w", ErrBackupInvalid, err)
+	}
+	if !info.Mode().IsRegular() {
+		return entity.Backup{}, fmt.Errorf("%w: %s is not a file", ErrBackupInvalid, file)
+	}
+
+	options := DefaultOptions()
+	options.File = file
+	options.ReadOnly = true
+	db, err := CreateConnection(options)
+	if err != nil {
+		return entity.Backup{}, fmt.Errorf("%w: %w", ErrBackupInvalid, err)
+	}
+	defer db.Close()
+
+	if err := checkIntegrity(ctx, db); err != nil {
+		return entity.Backup{}, fmt.Errorf("%w: %w", ErrBackupInvalid, err)
+	}
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return entity.Backup{}, fmt.Errorf("%w: %w", ErrBackupInvalid, translateError(err))
+	}
+	rows, err := countRows(ctx, db)
+	if err != nil {
+		return entity.Backup{}, fmt.Errorf("%w: %w", ErrBackupInvalid, err)
+	}
+	return entity.Backup{File: file, Bytes: info.Size(), SchemaVersion: version, Rows: rows}, nil
+}
+
+// checkIntegrity runs PRAGMA integrity_check, it fails with the problems SQLite found.
+func checkIntegrity(ctx context.Context, db *sql.DB) error {
+	defer observeQuery("integrity_check", time.Now())
+	rows, err := db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return translateError(err)
+	}
+	defer rows.Close()
+
+	problems := make([]string, 0)
+	for rows.Next() {
+		var problem string
+		if err := rows.Scan(&problem); err != nil {
+			return translateError(err)
+		}
+		if problem != "ok" {
+			problems = append(problems, problem)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return translateError(err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: integrity check: %s", ErrCorrupt, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// countRows counts the rows of every table of BACKUP_TABLES, of every tenant.
+func countRows(ctx context.Context, db *sql.DB) (map[string]int, error) {
+	defer observeQuery("count_rows", time.Now())
+	counts := make(map[string]int, len(BACKUP_TABLES))
+	for _, table := range BACKUP_TABLES {
+		var count int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&count); err != nil {
+			return nil, translateError(err)
+		}
+		counts[table] = count
+	}
+	return counts, nil
+}
+
+// Restore replaces the database file target with the backup file, which is verified first.
+// The server must be stopped, the file is swapped underneath any open connection.
+//
+// Unless forced, Restore refuses with ErrBackupBehind a backup with fewer rows in any table
+// than the target, it would lose data. A target that does not verify is always replaced.
+// The target, and its write-ahead log if any, are moved aside to a .pre-restore file
+// whose path is returned, "" when there was no target.
+func Restore(ctx context.Context, backup string, target string, force bool) (string, error) {
+	// verify the copy that is swapped in, not the file it was copied from
+	restoring := target + ".restoring"
+	if err := copyFile(backup, restoring); err != nil {
+		return "", err
+	}
+	defer os.Remove(restoring)
+	restored, err := VerifyDatabase(ctx, restoring)
+	if err != nil {
+		return "", err
+	}
+
+	previous := ""
+	if _, err := os.Stat(target); err == nil {
+		current, err := VerifyDatabase(ctx, target)
+		if err == nil && !force {
+			for _, table := range BACKUP_TABLES {
+				if restored.Rows[table] < current.Rows[table] {
+					return "", fmt.Errorf("%w: %s has %d rows in %s, the database %d",
+						ErrBackupBehind, backup, restored.Rows[table], table, current.Rows[table])
+				}
+			}
+		}
+
+		previous = fmt.Sprintf("%s.pre-restore-%s", target, time.Now().UTC().Format(BACKUP_TIME_FORMAT))
+		// a write-ahead log left by the replaced database would be replayed onto the backup
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			if err := os.Rename(target+suffix, previous+suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return "", err
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	if err := os.Rename(restoring, target); err != nil {
+		return "", err
+	}
+	return previous, nil
+}
+
+// copyFile copies the file src to dst, which is replaced, and syncs it to disk.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBackupInvalid, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chauvm/timetravel/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupFile(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 600_000_000, time.UTC)
+	assert.Equal(t, filepath.Join("backups", "rainbow-20240102T030405.600Z.db"), BackupFile("backups", "./data/rainbow.db", at))
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	options := DefaultOptions()
+	options.File = filepath.Join(dir, "rainbow.db")
+	// the server keeps writing to the write-ahead log while the backup is taken
+	options.JournalMode = "WAL"
+	db, err := CreateConnection(options)
+	assert.NoError(t, err)
+	defer db.Close()
+	_, err = InsertRecord(ctx, db, entity.Record{ID: 1, Version: 1, Data: map[string]string{"hello": "world"}})
+	assert.NoError(t, err)
+
+	backup := BackupFile(filepath.Join(dir, "backups"), options.File, time.Now())
+	assert.NoError(t, Backup(ctx, db, backup))
+	assert.True(t, errors.Is(Backup(ctx, db, backup), ErrConflict))
+
+	report, err := VerifyDatabase(ctx, backup)
+	assert.NoError(t, err)
+	assert.Equal(t, backup, report.File)
+	assert.Greater(t, report.Bytes, int64(0))
+	migrations, _ := Migrations()
+	assert.Equal(t, len(migrations), report.SchemaVersion)
+	assert.Equal(t, map[string]int{"records": 1, "legal_holds": 0, "legal_hold_events": 0, "api_keys": 0}, report.Rows)
+
+	// the database moved on since the backup, restoring it would lose a version
+	_, err = InsertRecord(ctx, db, entity.Record{ID: 1, Version: 2, Data: map[string]string{"hello": "again"}})
+	assert.NoError(t, err)
+	assert.NoError(t, Close(db))
+	_, err = Restore(ctx, backup, options.File, false)
+	assert.True(t, errors.Is(err, ErrBackupBehind))
+
+	previous, err := Restore(ctx, backup, options.File, true)
+	assert.NoError(t, err)
+	assert.FileExists(t, previous)
+	_, err = os.Stat(options.File + ".restoring")
+	assert.True(t, os.IsNotExist(err))
+
+	db, err = CreateConnection(options)
+	assert.NoError(t, err)
+	defer db.Close()
+	record, err := GetLatestRecord(ctx, db, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, record.Version)
+
+	// a database that does not exist yet is restored as is
+	previous, err = Restore(ctx, backup, filepath.Join(dir, "new.db"), false)
+	assert.NoError(t, err)
+	assert.Equal(t, "", previous)
+}
+
+func TestVerifyDatabaseRejectsInvalidBackups(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	_, err := VerifyDatabase(ctx, filepath.Join(dir, "missing.db"))
+	assert.True(t, errors.Is(err, ErrBackupInvalid))
+
+	garbage := filepath.Join(dir, "garbage.db")
+	assert.NoError(t, os.WriteFile(garbage, []byte("not a database, not even close to one"), 0o600))
+	_, err = VerifyDatabase(ctx, garbage)
+	assert.True(t, errors.Is(err, ErrBackupInvalid))
+
+	// written by a newer build
+	newer := filepath.Join(dir, "newer.db")
+	db := openTestDB(t)
+	assert.NoError(t, Migrate(db))
+	_, err = db.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (999, 'from_the_future', CURRENT_TIMESTAMP)")
+	assert.NoError(t, err)
+	assert.NoError(t, Backup(ctx, db, newer))
+	_, err = VerifyDatabase(ctx, newer)
+	assert.True(t, errors.Is(err, ErrBackupInvalid))
+	assert.True(t, errors.Is(err, ErrSchemaTooNew))
+
+	// an invalid backup never replaces the database
+	target := filepath.Join(dir, "rainbow.db")
+	assert.NoError(t, os.WriteFile(target, []byte("kept"), 0o600))
+	_, err = Restore(ctx, garbage, target, true)
+	assert.True(t, errors.Is(err, ErrBackupInvalid))
+	content, _ := os.ReadFile(target)
+	assert.Equal(t, "kept", string(content))
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// CheckpointResult is the outcome of PRAGMA wal_checkpoint.
+type CheckpointResult struct {
+	// Busy is set when readers or writers kept the checkpoint from completing
+	Busy bool
+	// Frames is the number of frames in the write-ahead log, Checkpointed how many of
+	// them are now in the database file
+	Frames       int
+	Checkpointed int
+}
+
+// Checkpoint copies the write-ahead log back into the database file. The mode is one of
+// PASSIVE, FULL, RESTART or TRUNCATE, see PRAGMA wal_checkpoint.
+func Checkpoint(ctx context.Context, db *sql.DB, mode string) (CheckpointResult, error) {
+	defer observeQuery("checkpoint", time.Now())
+	var busy int
+	var result CheckpointResult
+	err := db.QueryRowContext(ctx, fmt.Sprintf("PRAGMA wal_checkpoint(%s)", mode)).Scan(&busy, &result.Frames, &result.Checkpointed)
+	result.Busy = busy != 0
+	return result, translateError(err)
+}
+
+// HoldSnapshot starts a read transaction and reads from it, it sees the database as it is
+// until it is rolled back. Meanwhile no checkpoint copies the frames written after it into
+// the database file and the write-ahead log is not restarted.
+func HoldSnapshot(ctx context.Context, db *sql.DB) (*sql.Tx, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	// a deferred transaction only takes its read lock on the first read
+	var version sql.NullInt64
+	if err := tx.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		tx.Rollback()
+		return nil, translateError(err)
+	}
+	return tx, nil
+}
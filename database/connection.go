@@ -1,44 +1,103 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/logging"
+	"github.com/chauvm/timetravel/tenant"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 const DATABASE_FILE string = "./rainbow.db"
 const DATABASE_FILE_UNIT_TEST string = "./rainbow_test.db"
 
-const INIT_DB string = `
- CREATE TABLE IF NOT EXISTS records (
- id INTEGER NOT NULL,
- timestamp DATETIME NOT NULL,
- data STRING NOT NULL,
- updates STRING,
- version INTEGER NOT NULL,
- PRIMARY KEY (id ASC, version DESC)
- );`
+// TIMESTAMP_FORMAT is the layout SQLite's CURRENT_TIMESTAMP writes to the timestamp column
+const TIMESTAMP_FORMAT string = "2006-01-02 15:04:05"
+
+// LEGAL_HOLD_MESSAGE is the error the triggers of migrations/0003_create_legal_holds.sql
+// raise when a held record is deleted or rewritten
+const LEGAL_HOLD_MESSAGE string = "record is under legal hold"
+
+// Options configures the SQLite database file and the pragmas set on every connection.
+type Options struct {
+	File        string
+	BusyTimeout time.Duration
+	JournalMode string
+	Synchronous string
+	ForeignKeys bool
+	// ReadOnly opens an existing, fully migrated database without ever writing to it,
+	// for forensics
+	ReadOnly bool
+}
+
+// DefaultOptions opens ./rainbow.db with SQLite's defaults and a 5s busy timeout.
+func DefaultOptions() Options {
+	return Options{
+		File:        DATABASE_FILE,
+		BusyTimeout: 5 * time.Second,
+		JournalMode: "DELETE",
+		Synchronous: "FULL",
+		ForeignKeys: false,
+	}
+}
+
+// DSN returns the go-sqlite3 data source name applying the pragmas.
+func (o Options) DSN() string {
+	params := url.Values{}
+	params.Set("_busy_timeout", strconv.FormatInt(o.BusyTimeout.Milliseconds(), 10))
+	if o.ReadOnly {
+		// switching the journal mode of the file would write to it
+		params.Set("mode", "ro")
+	} else {
+		params.Set("_journal_mode", o.JournalMode)
+	}
+	params.Set("_synchronous", o.Synchronous)
+	params.Set("_foreign_keys", strconv.FormatBool(o.ForeignKeys))
+	return fmt.Sprintf("file:%s?%s", o.File, params.Encode())
+}
 
 // create a SQLite3 database connection, or create the SQLite file if not existed yet
-func CreateConnection() (*sql.DB, error) {
-	log.Println("Creating database connection...")
-	db, err := sql.Open("sqlite3", DATABASE_FILE)
+func CreateConnection(options Options) (*sql.DB, error) {
+	log.Printf("Creating database connection to %s...", options.File)
+	db, err := sql.Open("sqlite3", options.DSN())
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
-	// create the table if not existed yet
-	if _, err := db.Exec(INIT_DB); err != nil {
-		log.Fatal(err)
-		return nil, err
+	if options.ReadOnly {
+		// the schema cannot be upgraded, it must already be the one of this build
+		if err := CheckMigrations(context.Background(), db); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return db, nil
+	}
+	// create or upgrade the tables
+	if err := Migrate(db); err != nil {
+		db.Close()
+		return nil, translateError(err)
 	}
 	return db, nil
 }
 
+// Close checkpoints the write-ahead log, if any, back into the database file and closes
+// the database once the queries in flight are done.
+func Close(db *sql.DB) error {
+	if _, err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		db.Close()
+		return err
+	}
+	return db.Close()
+}
+
 func CreateConnectionUnitTests() (*sql.DB, error) {
 	// if DATABASE_FILE_UNIT_TEST exists, remove it
 	if err := os.Remove(DATABASE_FILE_UNIT_TEST); err != nil {
@@ -46,69 +105,121 @@ func CreateConnectionUnitTests() (*sql.DB, error) {
 	}
 	db, err := sql.Open("sqlite3", DATABASE_FILE_UNIT_TEST)
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
-	// create the table if not existed yet
-	if _, err := db.Exec(INIT_DB); err != nil {
-		log.Fatal(err)
-		return nil, err
+	// create or upgrade the tables
+	if err := Migrate(db); err != nil {
+		db.Close()
+		return nil, translateError(err)
 	}
 	return db, nil
 }
 
-func InsertRecord(db *sql.DB, record entity.Record) (int, error) {
-	log.Printf("InsertRecord in database %v", record)
+func InsertRecord(ctx context.Context, db *sql.DB, record entity.Record) (int, error) {
+	defer observeQuery("insert_record", time.Now())
+	logging.FromContext(ctx).Debug("insert record", "record_id", record.ID, "version", record.Version, "data", logging.Payload(record.Data))
 	dataJson, err := json.Marshal(record.Data)
 	if err != nil {
-		return 0, err
+		return 0, translateError(err)
 	}
 	updatesJson, err := json.Marshal(record.Updates)
 	if err != nil {
-		return 0, err
+		return 0, translateError(err)
+	}
+	// occurred_at is only known when the client reported it
+	var occurredAt interface{}
+	if record.OccurredAt != "" {
+		at, err := time.Parse(time.RFC3339, record.OccurredAt)
+		if err != nil {
+			return 0, translateError(err)
+		}
+		occurredAt = at.UTC().Format(TIMESTAMP_FORMAT)
+	}
+	var author interface{}
+	if record.Author != "" {
+		author = record.Author
 	}
-	res, err := db.Exec("INSERT INTO records (id, version, timestamp, data, updates) VALUES (?, ?, CURRENT_TIMESTAMP, ?, ?)",
-		record.ID, record.Version, dataJson, updatesJson)
+	res, err := db.ExecContext(ctx, "INSERT INTO records (tenant, id, version, timestamp, data, updates, occurred_at, author) VALUES (?, ?, ?, CURRENT_TIMESTAMP, ?, ?, ?, ?)",
+		tenant.ID(ctx), record.ID, record.Version, dataJson, updatesJson, occurredAt, author)
 
 	if err != nil {
-		return 0, err
+		return 0, translateError(err)
 	}
 
 	var id int64
 	if id, err = res.LastInsertId(); err != nil {
-		return 0, err
+		return 0, translateError(err)
 	}
 	return int(id), nil
 }
 
-func GetLatestRecord(db *sql.DB, id int) (*entity.Record, error) {
-	row := db.QueryRow("SELECT id, timestamp, data, updates, version FROM records WHERE id = ? ORDER BY timestamp DESC LIMIT 1", id)
-	record := entity.Record{}
+func GetLatestRecord(ctx context.Context, db *sql.DB, id int) (*entity.Record, error) {
+	defer observeQuery("get_latest_record", time.Now())
+	row := db.QueryRowContext(ctx, "SELECT id, timestamp, data, updates, version, occurred_at, author FROM records WHERE tenant = ? AND id = ? ORDER BY version DESC LIMIT 1", tenant.ID(ctx), id)
+	return scanRecord(row)
+}
+
+// GetRecordAtTime returns the version of the record that was in force at the given time,
+// i.e. the latest version recorded at or before it.
+func GetRecordAtTime(ctx context.Context, db *sql.DB, id int, at time.Time) (*entity.Record, error) {
+	defer observeQuery("get_record_at_time", time.Now())
+	row := db.QueryRowContext(ctx, "SELECT id, timestamp, data, updates, version, occurred_at, author FROM records WHERE tenant = ? AND id = ? AND timestamp <= ? ORDER BY version DESC LIMIT 1",
+		tenant.ID(ctx), id, at.UTC().Format(TIMESTAMP_FORMAT))
+	return scanRecord(row)
+}
 
-	if row == nil {
-		return &record, nil
+// GetRecordHistory returns every version of the record, oldest first.
+func GetRecordHistory(ctx context.Context, db *sql.DB, id int) ([]*entity.Record, error) {
+	defer observeQuery("get_record_history", time.Now())
+	rows, err := db.QueryContext(ctx, "SELECT id, timestamp, data, updates, version, occurred_at, author FROM records WHERE tenant = ? AND id = ? ORDER BY version ASC", tenant.ID(ctx), id)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	records := make([]*entity.Record, 0)
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		records = append(records, record)
 	}
+	return records, translateError(rows.Err())
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanRecord parses a (id, timestamp, data, updates, version, occurred_at, author) row into a record
+func scanRecord(row rowScanner) (*entity.Record, error) {
+	record := entity.Record{}
 
-	// parse the row and put in the record
 	var rawData string
 	var rawUpdates string
-	err := row.Scan(&record.ID, &record.Timestamp, &rawData, &rawUpdates, &record.Version)
+	var occurredAt sql.NullString
+	var author sql.NullString
+	err := row.Scan(&record.ID, &record.Timestamp, &rawData, &rawUpdates, &record.Version, &occurredAt, &author)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
+	record.OccurredAt = occurredAt.String
+	record.Author = author.String
 
 	// parse the insertion data
 	var data map[string]string = make(map[string]string)
 	err = json.Unmarshal([]byte(rawData), &data)
 	if err != nil {
-		return &record, err
+		return &record, fmt.Errorf("%w: data of record %d version %d: %w", ErrCorrupt, record.ID, record.Version, err)
 	}
 
 	// parse the updates data
 	var updates map[string]string = make(map[string]string)
 	err = json.Unmarshal([]byte(rawUpdates), &updates)
 	if err != nil {
-		return &record, err
+		return &record, fmt.Errorf("%w: updates of record %d version %d: %w", ErrCorrupt, record.ID, record.Version, err)
 	}
 
 	record.Data = data
@@ -121,7 +232,7 @@ func GetLatestRecord(db *sql.DB, id int) (*entity.Record, error) {
 // func GetRecords(db *sql.DB, id int) ([]*entity.Record, error) {
 // 	rows, err := db.Query("SELECT * FROM records WHERE id = ?;", id)
 // 	if err != nil {
-// 		return nil, err
+// 		return nil, translateError(err)
 // 	}
 // 	defer rows.Close()
 
@@ -130,65 +241,156 @@ func GetLatestRecord(db *sql.DB, id int) (*entity.Record, error) {
 // 		var record entity.Record
 // 		err = rows.Scan(&record.ID, &record.Timestamp, &record.Data, &record.Updates, &record.Version)
 // 		if err != nil {
-// 			return nil, err
+// 			return nil, translateError(err)
 // 		}
 // 		records = append(records, &record)
 // 	}
 // 	return records, nil
 // }
 
-func GetRecordVersions(db *sql.DB, id int) ([]int, error) {
-	rows, err := db.Query("SELECT version FROM records WHERE id = ? ORDER BY version DESC", id)
+// GetLateVersions returns every version whose client-reported occurrence time precedes
+// the time it was recorded by more than the threshold, oldest first.
+func GetLateVersions(ctx context.Context, db *sql.DB, threshold time.Duration) ([]*entity.Record, error) {
+	defer observeQuery("get_late_versions", time.Now())
+	rows, err := db.QueryContext(ctx, `SELECT id, timestamp, data, updates, version, occurred_at, author FROM records
+		WHERE tenant = ? AND occurred_at IS NOT NULL AND (julianday(timestamp) - julianday(occurred_at)) * 86400 > ?
+		ORDER BY timestamp ASC, id ASC, version ASC`, tenant.ID(ctx), threshold.Seconds())
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 	defer rows.Close()
 
-	versions := make([]int, 0)
+	records := make([]*entity.Record, 0)
 	for rows.Next() {
-		var version int
-		err = rows.Scan(&version)
+		record, err := scanRecord(rows)
 		if err != nil {
-			return nil, err
+			return nil, translateError(err)
 		}
-		versions = append(versions, version)
-
+		records = append(records, record)
 	}
-	return versions, nil
+	return records, translateError(rows.Err())
 }
 
-func GetRecordAtVersion(db *sql.DB, id int, version int) (*entity.Record, error) {
-	row := db.QueryRow("SELECT id, timestamp, data, updates, version FROM records WHERE id = ? AND version = ?", id, version)
-	record := entity.Record{}
+// GetRecordIDs returns the ids of every record.
+func GetRecordIDs(ctx context.Context, db *sql.DB) ([]int, error) {
+	defer observeQuery("get_record_ids", time.Now())
+	rows, err := db.QueryContext(ctx, "SELECT DISTINCT id FROM records WHERE tenant = ? ORDER BY id ASC", tenant.ID(ctx))
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
 
-	if row == nil {
-		return &record, nil
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, translateError(err)
+		}
+		ids = append(ids, id)
 	}
+	return ids, translateError(rows.Err())
+}
 
-	// parse the row and put in the record
-	var rawData string
-	var rawUpdates string
-	err := row.Scan(&record.ID, &record.Timestamp, &rawData, &rawUpdates, &record.Version)
+// DeleteRecordVersions removes versions of a record in a single transaction.
+//
+// Every row keeps the full data of its version, so the remaining versions stay readable.
+// The updates of a version that follows removed ones are rewritten to be relative to the
+// version now preceding it.
+func DeleteRecordVersions(ctx context.Context, db *sql.DB, id int, versions []int) error {
+	defer observeQuery("delete_record_versions", time.Now())
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return translateError(err)
 	}
+	defer tx.Rollback()
 
-	// parse the insertion data
-	var data map[string]string = make(map[string]string)
-	err = json.Unmarshal([]byte(rawData), &data)
+	for _, version := range versions {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM records WHERE tenant = ? AND id = ? AND version = ?", tenant.ID(ctx), id, version); err != nil {
+			return translateError(err)
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, timestamp, data, updates, version, occurred_at, author FROM records WHERE tenant = ? AND id = ? ORDER BY version ASC", tenant.ID(ctx), id)
 	if err != nil {
-		return &record, err
+		return translateError(err)
+	}
+	remaining := make([]*entity.Record, 0)
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			rows.Close()
+			return translateError(err)
+		}
+		remaining = append(remaining, record)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return translateError(err)
 	}
 
-	// parse the updates data
-	var updates map[string]string = make(map[string]string)
-	err = json.Unmarshal([]byte(rawUpdates), &updates)
+	for i, record := range remaining {
+		if i == 0 || !removedBetween(versions, remaining[i-1].Version, record.Version) {
+			continue
+		}
+		updates := map[string]string{}
+		for key, value := range record.Data {
+			if previous, ok := remaining[i-1].Data[key]; !ok || previous != value {
+				updates[key] = value
+			}
+		}
+		updatesJson, err := json.Marshal(updates)
+		if err != nil {
+			return translateError(err)
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE records SET updates = ? WHERE tenant = ? AND id = ? AND version = ?", updatesJson, tenant.ID(ctx), id, record.Version); err != nil {
+			return translateError(err)
+		}
+	}
+
+	return translateError(tx.Commit())
+}
+
+// removedBetween reports whether any of the removed versions lies strictly between from and to
+func removedBetween(removed []int, from int, to int) bool {
+	for _, version := range removed {
+		if version > from && version < to {
+			return true
+		}
+	}
+	return false
+}
+
+func GetRecordVersions(ctx context.Context, db *sql.DB, id int) ([]int, error) {
+	defer observeQuery("get_record_versions", time.Now())
+	rows, err := db.QueryContext(ctx, "SELECT version FROM records WHERE tenant = ? AND id = ? ORDER BY version DESC", tenant.ID(ctx), id)
 	if err != nil {
-		return &record, err
+		return nil, translateError(err)
 	}
+	defer rows.Close()
 
-	record.Data = data
-	record.Updates = updates
+	versions := make([]int, 0)
+	for rows.Next() {
+		var version int
+		err = rows.Scan(&version)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		versions = append(versions, version)
 
-	return &record, nil
+	}
+	return versions, translateError(rows.Err())
+}
+
+func GetRecordAtVersion(ctx context.Context, db *sql.DB, id int, version int) (*entity.Record, error) {
+	defer observeQuery("get_record_at_version", time.Now())
+	row := db.QueryRowContext(ctx, "SELECT id, timestamp, data, updates, version, occurred_at, author FROM records WHERE tenant = ? AND id = ? AND version = ?", tenant.ID(ctx), id, version)
+	return scanRecord(row)
+}
+
+// CountRecordVersions returns how many versions of the record are kept, 0 if it does not exist.
+func CountRecordVersions(ctx context.Context, db *sql.DB, id int) (int, error) {
+	defer observeQuery("count_record_versions", time.Now())
+	var versions int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM records WHERE tenant = ? AND id = ?", tenant.ID(ctx), id).Scan(&versions)
+	return versions, translateError(err)
 }
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/tenant"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloseCheckpointsWAL(t *testing.T) {
+	ctx := context.Background()
+	options := DefaultOptions()
+	options.File = filepath.Join(t.TempDir(), "rainbow.db")
+	options.JournalMode = "WAL"
+
+	db, err := CreateConnection(options)
+	assert.NoError(t, err)
+	_, err = InsertRecord(ctx, db, entity.Record{ID: 1, Version: 1, Data: map[string]string{"hello": "world"}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, Close(db))
+
+	// everything is in the database file, the write-ahead log is gone
+	_, err = os.Stat(options.File + "-wal")
+	assert.True(t, os.IsNotExist(err))
+
+	db, err = CreateConnection(options)
+	assert.NoError(t, err)
+	defer db.Close()
+	record, err := GetLatestRecord(ctx, db, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"hello": "world"}, record.Data)
+}
+
+func TestTenantIsolation(t *testing.T) {
+	options := DefaultOptions()
+	options.File = filepath.Join(t.TempDir(), "rainbow.db")
+	db, err := CreateConnection(options)
+	assert.NoError(t, err)
+	defer db.Close()
+	acme := tenant.WithID(context.Background(), "acme")
+	globex := tenant.WithID(context.Background(), "globex")
+
+	// the same id in two tenants
+	_, err = InsertRecord(acme, db, entity.Record{ID: 1, Version: 1, Data: map[string]string{"owner": "acme"}})
+	assert.NoError(t, err)
+	_, err = InsertRecord(globex, db, entity.Record{ID: 1, Version: 1, Data: map[string]string{"owner": "globex"}})
+	assert.NoError(t, err)
+
+	record, err := GetLatestRecord(acme, db, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", record.Data["owner"])
+	_, err = GetLatestRecord(context.Background(), db, 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	tenants, err := GetTenants(context.Background(), db)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"acme", "globex"}, tenants)
+
+	// a hold only covers the record of its tenant
+	assert.NoError(t, PlaceLegalHold(acme, db, 1, "legal", "case 42"))
+	_, err = DeleteTenant(acme, db)
+	assert.ErrorIs(t, err, ErrRecordOnHold)
+	// a released hold does not keep the tenant, and its history outlives the records
+	assert.NoError(t, PlaceLegalHold(globex, db, 1, "legal", "case 43"))
+	assert.NoError(t, ReleaseLegalHold(globex, db, 1, "legal", "settled"))
+	deleted, err := DeleteTenant(globex, db)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	events, err := GetLegalHoldEvents(globex, db, 1)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	records, err := GetTenantRecords(acme, db)
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	records, err = GetTenantRecords(globex, db)
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	options := DefaultOptions()
+	options.File = filepath.Join(t.TempDir(), "rainbow.db")
+	options.ReadOnly = true
+
+	// a missing file is not created
+	_, err := CreateConnection(options)
+	assert.Error(t, err)
+	_, err = os.Stat(options.File)
+	assert.True(t, os.IsNotExist(err))
+
+	options.ReadOnly = false
+	db, err := CreateConnection(options)
+	assert.NoError(t, err)
+	_, err = InsertRecord(ctx, db, entity.Record{ID: 1, Version: 1, Data: map[string]string{"hello": "world"}})
+	assert.NoError(t, err)
+	assert.NoError(t, Close(db))
+
+	options.ReadOnly = true
+	db, err = CreateConnection(options)
+	assert.NoError(t, err)
+	defer db.Close()
+	record, err := GetLatestRecord(ctx, db, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"hello": "world"}, record.Data)
+	_, err = InsertRecord(ctx, db, entity.Record{ID: 2, Version: 1, Data: map[string]string{}})
+	assert.Error(t, err)
+}
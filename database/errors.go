@@ -0,0 +1,54 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Errors returned by the database package, they wrap the driver error they stand for.
+var (
+	ErrNotFound   = errors.New("row not found")
+	ErrConflict   = errors.New("row already exists")
+	ErrBusy       = errors.New("database is busy or locked")
+	ErrConstraint = errors.New("constraint violation")
+	ErrCorrupt    = errors.New("database is corrupt")
+)
+
+// ErrRecordOnHold is the constraint raised by the legal hold triggers.
+var ErrRecordOnHold = fmt.Errorf("%w: %s", ErrConstraint, LEGAL_HOLD_MESSAGE)
+
+// translateError turns a driver error into one of the errors above, keeping the original.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+	switch sqliteErr.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked:
+		busyErrors.Inc()
+		return fmt.Errorf("%w: %w", ErrBusy, err)
+	case sqlite3.ErrCorrupt, sqlite3.ErrNotADB:
+		return fmt.Errorf("%w: %w", ErrCorrupt, err)
+	case sqlite3.ErrConstraint:
+		switch {
+		case sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey, sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique:
+			return fmt.Errorf("%w: %w", ErrConflict, err)
+		case strings.Contains(sqliteErr.Error(), LEGAL_HOLD_MESSAGE):
+			return ErrRecordOnHold
+		default:
+			return fmt.Errorf("%w: %w", ErrConstraint, err)
+		}
+	}
+	return err
+}
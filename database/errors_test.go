@@ -0,0 +1,33 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/chauvm/timetravel/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranslateError(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	assert.NoError(t, Migrate(db))
+
+	_, err := GetLatestRecord(ctx, db, 1)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	record := entity.Record{ID: 1, Data: map[string]string{"hello": "world"}, Version: 1}
+	_, err = InsertRecord(ctx, db, record)
+	assert.NoError(t, err)
+
+	// a racing insert of the same version
+	_, err = InsertRecord(ctx, db, record)
+	assert.True(t, errors.Is(err, ErrConflict))
+	assert.False(t, errors.Is(err, ErrConstraint))
+
+	assert.NoError(t, PlaceLegalHold(ctx, db, 1, "legal@example.com", "claim 1234"))
+	err = DeleteRecordVersions(ctx, db, 1, []int{1})
+	assert.True(t, errors.Is(err, ErrRecordOnHold))
+	assert.True(t, errors.Is(err, ErrConstraint))
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrMigrationsPending = errors.New("database has pending migrations")
+
+// Ping runs a cheap query, it fails when the database file cannot be read.
+func Ping(ctx context.Context, db *sql.DB) error {
+	defer observeQuery("ping", time.Now())
+	var version sql.NullInt64
+	err := db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_version").Scan(&version)
+	return translateError(err)
+}
+
+// CheckMigrations fails when the migrations of this build are not all applied.
+func CheckMigrations(ctx context.Context, db *sql.DB) error {
+	pending, err := PendingMigrations(db)
+	if err != nil {
+		return translateError(err)
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d to apply, next is %04d_%s", ErrMigrationsPending, len(pending), pending[0].Version, pending[0].Name)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/tenant"
+)
+
+var ErrLegalHoldExists = errors.New("record is already under legal hold")
+var ErrLegalHoldDoesNotExist = errors.New("record is not under legal hold")
+
+const (
+	LEGAL_HOLD_PLACED   string = "placed"
+	LEGAL_HOLD_RELEASED string = "released"
+)
+
+// PlaceLegalHold puts the record under legal hold and records who placed it and why.
+func PlaceLegalHold(ctx context.Context, db *sql.DB, id int, actor string, reason string) error {
+	defer observeQuery("place_legal_hold", time.Now())
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO legal_holds (tenant, record_id, placed_by, reason, placed_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)",
+		tenant.ID(ctx), id, actor, reason)
+	if err != nil {
+		return translateError(err)
+	}
+	if inserted, err := res.RowsAffected(); err != nil {
+		return translateError(err)
+	} else if inserted == 0 {
+		return ErrLegalHoldExists
+	}
+
+	if err := insertLegalHoldEvent(ctx, tx, id, LEGAL_HOLD_PLACED, actor, reason); err != nil {
+		return translateError(err)
+	}
+	return translateError(tx.Commit())
+}
+
+// ReleaseLegalHold lifts the legal hold on the record and records who released it and why.
+func ReleaseLegalHold(ctx context.Context, db *sql.DB, id int, actor string, reason string) error {
+	defer observeQuery("release_legal_hold", time.Now())
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM legal_holds WHERE tenant = ? AND record_id = ?", tenant.ID(ctx), id)
+	if err != nil {
+		return translateError(err)
+	}
+	if deleted, err := res.RowsAffected(); err != nil {
+		return translateError(err)
+	} else if deleted == 0 {
+		return ErrLegalHoldDoesNotExist
+	}
+
+	if err := insertLegalHoldEvent(ctx, tx, id, LEGAL_HOLD_RELEASED, actor, reason); err != nil {
+		return translateError(err)
+	}
+	return translateError(tx.Commit())
+}
+
+func insertLegalHoldEvent(ctx context.Context, tx *sql.Tx, id int, action string, actor string, reason string) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO legal_hold_events (tenant, record_id, action, actor, reason, timestamp) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)",
+		tenant.ID(ctx), id, action, actor, reason)
+	return translateError(err)
+}
+
+// GetLegalHold returns the current hold on the record, or ErrNotFound if it is not held.
+func GetLegalHold(ctx context.Context, db *sql.DB, id int) (*entity.LegalHold, error) {
+	defer observeQuery("get_legal_hold", time.Now())
+	row := db.QueryRowContext(ctx, "SELECT record_id, placed_by, reason, placed_at FROM legal_holds WHERE tenant = ? AND record_id = ?", tenant.ID(ctx), id)
+	hold := entity.LegalHold{}
+	if err := row.Scan(&hold.RecordID, &hold.PlacedBy, &hold.Reason, &hold.PlacedAt); err != nil {
+		return nil, translateError(err)
+	}
+	return &hold, nil
+}
+
+// GetLegalHoldEvents returns every time a hold was placed on or released from the record, oldest first.
+func GetLegalHoldEvents(ctx context.Context, db *sql.DB, id int) ([]entity.LegalHoldEvent, error) {
+	defer observeQuery("get_legal_hold_events", time.Now())
+	rows, err := db.QueryContext(ctx, "SELECT record_id, action, actor, reason, timestamp FROM legal_hold_events WHERE tenant = ? AND record_id = ? ORDER BY id ASC", tenant.ID(ctx), id)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	events := make([]entity.LegalHoldEvent, 0)
+	for rows.Next() {
+		event := entity.LegalHoldEvent{}
+		if err := rows.Scan(&event.RecordID, &event.Action, &event.Actor, &event.Reason, &event.Timestamp); err != nil {
+			return nil, translateError(err)
+		}
+		events = append(events, event)
+	}
+	return events, translateError(rows.Err())
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"time"
+
+	"github.com/chauvm/timetravel/metrics"
+)
+
+// VERSION_BUCKETS are the upper bounds of the versions per record histogram
+var VERSION_BUCKETS = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+
+// VERSION_COUNTS_INTERVAL is how often the versions per record histogram is read, it scans
+// every record so it is not read on every scrape
+const VERSION_COUNTS_INTERVAL = 5 * time.Minute
+
+var queryDuration = metrics.Default.NewHistogramVec("timetravel_db_query_duration_seconds",
+	"Duration of the database operations, including retries within the busy timeout.", metrics.DURATION_BUCKETS, "op")
+var busyErrors = metrics.Default.NewCounterVec("timetravel_db_busy_total",
+	"Operations that failed with SQLITE_BUSY or SQLITE_LOCKED after the busy timeout.")
+
+// observeQuery records how long the operation op took since start, call it deferred
+func observeQuery(op string, start time.Time) {
+	queryDuration.Observe(time.Since(start).Seconds(), op)
+}
+
+// RegisterMetrics adds the metrics read from the database: the size of the database file
+// and the connection pool when scraped, and how many versions records have when the
+// returned snapshot is refreshed, run it every VERSION_COUNTS_INTERVAL.
+func RegisterMetrics(registry *metrics.Registry, db *sql.DB, file string) *metrics.HistogramSnapshot {
+	registry.NewGaugeFunc("timetravel_db_file_size_bytes", "Size of the database file and its write-ahead log.", func() float64 {
+		var size int64
+		for _, path := range []string{file, file + "-wal"} {
+			if info, err := os.Stat(path); err == nil {
+				size += info.Size()
+			}
+		}
+		return float64(size)
+	})
+	registry.NewGaugeFunc("timetravel_db_open_connections", "Connections open to the database, in use or idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	registry.NewGaugeFunc("timetravel_db_in_use_connections", "Connections currently in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	registry.NewGaugeFunc("timetravel_db_wait_count", "Total number of times a query waited for a free connection.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	return registry.NewHistogramSnapshot("timetravel_record_versions", "Number of versions kept per record.", VERSION_BUCKETS, func(ctx context.Context) (map[float64]uint64, error) {
+		return GetVersionCounts(ctx, db)
+	})
+}
+
+// GetVersionCounts returns how many records have each number of versions.
+func GetVersionCounts(ctx context.Context, db *sql.DB) (map[float64]uint64, error) {
+	defer observeQuery("get_version_counts", time.Now())
+	rows, err := db.QueryContext(ctx, `SELECT versions, COUNT(*) FROM (SELECT COUNT(*) AS versions FROM records GROUP BY tenant, id) GROUP BY versions`)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	counts := map[float64]uint64{}
+	for rows.Next() {
+		var versions float64
+		var records uint64
+		if err := rows.Scan(&versions, &records); err != nil {
+			return nil, translateError(err)
+		}
+		counts[versions] = records
+	}
+	return counts, translateError(rows.Err())
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var ErrSchemaTooNew = errors.New("database schema is newer than this build supports")
+
+// Migration is one step of the schema, read from migrations/NNNN_name.sql.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+const INIT_SCHEMA_VERSION string = `
+ CREATE TABLE IF NOT EXISTS schema_version (
+ version INTEGER NOT NULL PRIMARY KEY,
+ name STRING NOT NULL,
+ applied_at DATETIME NOT NULL
+ );`
+
+var migrationFileName = regexp.MustCompile(`^(\d{4})_(\w+)\.sql$`)
+
+// Migrations returns every migration embedded in this build, in order.
+func Migrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		matches := migrationFileName.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("migration %s must be named NNNN_name.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(matches[1])
+		content, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: matches[2], SQL: string(content)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration %04d_%s is out of sequence, expected version %d", migration.Version, migration.Name, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// SchemaVersion returns the version of the last migration applied to the database, 0 if none.
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// PendingMigrations returns the migrations of this build not applied to the database yet.
+func PendingMigrations(db *sql.DB) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if version > len(migrations) {
+		return nil, fmt.Errorf("%w: database is at version %d, this build knows %d", ErrSchemaTooNew, version, len(migrations))
+	}
+	return migrations[version:], nil
+}
+
+// Migrate applies the pending migrations in order, each one in its own transaction.
+//
+// Migrate refuses to touch a database migrated by a newer build.
+func Migrate(db *sql.DB) error {
+	if _, err := db.Exec(INIT_SCHEMA_VERSION); err != nil {
+		return err
+	}
+	if err := adoptLegacySchema(db); err != nil {
+		return err
+	}
+
+	pending, err := PendingMigrations(db)
+	if err != nil {
+		return err
+	}
+	for _, migration := range pending {
+		log.Printf("Applying migration %04d_%s", migration.Version, migration.Name)
+		if err := applyMigration(db, migration); err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(migration.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, CURRENT_TIMESTAMP)",
+		migration.Version, migration.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// adoptLegacySchema records the migrations already reflected in a database created
+// before schema_version existed, when the tables were created at startup instead.
+func adoptLegacySchema(db *sql.DB) error {
+	version, err := SchemaVersion(db)
+	if err != nil || version > 0 {
+		return err
+	}
+
+	checks := []string{
+		// 0001: the records table
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'records'",
+		// 0002: its occurred_at column
+		"SELECT COUNT(*) FROM pragma_table_info('records') WHERE name = 'occurred_at'",
+		// 0003: the legal hold tables
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'legal_holds'",
+	}
+	adopted := 0
+	for _, check := range checks {
+		var count int
+		if err := db.QueryRow(check).Scan(&count); err != nil {
+			return err
+		}
+		if count == 0 {
+			break
+		}
+		adopted++
+	}
+	if adopted == 0 {
+		return nil
+	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations[:adopted] {
+		log.Printf("Adopting migration %04d_%s, already applied to this database", migration.Version, migration.Name)
+		if _, err := db.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, CURRENT_TIMESTAMP)",
+			migration.Version, migration.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "rainbow.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrate(t *testing.T) {
+	db := openTestDB(t)
+	migrations, err := Migrations()
+	assert.NoError(t, err)
+
+	assert.NoError(t, Migrate(db))
+	version, err := SchemaVersion(db)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+
+	pending, err := PendingMigrations(db)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// migrating again is a no-op
+	assert.NoError(t, Migrate(db))
+	version, err = SchemaVersion(db)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	// a database created by CREATE TABLE IF NOT EXISTS at startup, before migrations
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS records (
+		id INTEGER NOT NULL,
+		timestamp DATETIME NOT NULL,
+		data STRING NOT NULL,
+		updates STRING,
+		version INTEGER NOT NULL,
+		PRIMARY KEY (id ASC, version DESC)
+	);
+	INSERT INTO records (id, timestamp, data, updates, version) VALUES (1, CURRENT_TIMESTAMP, '{"hello":"world"}', '{}', 1);`)
+	assert.NoError(t, err)
+
+	assert.NoError(t, Migrate(db))
+
+	var name string
+	err = db.QueryRow("SELECT name FROM schema_version WHERE version = 1").Scan(&name)
+	assert.NoError(t, err)
+	assert.Equal(t, "create_records", name)
+
+	// existing data is readable with the new columns
+	record, err := GetLatestRecord(ctx, db, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"hello": "world"}, record.Data)
+	assert.Equal(t, "", record.OccurredAt)
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	db := openTestDB(t)
+	assert.NoError(t, Migrate(db))
+
+	_, err := db.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (9999, 'from_the_future', CURRENT_TIMESTAMP)")
+	assert.NoError(t, err)
+
+	err = Migrate(db)
+	assert.True(t, errors.Is(err, ErrSchemaTooNew))
+}
+
+func TestCheckMigrations(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	assert.NoError(t, Migrate(db))
+	assert.NoError(t, Ping(ctx, db))
+	assert.NoError(t, CheckMigrations(ctx, db))
+
+	_, err := db.Exec("DELETE FROM schema_version WHERE version = (SELECT MAX(version) FROM schema_version)")
+	assert.NoError(t, err)
+	assert.True(t, errors.Is(CheckMigrations(ctx, db), ErrMigrationsPending))
+}
@@ -0,0 +1,8 @@
+CREATE TABLE records (
+ id INTEGER NOT NULL,
+ timestamp DATETIME NOT NULL,
+ data STRING NOT NULL,
+ updates STRING,
+ version INTEGER NOT NULL,
+ PRIMARY KEY (id ASC, version DESC)
+);
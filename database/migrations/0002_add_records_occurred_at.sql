@@ -0,0 +1,2 @@
+-- when the client reported the change actually happened, if it did
+ALTER TABLE records ADD COLUMN occurred_at DATETIME;
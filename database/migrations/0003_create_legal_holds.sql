@@ -0,0 +1,27 @@
+CREATE TABLE legal_holds (
+ record_id INTEGER NOT NULL PRIMARY KEY,
+ placed_by STRING NOT NULL,
+ reason STRING NOT NULL,
+ placed_at DATETIME NOT NULL
+);
+
+CREATE TABLE legal_hold_events (
+ id INTEGER PRIMARY KEY AUTOINCREMENT,
+ record_id INTEGER NOT NULL,
+ action STRING NOT NULL,
+ actor STRING NOT NULL,
+ reason STRING NOT NULL,
+ timestamp DATETIME NOT NULL
+);
+
+CREATE INDEX legal_hold_events_record_id ON legal_hold_events (record_id);
+
+-- records under legal hold cannot be deleted or rewritten by anything touching the records table,
+-- appending new versions is still allowed. The message must match database.LEGAL_HOLD_MESSAGE.
+CREATE TRIGGER records_legal_hold_delete BEFORE DELETE ON records
+WHEN EXISTS (SELECT 1 FROM legal_holds WHERE record_id = OLD.id)
+BEGIN SELECT RAISE(ABORT, 'record is under legal hold'); END;
+
+CREATE TRIGGER records_legal_hold_update BEFORE UPDATE ON records
+WHEN EXISTS (SELECT 1 FROM legal_holds WHERE record_id = OLD.id)
+BEGIN SELECT RAISE(ABORT, 'record is under legal hold'); END;
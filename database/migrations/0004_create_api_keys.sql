@@ -0,0 +1,12 @@
+-- only the sha256 of a key is stored, the key itself is shown once when minted
+CREATE TABLE api_keys (
+ id INTEGER PRIMARY KEY AUTOINCREMENT,
+ name STRING NOT NULL UNIQUE,
+ hash STRING NOT NULL UNIQUE,
+ role STRING NOT NULL,
+ created_at DATETIME NOT NULL,
+ revoked_at DATETIME
+);
+
+-- the principal that wrote each version, unknown for versions written before authentication
+ALTER TABLE records ADD COLUMN author STRING;
@@ -0,0 +1,53 @@
+-- every record belongs to a tenant, ids are unique per tenant. Records written before
+-- tenants existed belong to the default tenant, see tenant.DEFAULT.
+-- SQLite cannot change a primary key in place, the table is rebuilt.
+CREATE TABLE records_with_tenant (
+ tenant STRING NOT NULL DEFAULT 'default',
+ id INTEGER NOT NULL,
+ timestamp DATETIME NOT NULL,
+ data STRING NOT NULL,
+ updates STRING,
+ version INTEGER NOT NULL,
+ occurred_at DATETIME,
+ author STRING,
+ PRIMARY KEY (tenant, id ASC, version DESC)
+);
+
+INSERT INTO records_with_tenant (tenant, id, timestamp, data, updates, version, occurred_at, author)
+SELECT 'default', id, timestamp, data, updates, version, occurred_at, author FROM records;
+
+-- drops the legal hold triggers along with the table, they are created again below
+DROP TABLE records;
+ALTER TABLE records_with_tenant RENAME TO records;
+
+CREATE TABLE legal_holds_with_tenant (
+ tenant STRING NOT NULL DEFAULT 'default',
+ record_id INTEGER NOT NULL,
+ placed_by STRING NOT NULL,
+ reason STRING NOT NULL,
+ placed_at DATETIME NOT NULL,
+ PRIMARY KEY (tenant, record_id)
+);
+
+INSERT INTO legal_holds_with_tenant (tenant, record_id, placed_by, reason, placed_at)
+SELECT 'default', record_id, placed_by, reason, placed_at FROM legal_holds;
+
+DROP TABLE legal_holds;
+ALTER TABLE legal_holds_with_tenant RENAME TO legal_holds;
+
+ALTER TABLE legal_hold_events ADD COLUMN tenant STRING NOT NULL DEFAULT 'default';
+DROP INDEX legal_hold_events_record_id;
+CREATE INDEX legal_hold_events_tenant_record_id ON legal_hold_events (tenant, record_id);
+
+-- same as migrations/0003_create_legal_holds.sql, a hold only covers the record of its tenant
+CREATE TRIGGER records_legal_hold_delete BEFORE DELETE ON records
+WHEN EXISTS (SELECT 1 FROM legal_holds WHERE tenant = OLD.tenant AND record_id = OLD.id)
+BEGIN SELECT RAISE(ABORT, 'record is under legal hold'); END;
+
+CREATE TRIGGER records_legal_hold_update BEFORE UPDATE ON records
+WHEN EXISTS (SELECT 1 FROM legal_holds WHERE tenant = OLD.tenant AND record_id = OLD.id)
+BEGIN SELECT RAISE(ABORT, 'record is under legal hold'); END;
+
+-- the tenant an api key is bound to, NULL for keys acting on the default tenant, or on any
+-- tenant for roles allowed to, see auth.Principal.ResolveTenant
+ALTER TABLE api_keys ADD COLUMN tenant STRING;
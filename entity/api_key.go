@@ -0,0 +1,11 @@
+package entity
+
+// APIKey is a credential of a principal, identified by its name. Only its hash is stored.
+// A key without a tenant acts on the default tenant, or on any tenant if its role allows it.
+type APIKey struct {
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	Tenant    string `json:"tenant,omitempty"`
+	CreatedAt string `json:"created_at"`
+	RevokedAt string `json:"revoked_at,omitempty"`
+}
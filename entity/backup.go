@@ -0,0 +1,11 @@
+package entity
+
+// Backup describes a copy of the database file, one just taken or one about to be restored.
+type Backup struct {
+	File string `json:"file"`
+	// TakenAt is only known for a backup just taken
+	TakenAt       string         `json:"taken_at,omitempty"`
+	Bytes         int64          `json:"bytes"`
+	SchemaVersion int            `json:"schema_version"`
+	Rows          map[string]int `json:"rows"`
+}
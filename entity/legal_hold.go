@@ -0,0 +1,18 @@
+package entity
+
+// LegalHold prevents a record's history from being deleted or rewritten while litigation is pending.
+type LegalHold struct {
+	RecordID int    `json:"record_id"`
+	PlacedBy string `json:"placed_by"`
+	Reason   string `json:"reason"`
+	PlacedAt string `json:"placed_at"`
+}
+
+// LegalHoldEvent is a hold being placed on or released from a record.
+type LegalHoldEvent struct {
+	RecordID  int    `json:"record_id"`
+	Action    string `json:"action"`
+	Actor     string `json:"actor"`
+	Reason    string `json:"reason"`
+	Timestamp string `json:"timestamp"`
+}
@@ -1,11 +1,17 @@
 package entity
 
+import "time"
+
 type Record struct {
 	ID        int               `json:"id"`
 	Data      map[string]string `json:"data"`
 	Updates   map[string]string `json:"updates"`
 	Version   int               `json:"version"`
 	Timestamp string            `json:"timestamp"`
+	// OccurredAt is when the client reported the change actually happened, if it did
+	OccurredAt string `json:"occurred_at,omitempty"`
+	// Author is the authenticated principal that wrote this version
+	Author string `json:"author,omitempty"`
 }
 
 type ExternalRecord struct {
@@ -33,3 +39,8 @@ func (d *Record) GetExternalRecord() ExternalRecord {
 		Data: d.Data,
 	}
 }
+
+// RecordedAt parses the time at which this version was recorded.
+func (d *Record) RecordedAt() (time.Time, error) {
+	return time.Parse(time.RFC3339, d.Timestamp)
+}
@@ -0,0 +1,16 @@
+package entity
+
+// RetroactiveChange is a version of a record whose change occurred well before it was recorded.
+//
+// Until RecordedAt the record was known as Before, the version the change replaced, when it
+// should have been After since OccurredAt.
+type RetroactiveChange struct {
+	ID         int               `json:"id"`
+	Version    int               `json:"version"`
+	OccurredAt string            `json:"occurred_at"`
+	RecordedAt string            `json:"recorded_at"`
+	Lag        string            `json:"lag"`
+	LagSeconds int64             `json:"lag_seconds"`
+	Before     map[string]string `json:"before"`
+	After      map[string]string `json:"after"`
+}
@@ -0,0 +1,80 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/chauvm/timetravel/api"
+	"github.com/chauvm/timetravel/logging"
+	"github.com/chauvm/timetravel/service"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// serviceError is how an error returned by a service is reported to clients
+type serviceError struct {
+	err  error
+	code codes.Code
+	// reason is the problem code of the HTTP api
+	reason string
+}
+
+// serviceErrors maps the sentinel errors of the services the methods can return, the
+// first match wins. Errors not listed here are internal errors.
+var serviceErrors = []serviceError{
+	{service.ErrRecordDoesNotExist, codes.NotFound, api.CODE_RECORD_NOT_FOUND},
+	{service.ErrRecordIDInvalid, codes.InvalidArgument, api.CODE_INVALID_ID},
+	{service.ErrRecordAlreadyExists, codes.AlreadyExists, api.CODE_RECORD_ALREADY_EXISTS},
+	{service.ErrRecordConflict, codes.Aborted, api.CODE_RECORD_CONFLICT},
+	{service.ErrRecordOnHold, codes.FailedPrecondition, api.CODE_RECORD_ON_HOLD},
+	{service.ErrRecordTooLarge, codes.InvalidArgument, api.CODE_RECORD_TOO_LARGE},
+	{service.ErrTooManyKeys, codes.InvalidArgument, api.CODE_TOO_MANY_KEYS},
+	{service.ErrKeyTooLong, codes.InvalidArgument, api.CODE_KEY_TOO_LONG},
+	{service.ErrValueTooLong, codes.InvalidArgument, api.CODE_VALUE_TOO_LONG},
+	{service.ErrTooManyVersions, codes.ResourceExhausted, api.CODE_TOO_MANY_VERSIONS},
+	{service.ErrUnavailable, codes.Unavailable, api.CODE_UNAVAILABLE},
+	{service.ErrConstraintViolation, codes.FailedPrecondition, api.CODE_CONSTRAINT_VIOLATION},
+	{service.ErrStorageCorrupt, codes.DataLoss, api.CODE_STORAGE_CORRUPT},
+}
+
+// the messages of the limit errors say which limit was exceeded and nothing internal,
+// they are sent whole rather than only the message of the sentinel
+var detailedErrors = []error{
+	service.ErrRecordTooLarge,
+	service.ErrTooManyKeys,
+	service.ErrKeyTooLong,
+	service.ErrValueTooLong,
+	service.ErrTooManyVersions,
+}
+
+// problem is an error status carrying the problem code as the reason of an ErrorInfo
+func problem(code codes.Code, reason string, message string) error {
+	s := status.New(code, message)
+	if detailed, err := s.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: ERROR_DOMAIN}); err == nil {
+		s = detailed
+	}
+	return s.Err()
+}
+
+// serviceProblem reports an error returned by a service. Only the message of the sentinel
+// is sent, the wrapped driver error is logged, but for the limit errors.
+func serviceProblem(ctx context.Context, err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	for _, e := range serviceErrors {
+		if !errors.Is(err, e.err) {
+			continue
+		}
+		message := e.err.Error()
+		for _, detailed := range detailedErrors {
+			if errors.Is(err, detailed) {
+				message = err.Error()
+			}
+		}
+		return problem(e.code, e.reason, message)
+	}
+	logging.FromContext(ctx).Error("rpc failed", "error", err)
+	return problem(codes.Internal, api.CODE_INTERNAL, api.ErrInternal.Error())
+}
@@ -0,0 +1,455 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chauvm/timetravel/api"
+	"github.com/chauvm/timetravel/auth"
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/service"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// keys minted for the tests, by role
+var keys map[string]string
+
+// setUp serves an in-memory record service on an in-process listener
+func setUp(t *testing.T, authenticated bool) (*Server, RecordsClient) {
+	records := service.NewInMemoryRecordService(service.DefaultLimits())
+
+	var authenticator *auth.Authenticator
+	if authenticated {
+		options := database.DefaultOptions()
+		options.File = filepath.Join(t.TempDir(), "keys.db")
+		db, err := database.CreateConnection(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		authenticator = auth.NewAuthenticator(db)
+		keys = map[string]string{}
+		for _, role := range []string{auth.ROLE_READER, auth.ROLE_WRITER, auth.ROLE_ADMIN} {
+			keys[role], err = authenticator.Mint(context.Background(), role+"@example.com", role, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		keys["acme"], err = authenticator.Mint(context.Background(), "acme@example.com", auth.ROLE_ADMIN, "acme")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := NewServer(records, authenticator)
+	server.feedInterval = 10 * time.Millisecond
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return server, NewRecordsClient(conn)
+}
+
+// withKey sends the api key of the role with the calls made with the context
+func withKey(ctx context.Context, role string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, API_KEY_METADATA, keys[role])
+}
+
+// assertProblem checks the status code and the problem code of an error
+func assertProblem(t *testing.T, err error, code codes.Code, reason string) {
+	s, ok := status.FromError(err)
+	if !assert.True(t, ok, "not a status: %v", err) {
+		return
+	}
+	assert.Equal(t, code, s.Code(), s.Message())
+	for _, detail := range s.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			assert.Equal(t, ERROR_DOMAIN, info.Domain)
+			assert.Equal(t, reason, info.Reason)
+			return
+		}
+	}
+	t.Errorf("no ErrorInfo in %v", s.Details())
+}
+
+// receive reads n records from the stream
+func receive(t *testing.T, stream grpc.ServerStreamingClient[Record], n int) []*Record {
+	records := []*Record{}
+	for i := 0; i < n; i++ {
+		record, err := stream.Recv()
+		if !assert.NoError(t, err) {
+			break
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRecords(t *testing.T) {
+	ctx := context.Background()
+	_, client := setUp(t, false)
+
+	record, err := client.UpsertRecord(ctx, &UpsertRecordRequest{Id: 1, Set: map[string]string{"hello": "world", "status": "ok"}})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"hello": "world", "status": "ok"}, record.Data)
+	assert.Equal(t, int64(1), record.Version)
+
+	record, err = client.UpsertRecord(ctx, &UpsertRecordRequest{Id: 1, Set: map[string]string{"hello": "there"}, Delete: []string{"status"}, OccurredAt: "2024-03-01"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"hello": "there"}, record.Data)
+	assert.Equal(t, int64(2), record.Version)
+
+	record, err = client.GetRecord(ctx, &GetRecordRequest{Id: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), record.Id)
+	assert.Equal(t, map[string]string{"hello": "there"}, record.Data)
+
+	versions, err := client.ListVersions(ctx, &ListVersionsRequest{Id: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 1}, versions.Versions)
+
+	record, err = client.GetRecordAtVersion(ctx, &GetRecordAtVersionRequest{Id: 1, Version: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"hello": "world", "status": "ok"}, record.Data)
+
+	stream, err := client.GetRecordHistory(ctx, &GetRecordHistoryRequest{Id: 1})
+	assert.NoError(t, err)
+	history := receive(t, stream, 2)
+	_, err = stream.Recv()
+	assert.True(t, errors.Is(err, io.EOF))
+	assert.Equal(t, int64(1), history[0].Version)
+	assert.Equal(t, int64(2), history[1].Version)
+	assert.Equal(t, "2024-03-01T00:00:00Z", history[1].OccurredAt)
+	assert.NotEmpty(t, history[1].Timestamp)
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	_, client := setUp(t, false)
+
+	_, err := client.GetRecord(ctx, &GetRecordRequest{Id: 0})
+	assertProblem(t, err, codes.InvalidArgument, api.CODE_INVALID_ID)
+	_, err = client.GetRecord(ctx, &GetRecordRequest{Id: 1 << 40})
+	assertProblem(t, err, codes.InvalidArgument, api.CODE_INVALID_ID)
+	_, err = client.GetRecord(ctx, &GetRecordRequest{Id: 1})
+	assertProblem(t, err, codes.NotFound, api.CODE_RECORD_NOT_FOUND)
+	_, err = client.GetRecordAtVersion(ctx, &GetRecordAtVersionRequest{Id: 1, Version: 0})
+	assertProblem(t, err, codes.InvalidArgument, api.CODE_INVALID_VERSION)
+	stream, err := client.GetRecordHistory(ctx, &GetRecordHistoryRequest{Id: 1})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assertProblem(t, err, codes.NotFound, api.CODE_RECORD_NOT_FOUND)
+
+	// preconditions
+	_, err = client.UpsertRecord(ctx, &UpsertRecordRequest{Id: 1, Set: map[string]string{"a": "b"}, Precondition: Precondition_PRECONDITION_UPDATE})
+	assertProblem(t, err, codes.FailedPrecondition, api.CODE_RECORD_NOT_FOUND)
+	_, err = client.UpsertRecord(ctx, &UpsertRecordRequest{Id: 1, Set: map[string]string{"a": "b"}, Precondition: Precondition_PRECONDITION_CREATE})
+	assert.NoError(t, err)
+	_, err = client.UpsertRecord(ctx, &UpsertRecordRequest{Id: 1, Set: map[string]string{"a": "c"}, Precondition: Precondition_PRECONDITION_CREATE})
+	assertProblem(t, err, codes.FailedPrecondition, api.CODE_RECORD_ALREADY_EXISTS)
+	_, err = client.UpsertRecord(ctx, &UpsertRecordRequest{Id: 1, Precondition: 7})
+	assertProblem(t, err, codes.InvalidArgument, api.CODE_INVALID_PARAMETER)
+
+	// invalid updates
+	_, err = client.UpsertRecord(ctx, &UpsertRecordRequest{Id: 1, Set: map[string]string{"a": "c"}, Delete: []string{"a"}})
+	assertProblem(t, err, codes.InvalidArgument, api.CODE_INVALID_INPUT)
+	_, err = client.UpsertRecord(ctx, &UpsertRecordRequest{Id: 1, Set: map[string]string{"a": "c"}, OccurredAt: "tomorrow"})
+	assertProblem(t, err, codes.InvalidArgument, api.CODE_INVALID_PARAMETER)
+	_, err = client.UpsertRecord(ctx, &UpsertRecordRequest{Id: 1, Set: map[string]string{"a": string(make([]byte, 65<<10))}})
+	assertProblem(t, err, codes.InvalidArgument, api.CODE_VALUE_TOO_LONG)
+	// beyond MaxBodyBytes
+	_, err = client.UpsertRecord(ctx, &UpsertRecordRequest{Id: 1, Set: map[string]string{"a": string(make([]byte, 2<<20))}})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+// the sentinels of the services map to their own codes, without the wrapped driver error
+func TestServiceProblems(t *testing.T) {
+	driverErr := errors.New("sqlite: disk I/O error at page 7")
+	cases := []struct {
+		err    error
+		code   codes.Code
+		reason string
+	}{
+		{service.ErrRecordConflict, codes.Aborted, api.CODE_RECORD_CONFLICT},
+		{service.ErrUnavailable, codes.Unavailable, api.CODE_UNAVAILABLE},
+		{service.ErrConstraintViolation, codes.FailedPrecondition, api.CODE_CONSTRAINT_VIOLATION},
+		{service.ErrStorageCorrupt, codes.DataLoss, api.CODE_STORAGE_CORRUPT},
+		{errors.New("unexpected"), codes.Internal, api.CODE_INTERNAL},
+	}
+	for _, c := range cases {
+		err := serviceProblem(context.Background(), fmt.Errorf("%w: %w", c.err, driverErr))
+		assertProblem(t, err, c.code, c.reason)
+		assert.NotContains(t, status.Convert(err).Message(), driverErr.Error())
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	ctx := context.Background()
+	_, client := setUp(t, true)
+
+	_, err := client.GetRecord(ctx, &GetRecordRequest{Id: 1})
+	assertProblem(t, err, codes.Unauthenticated, api.CODE_UNAUTHENTICATED)
+	_, err = client.GetRecord(metadata.AppendToOutgoingContext(ctx, API_KEY_METADATA, "unknown"), &GetRecordRequest{Id: 1})
+	assertProblem(t, err, codes.Unauthenticated, api.CODE_UNAUTHENTICATED)
+
+	_, err = client.UpsertRecord(withKey(ctx, auth.ROLE_READER), &UpsertRecordRequest{Id: 1, Set: map[string]string{"a": "b"}})
+	assertProblem(t, err, codes.PermissionDenied, api.CODE_FORBIDDEN)
+	_, err = client.UpsertRecord(withKey(ctx, auth.ROLE_WRITER), &UpsertRecordRequest{Id: 1, Set: map[string]string{"a": "b"}})
+	assert.NoError(t, err)
+	bearer := metadata.AppendToOutgoingContext(ctx, AUTHORIZATION_METADATA, "Bearer "+keys[auth.ROLE_READER])
+	_, err = client.GetRecord(bearer, &GetRecordRequest{Id: 1})
+	assert.NoError(t, err)
+
+	// reading the history is time travel, streams are authorized too
+	stream, err := client.GetRecordHistory(withKey(ctx, auth.ROLE_WRITER), &GetRecordHistoryRequest{Id: 1})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assertProblem(t, err, codes.PermissionDenied, api.CODE_FORBIDDEN)
+	stream, err = client.GetRecordHistory(withKey(ctx, auth.ROLE_ADMIN), &GetRecordHistoryRequest{Id: 1})
+	assert.NoError(t, err)
+	assert.Len(t, receive(t, stream, 1), 1)
+
+	// the record is in the default tenant, the key bound to acme only sees acme
+	_, err = client.GetRecord(withKey(ctx, "acme"), &GetRecordRequest{Id: 1})
+	assertProblem(t, err, codes.NotFound, api.CODE_RECORD_NOT_FOUND)
+	_, err = client.GetRecord(metadata.AppendToOutgoingContext(withKey(ctx, "acme"), TENANT_METADATA, "default"), &GetRecordRequest{Id: 1})
+	assertProblem(t, err, codes.PermissionDenied, api.CODE_FORBIDDEN)
+	_, err = client.GetRecord(metadata.AppendToOutgoingContext(withKey(ctx, auth.ROLE_ADMIN), TENANT_METADATA, "acme"), &GetRecordRequest{Id: 1})
+	assertProblem(t, err, codes.NotFound, api.CODE_RECORD_NOT_FOUND)
+	// keys bound to no tenant act on the default one, only admins pick another
+	_, err = client.GetRecord(metadata.AppendToOutgoingContext(withKey(ctx, auth.ROLE_WRITER), TENANT_METADATA, "acme"), &GetRecordRequest{Id: 1})
+	assertProblem(t, err, codes.PermissionDenied, api.CODE_FORBIDDEN)
+	_, err = client.GetRecord(metadata.AppendToOutgoingContext(withKey(ctx, auth.ROLE_WRITER), TENANT_METADATA, "default"), &GetRecordRequest{Id: 1})
+	assert.NoError(t, err)
+	_, err = client.GetRecord(metadata.AppendToOutgoingContext(withKey(ctx, auth.ROLE_ADMIN), TENANT_METADATA, "not a tenant"), &GetRecordRequest{Id: 1})
+	assertProblem(t, err, codes.InvalidArgument, api.CODE_INVALID_TENANT)
+}
+
+func TestRequestID(t *testing.T) {
+	_, client := setUp(t, false)
+
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), REQUEST_ID_METADATA, "abc-123")
+	_, err := client.GetRecord(ctx, &GetRecordRequest{Id: 1}, grpc.Header(&header))
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, []string{"abc-123"}, header.Get(REQUEST_ID_METADATA))
+
+	// unsafe ids are replaced
+	ctx = metadata.AppendToOutgoingContext(context.Background(), REQUEST_ID_METADATA, "a b")
+	_, err = client.GetRecord(ctx, &GetRecordRequest{Id: 1}, grpc.Header(&header))
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Len(t, header.Get(REQUEST_ID_METADATA)[0], 32)
+}
+
+func TestWatchRecord(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, client := setUp(t, false)
+
+	// the record does not exist yet
+	stream, err := client.WatchRecord(ctx, &WatchRecordRequest{Id: 1})
+	assert.NoError(t, err)
+	_, err = client.UpsertRecord(ctx, &UpsertRecordRequest{Id: 1, Set: map[string]string{"hello": "world"}})
+	assert.NoError(t, err)
+	_, err = client.UpsertRecord(ctx, &UpsertRecordRequest{Id: 1, Set: map[string]string{"hello": "again"}})
+	assert.NoError(t, err)
+	records := receive(t, stream, 2)
+	assert.Equal(t, int64(1), records[0].Version)
+	assert.Equal(t, "again", records[1].Data["hello"])
+
+	// resuming after a version sends the versions recorded since first
+	resumed, err := client.WatchRecord(ctx, &WatchRecordRequest{Id: 1, AfterVersion: 1})
+	assert.NoError(t, err)
+	_, err = client.UpsertRecord(ctx, &UpsertRecordRequest{Id: 1, Delete: []string{"hello"}})
+	assert.NoError(t, err)
+	records = receive(t, resumed, 2)
+	assert.Equal(t, int64(2), records[0].Version)
+	assert.Equal(t, int64(3), records[1].Version)
+	assert.Empty(t, records[1].Data)
+	records = receive(t, stream, 1)
+	assert.Equal(t, int64(3), records[0].Version)
+
+	invalid, err := client.WatchRecord(ctx, &WatchRecordRequest{Id: 1, AfterVersion: -1})
+	assert.NoError(t, err)
+	_, err = invalid.Recv()
+	assertProblem(t, err, codes.InvalidArgument, api.CODE_INVALID_VERSION)
+
+	// cancelling the call ends the watch
+	watchCtx, stop := context.WithCancel(ctx)
+	cancelled, err := client.WatchRecord(watchCtx, &WatchRecordRequest{Id: 1, AfterVersion: 3})
+	assert.NoError(t, err)
+	stop()
+	_, err = cancelled.Recv()
+	assert.Equal(t, codes.Canceled, status.Code(err))
+}
+
+func TestShutdownEndsWatches(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server, client := setUp(t, false)
+
+	stream, err := client.WatchRecord(ctx, &WatchRecordRequest{Id: 1})
+	assert.NoError(t, err)
+	_, err = client.UpsertRecord(ctx, &UpsertRecordRequest{Id: 1, Set: map[string]string{"hello": "world"}})
+	assert.NoError(t, err)
+	receive(t, stream, 1)
+
+	assert.NoError(t, server.Shutdown(ctx))
+	_, err = stream.Recv()
+	assertProblem(t, err, codes.Unavailable, api.CODE_UNAVAILABLE)
+}
+
+// recordDescriptor describes Record of records.proto, as protoc would
+func recordDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	int64Type := descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()
+	stringType := descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+	messageType := descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	entry := &descriptorpb.DescriptorProto{
+		Name: proto.String("DataEntry"),
+		Field: []*descriptorpb.FieldDescriptorProto{
+			{Name: proto.String("key"), JsonName: proto.String("key"), Number: proto.Int32(1), Label: optional, Type: stringType},
+			{Name: proto.String("value"), JsonName: proto.String("value"), Number: proto.Int32(2), Label: optional, Type: stringType},
+		},
+		Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("expected.proto"),
+		Package: proto.String("timetravel.v2.expected"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Record"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("id"), JsonName: proto.String("id"), Number: proto.Int32(1), Label: optional, Type: int64Type},
+				{Name: proto.String("data"), JsonName: proto.String("data"), Number: proto.Int32(2), Label: repeated, Type: messageType, TypeName: proto.String(".timetravel.v2.expected.Record.DataEntry")},
+				{Name: proto.String("version"), JsonName: proto.String("version"), Number: proto.Int32(3), Label: optional, Type: int64Type},
+				{Name: proto.String("author"), JsonName: proto.String("author"), Number: proto.Int32(7), Label: optional, Type: stringType},
+			},
+			NestedType: []*descriptorpb.DescriptorProto{entry},
+		}},
+	}
+	descriptor, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return descriptor.Messages().ByName("Record")
+}
+
+func TestWireFormat(t *testing.T) {
+	descriptor := recordDescriptor(t)
+
+	// a message encoded from the descriptor protoc would build decodes into the generated type
+	expected := dynamicpb.NewMessage(descriptor)
+	expected.Set(descriptor.Fields().ByName("id"), protoreflect.ValueOfInt64(42))
+	expected.Set(descriptor.Fields().ByName("version"), protoreflect.ValueOfInt64(3))
+	data := expected.Mutable(descriptor.Fields().ByName("data")).Map()
+	data.Set(protoreflect.ValueOfString("hello").MapKey(), protoreflect.ValueOfString("world"))
+	data.Set(protoreflect.ValueOfString("empty").MapKey(), protoreflect.ValueOfString(""))
+	b, err := proto.Marshal(expected)
+	assert.NoError(t, err)
+	record := &Record{}
+	assert.NoError(t, proto.Unmarshal(b, record))
+	assert.True(t, proto.Equal(&Record{Id: 42, Version: 3, Data: map[string]string{"hello": "world", "empty": ""}}, record), "%v", record)
+
+	// and the generated type encodes what that descriptor decodes
+	b, err = proto.Marshal(&Record{Id: 7, Data: map[string]string{"a": "b"}, Author: "writer@example.com"})
+	assert.NoError(t, err)
+	decoded := dynamicpb.NewMessage(descriptor)
+	assert.NoError(t, proto.Unmarshal(b, decoded))
+	assert.Equal(t, int64(7), decoded.Get(descriptor.Fields().ByName("id")).Int())
+	assert.Equal(t, "b", decoded.Get(descriptor.Fields().ByName("data")).Map().Get(protoreflect.ValueOfString("a").MapKey()).String())
+	assert.Equal(t, "writer@example.com", decoded.Get(descriptor.Fields().ByName("author")).String())
+
+	// repeated scalars are packed, and read either way
+	b, err = proto.Marshal(&ListVersionsResponse{Versions: []int64{3, 2, 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x0a, 3, 3, 2, 1}, b)
+	versions := &ListVersionsResponse{}
+	assert.NoError(t, proto.Unmarshal([]byte{0x08, 3, 0x08, 2}, versions))
+	assert.Equal(t, []int64{3, 2}, versions.Versions)
+
+	// truncated messages are rejected
+	assert.Error(t, proto.Unmarshal([]byte{0x0a, 5, 'x'}, &Record{}))
+}
+
+// records.proto and the code generated from it declare the same fields, enum values and
+// methods, run go generate after changing records.proto
+func TestGeneratedCode(t *testing.T) {
+	source, err := os.ReadFile("records.proto")
+	assert.NoError(t, err)
+	blockPattern := regexp.MustCompile(`^(?:message|enum) (\w+) \{$`)
+	numberPattern := regexp.MustCompile(`(\w+) = (\d+);$`)
+	rpcPattern := regexp.MustCompile(`^rpc (\w+)\(`)
+	declared := []string{}
+	block := ""
+	for _, line := range strings.Split(string(source), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "//"):
+		case line == "}":
+			block = ""
+		case blockPattern.MatchString(line):
+			block = blockPattern.FindStringSubmatch(line)[1]
+		case rpcPattern.MatchString(line):
+			declared = append(declared, "rpc "+rpcPattern.FindStringSubmatch(line)[1])
+		case block != "" && numberPattern.MatchString(line):
+			m := numberPattern.FindStringSubmatch(line)
+			declared = append(declared, block+"."+m[1]+" = "+m[2])
+		}
+	}
+
+	generated := []string{}
+	messages := File_records_proto.Messages()
+	for i := 0; i < messages.Len(); i++ {
+		fields := messages.Get(i).Fields()
+		for j := 0; j < fields.Len(); j++ {
+			generated = append(generated, fmt.Sprintf("%s.%s = %d", messages.Get(i).Name(), fields.Get(j).Name(), fields.Get(j).Number()))
+		}
+	}
+	enums := File_records_proto.Enums()
+	for i := 0; i < enums.Len(); i++ {
+		values := enums.Get(i).Values()
+		for j := 0; j < values.Len(); j++ {
+			generated = append(generated, fmt.Sprintf("%s.%s = %d", enums.Get(i).Name(), values.Get(j).Name(), values.Get(j).Number()))
+		}
+	}
+	methods := File_records_proto.Services().ByName("Records").Methods()
+	for i := 0; i < methods.Len(); i++ {
+		generated = append(generated, "rpc "+string(methods.Get(i).Name()))
+		// a method missing from the table would be denied to every key
+		method := "/" + string(File_records_proto.Services().ByName("Records").FullName()) + "/" + string(methods.Get(i).Name())
+		assert.Contains(t, methodPermissions, method)
+	}
+	assert.NotEmpty(t, declared)
+	assert.ElementsMatch(t, declared, generated)
+}
@@ -0,0 +1,219 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/chauvm/timetravel/api"
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/logging"
+	"github.com/chauvm/timetravel/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetRecord returns the latest version of the record, like v2 GET /records/{id}.
+func (s *Server) GetRecord(ctx context.Context, request *GetRecordRequest) (*Record, error) {
+	id, err := recordID(request.Id)
+	if err != nil {
+		return nil, err
+	}
+	record, err := s.records.GetRecord(ctx, id)
+	if err != nil {
+		return nil, serviceProblem(ctx, err)
+	}
+	return newRecord(record), nil
+}
+
+// UpsertRecord updates the record if it exists and creates it otherwise, like
+// v2 POST /records/{id}. The precondition restricts it to one or the other.
+func (s *Server) UpsertRecord(ctx context.Context, request *UpsertRecordRequest) (*Record, error) {
+	id, err := recordID(request.Id)
+	if err != nil {
+		return nil, err
+	}
+	if request.Precondition < Precondition_PRECONDITION_NONE || request.Precondition > Precondition_PRECONDITION_UPDATE {
+		return nil, problem(codes.InvalidArgument, api.CODE_INVALID_PARAMETER, "invalid precondition; precondition must be one of NONE, CREATE, UPDATE")
+	}
+
+	// a nil update deletes the key, as null does over HTTP
+	updates := make(map[string]*string, len(request.Set)+len(request.Delete))
+	for key, value := range request.Set {
+		value := value
+		updates[key] = &value
+	}
+	for _, key := range request.Delete {
+		if _, ok := request.Set[key]; ok {
+			return nil, problem(codes.InvalidArgument, api.CODE_INVALID_INPUT, "invalid input; key "+strconv.Quote(key)+" is both set and deleted")
+		}
+		updates[key] = nil
+	}
+
+	// the client may report when the change actually happened, if it is reported late
+	if request.OccurredAt != "" {
+		occurredAt, err := service.ParseOccurredAt(request.OccurredAt)
+		if err != nil {
+			return nil, problem(codes.InvalidArgument, api.CODE_INVALID_PARAMETER, "invalid occurred_at; occurred_at must be an RFC 3339 timestamp or a date, not in the future")
+		}
+		ctx = service.WithOccurredAt(ctx, occurredAt)
+	}
+
+	record, err := s.records.GetRecord(ctx, id)
+	if err != nil && !errors.Is(err, service.ErrRecordDoesNotExist) {
+		return nil, serviceProblem(ctx, err)
+	}
+	if err == nil && request.Precondition == Precondition_PRECONDITION_CREATE {
+		return nil, problem(codes.FailedPrecondition, api.CODE_RECORD_ALREADY_EXISTS, "record already exists; PRECONDITION_CREATE only creates records")
+	}
+	if err != nil && request.Precondition == Precondition_PRECONDITION_UPDATE {
+		return nil, problem(codes.FailedPrecondition, api.CODE_RECORD_NOT_FOUND, "record does not exist; PRECONDITION_UPDATE only updates records")
+	}
+
+	if err == nil { // record exists
+		record, err = s.records.UpdateRecord(ctx, id, updates)
+	} else { // record does not exist
+		logging.FromContext(ctx).Debug("record does not exist, creating it", "record_id", id)
+
+		// the deletes of a new record have nothing to delete
+		data := map[string]string{}
+		for key, value := range request.Set {
+			data[key] = value
+		}
+		record = entity.Record{
+			ID:      id,
+			Data:    data,
+			Updates: data,
+			Version: 1,
+		}
+		err = s.records.CreateRecord(ctx, record)
+	}
+	if err != nil {
+		return nil, serviceProblem(ctx, err)
+	}
+	return newRecord(record), nil
+}
+
+// ListVersions lists the versions of the record, like v2 GET /records/{id}/versions.
+func (s *Server) ListVersions(ctx context.Context, request *ListVersionsRequest) (*ListVersionsResponse, error) {
+	id, err := recordID(request.Id)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := s.records.GetRecordVersions(ctx, id)
+	if err != nil {
+		return nil, serviceProblem(ctx, err)
+	}
+	response := &ListVersionsResponse{Versions: make([]int64, 0, len(versions))}
+	for _, version := range versions {
+		response.Versions = append(response.Versions, int64(version))
+	}
+	return response, nil
+}
+
+// GetRecordAtVersion returns a version of the record, like v2 GET /records/{id}/{version}.
+func (s *Server) GetRecordAtVersion(ctx context.Context, request *GetRecordAtVersionRequest) (*Record, error) {
+	id, err := recordID(request.Id)
+	if err != nil {
+		return nil, err
+	}
+	if request.Version <= 0 || request.Version > math.MaxInt32 {
+		return nil, problem(codes.InvalidArgument, api.CODE_INVALID_VERSION, "invalid version; version must be a positive number")
+	}
+	record, err := s.records.GetRecordAtVersion(ctx, id, int(request.Version))
+	if err != nil {
+		return nil, serviceProblem(ctx, err)
+	}
+	return newRecord(record), nil
+}
+
+// GetRecordHistory streams every version of the record, oldest first, like
+// v2 GET /records/{id}/history.
+func (s *Server) GetRecordHistory(request *GetRecordHistoryRequest, stream grpc.ServerStreamingServer[Record]) error {
+	ctx := stream.Context()
+	id, err := recordID(request.Id)
+	if err != nil {
+		return err
+	}
+	history, err := s.records.GetRecordHistory(ctx, id)
+	if err != nil {
+		return serviceProblem(ctx, err)
+	}
+	if len(history) == 0 {
+		return problem(codes.NotFound, api.CODE_RECORD_NOT_FOUND, service.ErrRecordDoesNotExist.Error())
+	}
+	for _, record := range history {
+		if err := stream.Send(newRecord(record)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WatchRecord streams the versions of the record after the version of the request, first
+// those already recorded, then those recorded while the call lasts. It polls the service,
+// so it sees the writes of every transport and every instance of the server.
+func (s *Server) WatchRecord(request *WatchRecordRequest, stream grpc.ServerStreamingServer[Record]) error {
+	ctx := stream.Context()
+	id, err := recordID(request.Id)
+	if err != nil {
+		return err
+	}
+	if request.AfterVersion < 0 || request.AfterVersion > math.MaxInt32 {
+		return problem(codes.InvalidArgument, api.CODE_INVALID_VERSION, "invalid after_version; after_version must not be negative")
+	}
+	after := int(request.AfterVersion)
+
+	ticker := time.NewTicker(s.feedInterval)
+	defer ticker.Stop()
+	for {
+		// a record that does not exist yet is watched until it is created
+		versions, err := s.records.GetRecordVersions(ctx, id)
+		if err != nil && !errors.Is(err, service.ErrRecordDoesNotExist) {
+			return serviceProblem(ctx, err)
+		}
+		sort.Ints(versions)
+		for _, version := range versions {
+			if version <= after {
+				continue
+			}
+			record, err := s.records.GetRecordAtVersion(ctx, id, version)
+			// removed by compaction since it was listed
+			if errors.Is(err, service.ErrRecordDoesNotExist) {
+				continue
+			}
+			if err != nil {
+				return serviceProblem(ctx, err)
+			}
+			if err := stream.Send(newRecord(record)); err != nil {
+				return err
+			}
+			after = version
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-s.done:
+			return problem(codes.Unavailable, api.CODE_UNAVAILABLE, "server is shutting down, watch again from the last version received")
+		case <-ticker.C:
+		}
+	}
+}
+
+// newRecord converts a record of the service
+func newRecord(record entity.Record) *Record {
+	return &Record{
+		Id:         int64(record.ID),
+		Data:       record.Data,
+		Version:    int64(record.Version),
+		Timestamp:  record.Timestamp,
+		Updates:    record.Updates,
+		OccurredAt: record.OccurredAt,
+		Author:     record.Author,
+	}
+}
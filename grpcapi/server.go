@@ -0,0 +1,228 @@
+// Package grpcapi serves the record service over gRPC: the operations of the v2 HTTP api,
+// and streams of the history and the new versions of a record. The contract is
+// records.proto, authentication, tenants and error codes work as they do over HTTP.
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative records.proto
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chauvm/timetravel/api"
+	"github.com/chauvm/timetravel/auth"
+	"github.com/chauvm/timetravel/logging"
+	"github.com/chauvm/timetravel/service"
+	"github.com/chauvm/timetravel/tenant"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// FEED_INTERVAL is how often WatchRecord looks for new versions of the record
+const FEED_INTERVAL = time.Second
+
+// ERROR_DOMAIN is the domain of the ErrorInfo detail of every error, its reason is the
+// problem code the HTTP api would send
+const ERROR_DOMAIN = "timetravel"
+
+// metadata keys, the HTTP headers of the same purpose in lower case
+const (
+	API_KEY_METADATA       = "x-api-key"
+	AUTHORIZATION_METADATA = "authorization"
+	TENANT_METADATA        = "x-tenant-id"
+	REQUEST_ID_METADATA    = "x-request-id"
+)
+
+// methodPermissions is the permission each method requires, like the routes of the v2 api
+var methodPermissions = map[string]string{
+	Records_GetRecord_FullMethodName:          auth.PERMISSION_READ,
+	Records_UpsertRecord_FullMethodName:       auth.PERMISSION_WRITE,
+	Records_ListVersions_FullMethodName:       auth.PERMISSION_TIME_TRAVEL,
+	Records_GetRecordAtVersion_FullMethodName: auth.PERMISSION_TIME_TRAVEL,
+	Records_GetRecordHistory_FullMethodName:   auth.PERMISSION_TIME_TRAVEL,
+	Records_WatchRecord_FullMethodName:        auth.PERMISSION_TIME_TRAVEL,
+}
+
+// Server serves a record service over gRPC.
+type Server struct {
+	UnimplementedRecordsServer
+	records service.RecordService
+	// authenticator is nil when authentication is disabled
+	authenticator *auth.Authenticator
+	// feedInterval is FEED_INTERVAL but in tests
+	feedInterval time.Duration
+	server       *grpc.Server
+	// done ends the watches on shutdown, they would keep the server from stopping gracefully
+	done     chan struct{}
+	stopping sync.Once
+}
+
+// NewServer serves records, requiring api keys checked by authenticator, or none if it is
+// nil. Requests are bounded by the MaxBodyBytes limit of the service, if it has limits.
+func NewServer(records service.RecordService, authenticator *auth.Authenticator) *Server {
+	s := &Server{
+		records:       records,
+		authenticator: authenticator,
+		feedInterval:  FEED_INTERVAL,
+		done:          make(chan struct{}),
+	}
+	options := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.interceptUnary),
+		grpc.ChainStreamInterceptor(s.interceptStream),
+	}
+	if limited, ok := records.(service.LimitedService); ok && limited.Limits().MaxBodyBytes > 0 {
+		options = append(options, grpc.MaxRecvMsgSize(limited.Limits().MaxBodyBytes))
+	}
+	s.server = grpc.NewServer(options...)
+	RegisterRecordsServer(s.server, s)
+	return s
+}
+
+// Serve accepts connections on the listener until Shutdown, like http.Server.Serve.
+func (s *Server) Serve(listener net.Listener) error {
+	return s.server.Serve(listener)
+}
+
+// Shutdown ends the watches, stops accepting connections and waits for the calls in
+// flight to finish. When ctx is done first, the remaining calls are cancelled.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopping.Do(func() { close(s.done) })
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s.server.GracefulStop()
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		<-stopped
+		return ctx.Err()
+	}
+}
+
+// interceptUnary authorizes and logs the calls of unary methods
+func (s *Server) interceptUnary(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx, err := s.authorize(ctx, info.FullMethod)
+	var response any
+	if err == nil {
+		response, err = handler(ctx, request)
+	}
+	logCall(ctx, info.FullMethod, start, err)
+	return response, err
+}
+
+// interceptStream authorizes and logs the calls of streaming methods
+func (s *Server) interceptStream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, err := s.authorize(stream.Context(), info.FullMethod)
+	if err == nil {
+		err = handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+	}
+	logCall(ctx, info.FullMethod, start, err)
+	return err
+}
+
+// contextStream is a server stream whose context was replaced
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (c *contextStream) Context() context.Context {
+	return c.ctx
+}
+
+// logCall logs one line per call, like api.LogRequests does per request
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	logging.FromContext(ctx).Info("rpc",
+		"method", method,
+		"code", status.Code(err).String(),
+		"latency_ms", float64(time.Since(start).Microseconds())/1000,
+	)
+}
+
+// authorize assigns the call a request id, or propagates the one sent by the client,
+// then authenticates it and resolves its tenant, as the middlewares of the HTTP api do
+func (s *Server) authorize(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	id := first(md, REQUEST_ID_METADATA)
+	if !logging.ValidRequestID(id) {
+		id = logging.NewRequestID()
+	}
+	ctx = logging.WithRequestID(ctx, id)
+	// fails only when the call is already over, which the handler sees in its context
+	grpc.SetHeader(ctx, metadata.Pairs(REQUEST_ID_METADATA, id))
+
+	if s.authenticator != nil {
+		// a method missing from the table is denied rather than left open
+		permission, known := methodPermissions[method]
+		principal, err := s.authenticator.Authenticate(ctx, apiKey(md))
+		if errors.Is(err, auth.ErrUnauthenticated) {
+			return ctx, problem(codes.Unauthenticated, api.CODE_UNAUTHENTICATED, err.Error())
+		}
+		if err != nil {
+			return ctx, serviceProblem(ctx, err)
+		}
+		if !known || !auth.Allowed(principal.Role, permission) {
+			logging.FromContext(ctx).Warn("permission denied", "principal", principal.Name, "role", principal.Role, "method", method)
+			return ctx, problem(codes.PermissionDenied, api.CODE_FORBIDDEN, "the role "+principal.Role+" may not "+permission)
+		}
+		ctx = auth.WithPrincipal(ctx, principal)
+	}
+
+	requested := first(md, TENANT_METADATA)
+	if requested != "" && !tenant.Valid(requested) {
+		return ctx, problem(codes.InvalidArgument, api.CODE_INVALID_TENANT, tenant.ErrTenantInvalid.Error())
+	}
+	tenantID := requested
+	if principal, ok := auth.PrincipalFrom(ctx); ok {
+		resolved, err := principal.ResolveTenant(requested)
+		if err != nil {
+			logging.FromContext(ctx).Warn("tenant denied", "principal", principal.Name, "tenant", requested)
+			return ctx, problem(codes.PermissionDenied, api.CODE_FORBIDDEN, "the api key may not act on tenant "+requested)
+		}
+		tenantID = resolved
+	}
+	if tenantID == "" {
+		tenantID = tenant.DEFAULT
+	}
+	return tenant.WithID(ctx, tenantID), nil
+}
+
+// apiKey returns the key sent with the call, if any
+func apiKey(md metadata.MD) string {
+	if key := first(md, API_KEY_METADATA); key != "" {
+		return key
+	}
+	if value := first(md, AUTHORIZATION_METADATA); strings.HasPrefix(value, "Bearer ") {
+		return strings.TrimPrefix(value, "Bearer ")
+	}
+	return ""
+}
+
+// first returns the first value of the metadata key, or ""
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// recordID checks the id of a request, record ids are positive 32 bit numbers
+func recordID(id int64) (int, error) {
+	if id <= 0 || id > math.MaxInt32 {
+		return 0, problem(codes.InvalidArgument, api.CODE_INVALID_ID, "invalid id; id must be a positive number")
+	}
+	return int(id), nil
+}
@@ -0,0 +1,26 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrDiskFull = errors.New("not enough free disk space")
+
+// DiskHeadroom checks the file system holding path has at least minFree bytes available.
+func DiskHeadroom(path string, minFree uint64) Check {
+	return Check{
+		Name: "disk",
+		Run: func(ctx context.Context) error {
+			free, err := freeBytes(path)
+			if err != nil {
+				return err
+			}
+			if free < minFree {
+				return fmt.Errorf("%w: %d bytes available, at least %d required", ErrDiskFull, free, minFree)
+			}
+			return nil
+		},
+	}
+}
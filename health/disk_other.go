@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package health
+
+import "math"
+
+// freeBytes is not implemented on this platform, the headroom is not checked
+func freeBytes(path string) (uint64, error) {
+	return math.MaxUint64, nil
+}
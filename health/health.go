@@ -0,0 +1,107 @@
+// Package health runs the readiness checks of the server and reports each component.
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DEFAULT_TIMEOUT bounds how long the readiness checks run together
+const DEFAULT_TIMEOUT = 2 * time.Second
+
+// statuses of the server and of its components
+const (
+	STATUS_OK            = "ok"
+	STATUS_FAILED        = "failed"
+	STATUS_SHUTTING_DOWN = "shutting_down"
+)
+
+// Check is a readiness check of one component, Run returns nil when it is healthy.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Component is the outcome of the check of one component.
+type Component struct {
+	Status    string  `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// Report is the readiness of the server, it is ready when every component is ok.
+type Report struct {
+	OK         bool                 `json:"ok"`
+	Status     string               `json:"status"`
+	Components map[string]Component `json:"components"`
+}
+
+// Checker runs the readiness checks until the server starts shutting down.
+type Checker struct {
+	checks       []Check
+	timeout      time.Duration
+	shuttingDown atomic.Bool
+}
+
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, timeout: timeout}
+}
+
+// SetShuttingDown makes the server report not ready from now on, so load balancers
+// stop sending requests while the requests in flight finish.
+func (c *Checker) SetShuttingDown() {
+	c.shuttingDown.Store(true)
+}
+
+// Ready runs every check concurrently and reports each component.
+func (c *Checker) Ready(ctx context.Context) Report {
+	report := Report{OK: true, Status: STATUS_OK, Components: make(map[string]Component, len(c.checks))}
+	if c.shuttingDown.Load() {
+		report.OK = false
+		report.Status = STATUS_SHUTTING_DOWN
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range c.checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			start := time.Now()
+			err := check.Run(ctx)
+			component := Component{Status: STATUS_OK, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				component.Status = STATUS_FAILED
+				component.Error = err.Error()
+			}
+			mu.Lock()
+			report.Components[check.Name] = component
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	for _, name := range c.Names() {
+		if report.Components[name].Status != STATUS_OK && report.Status == STATUS_OK {
+			report.OK = false
+			report.Status = STATUS_FAILED
+		}
+	}
+	return report
+}
+
+// Names returns the names of the components checked, sorted.
+func (c *Checker) Names() []string {
+	names := make([]string, 0, len(c.checks))
+	for _, check := range c.checks {
+		names = append(names, check.Name)
+	}
+	sort.Strings(names)
+	return names
+}
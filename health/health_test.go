@@ -0,0 +1,56 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReady(t *testing.T) {
+	failing := errors.New("database is locked")
+	ok := Check{Name: "database", Run: func(ctx context.Context) error { return nil }}
+	checker := NewChecker(DEFAULT_TIMEOUT, ok, DiskHeadroom(t.TempDir(), 0))
+
+	report := checker.Ready(context.Background())
+	assert.True(t, report.OK)
+	assert.Equal(t, STATUS_OK, report.Status)
+	assert.Equal(t, STATUS_OK, report.Components["database"].Status)
+	assert.Equal(t, STATUS_OK, report.Components["disk"].Status)
+
+	// any failing component makes the server not ready
+	checker = NewChecker(DEFAULT_TIMEOUT, ok, Check{Name: "migrations", Run: func(ctx context.Context) error { return failing }})
+	report = checker.Ready(context.Background())
+	assert.False(t, report.OK)
+	assert.Equal(t, STATUS_FAILED, report.Status)
+	assert.Equal(t, Component{Status: STATUS_FAILED, Error: "database is locked", LatencyMS: report.Components["migrations"].LatencyMS}, report.Components["migrations"])
+
+	// and so does shutting down, the components are still reported
+	checker = NewChecker(DEFAULT_TIMEOUT, ok)
+	checker.SetShuttingDown()
+	report = checker.Ready(context.Background())
+	assert.False(t, report.OK)
+	assert.Equal(t, STATUS_SHUTTING_DOWN, report.Status)
+	assert.Equal(t, STATUS_OK, report.Components["database"].Status)
+}
+
+func TestReadyTimeout(t *testing.T) {
+	slow := Check{Name: "database", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	report := NewChecker(10*time.Millisecond, slow).Ready(context.Background())
+	assert.False(t, report.OK)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Components["database"].Error)
+}
+
+func TestDiskHeadroom(t *testing.T) {
+	err := DiskHeadroom(t.TempDir(), math.MaxUint64).Run(context.Background())
+	if freeSpace, _ := freeBytes(t.TempDir()); freeSpace == math.MaxUint64 {
+		t.Skip("free disk space is not available on this platform")
+	}
+	assert.True(t, errors.Is(err, ErrDiskFull))
+}
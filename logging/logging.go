@@ -0,0 +1,88 @@
+// Package logging carries the request id through contexts and keeps record payloads
+// out of the logs unless asked for.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync/atomic"
+)
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// WithRequestID returns a context carrying the id of the request being served.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the id of the request being served, empty outside of a request.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// REQUEST_ID_MAX_LENGTH bounds the ids propagated from clients
+const REQUEST_ID_MAX_LENGTH = 128
+
+// ValidRequestID accepts ids sent by clients that are safe to echo and log as is.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > REQUEST_ID_MAX_LENGTH {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+// NewRequestID returns a random 128 bit id, hex encoded.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// FromContext returns the default logger, annotated with the request id of ctx if any.
+func FromContext(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if id := RequestID(ctx); id != "" {
+		logger = logger.With("request_id", id)
+	}
+	return logger
+}
+
+var logPayloads atomic.Bool
+
+// SetLogPayloads turns logging of record payloads on or off, they are redacted by default.
+func SetLogPayloads(on bool) {
+	logPayloads.Store(on)
+}
+
+// Payload is record data that is only written to the logs when payload logging is on.
+type Payload map[string]string
+
+func (p Payload) LogValue() slog.Value {
+	if !logPayloads.Load() {
+		return slog.StringValue(fmt.Sprintf("[redacted %d fields]", len(p)))
+	}
+	keys := make([]string, 0, len(p))
+	for key := range p {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, key := range keys {
+		attrs = append(attrs, slog.String(key, p[key]))
+	}
+	return slog.GroupValue(attrs...)
+}
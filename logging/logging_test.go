@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromContext(t *testing.T) {
+	var out bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&out, nil)))
+
+	FromContext(context.Background()).Info("no request")
+	assert.NotContains(t, out.String(), "request_id")
+
+	ctx := WithRequestID(context.Background(), "abc")
+	assert.Equal(t, "abc", RequestID(ctx))
+	FromContext(ctx).Info("in a request")
+	assert.Contains(t, out.String(), "\"request_id\":\"abc\"")
+}
+
+func TestPayload(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+	data := Payload{"name": "Jane Doe", "ssn": "123-45-6789"}
+
+	logger.Info("redacted", "data", data)
+	assert.Contains(t, out.String(), "\"data\":\"[redacted 2 fields]\"")
+	assert.NotContains(t, out.String(), "Jane Doe")
+
+	SetLogPayloads(true)
+	defer SetLogPayloads(false)
+	out.Reset()
+	logger.Info("logged", "data", data)
+	assert.Contains(t, out.String(), "\"data\":{\"name\":\"Jane Doe\",\"ssn\":\"123-45-6789\"}")
+}
@@ -0,0 +1,372 @@
+// Package metrics keeps counters, gauges and histograms and writes them in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CONTENT_TYPE is the media type of the Prometheus text exposition format
+const CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// DURATION_BUCKETS are the upper bounds, in seconds, of latency histograms
+var DURATION_BUCKETS = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric is anything a registry can write
+type metric interface {
+	name() string
+	write(w io.Writer) error
+}
+
+// Registry holds the metrics exposed together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]metric{}}
+}
+
+// Default is the registry served on /metrics.
+var Default = NewRegistry()
+
+// register adds m, replacing a metric of the same name
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics[m.name()] = m
+}
+
+// Write writes every metric, sorted by name.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler serves the metrics of the registry, or a 500 if any of them cannot be read.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body bytes.Buffer
+		if err := r.Write(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", CONTENT_TYPE)
+		_, _ = body.WriteTo(w)
+	})
+}
+
+// desc is the name, help and label names shared by every kind of metric
+type desc struct {
+	metricName string
+	help       string
+	labels     []string
+}
+
+func (d desc) name() string { return d.metricName }
+
+func (d desc) writeHeader(w io.Writer, kind string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.metricName, escapeHelp(d.help), d.metricName, kind)
+	return err
+}
+
+// key joins label values into a map key, they cannot contain the separator
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", d.metricName, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs formats the labels of a series, with extra pairs such as le appended
+func (d desc) labelPairs(key string, extra ...string) string {
+	pairs := make([]string, 0, len(d.labels)+len(extra)/2)
+	if len(d.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", d.labels[i], escapeLabel(value)))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extra[i], escapeLabel(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// CounterVec is a counter per combination of label values.
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec registers a counter with the given label names.
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name, help, labels}, values: map[string]float64{}}
+	if len(labels) == 0 {
+		// a counter without labels has a single series, exposed from the start
+		c.values[""] = 0
+	}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter of the label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v, which must not be negative, to the counter of the label values.
+func (c *CounterVec) Add(v float64, values ...string) {
+	key := c.key(values)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(w io.Writer) error {
+	if err := c.writeHeader(w, "counter"); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.labelPairs(key), formatFloat(c.values[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HistogramVec is a histogram per combination of label values.
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec registers a histogram with the given upper bounds, sorted ascending.
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{desc: desc{name, help, labels}, buckets: buckets, values: map[string]*histogram{}}
+	r.register(h)
+	return h
+}
+
+// Observe adds v to the histogram of the label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			hist.counts[i]++
+		}
+	}
+	hist.sum += v
+	hist.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) error {
+	if err := h.writeHeader(w, "histogram"); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.values) {
+		if err := writeHistogram(w, h.desc, key, h.buckets, h.values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeHistogram(w io.Writer, d desc, key string, buckets []float64, hist *histogram) error {
+	for i, bound := range buckets {
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", d.metricName, d.labelPairs(key, "le", formatFloat(bound)), hist.counts[i]); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+		d.metricName, d.labelPairs(key, "le", "+Inf"), hist.count,
+		d.metricName, d.labelPairs(key), formatFloat(hist.sum),
+		d.metricName, d.labelPairs(key), hist.count)
+	return err
+}
+
+// GaugeFunc is a gauge whose value is read when the metrics are written.
+type GaugeFunc struct {
+	desc
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge reading its value from f.
+func (r *Registry) NewGaugeFunc(name string, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{metricName: name, help: help}, value: f}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) error {
+	if err := g.writeHeader(w, "gauge"); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.value()))
+	return err
+}
+
+// HistogramFunc is a histogram whose observations are read when the metrics are written,
+// for distributions kept elsewhere such as in the database.
+type HistogramFunc struct {
+	desc
+	buckets      []float64
+	observations func() (map[float64]uint64, error)
+}
+
+// NewHistogramFunc registers a histogram reading how many times each value was observed from f.
+func (r *Registry) NewHistogramFunc(name string, help string, buckets []float64, f func() (map[float64]uint64, error)) *HistogramFunc {
+	h := &HistogramFunc{desc: desc{metricName: name, help: help}, buckets: buckets, observations: f}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramFunc) write(w io.Writer) error {
+	observations, err := h.observations()
+	if err != nil {
+		return fmt.Errorf("metrics: %s: %w", h.metricName, err)
+	}
+	if err := h.writeHeader(w, "histogram"); err != nil {
+		return err
+	}
+	hist := &histogram{counts: make([]uint64, len(h.buckets))}
+	for value, count := range observations {
+		for i, bound := range h.buckets {
+			if value <= bound {
+				hist.counts[i] += count
+			}
+		}
+		hist.sum += value * float64(count)
+		hist.count += count
+	}
+	return writeHistogram(w, h.desc, "", h.buckets, hist)
+}
+
+// HistogramSnapshot is a histogram whose observations are read by a query too costly to run
+// on every scrape: Refresh, or Run on a timer, reads them and scrapes write the last read.
+type HistogramSnapshot struct {
+	*HistogramFunc
+	read func(ctx context.Context) (map[float64]uint64, error)
+
+	mu           sync.Mutex
+	observations map[float64]uint64
+}
+
+// NewHistogramSnapshot registers a histogram reading how many times each value was observed
+// from f when refreshed, empty until then.
+func (r *Registry) NewHistogramSnapshot(name string, help string, buckets []float64, f func(ctx context.Context) (map[float64]uint64, error)) *HistogramSnapshot {
+	s := &HistogramSnapshot{read: f, observations: map[float64]uint64{}}
+	s.HistogramFunc = r.NewHistogramFunc(name, help, buckets, s.last)
+	return s
+}
+
+func (s *HistogramSnapshot) last() (map[float64]uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.observations, nil
+}
+
+// Refresh reads the observations, the last read is kept if it fails.
+func (s *HistogramSnapshot) Refresh(ctx context.Context) error {
+	observations, err := s.read(ctx)
+	if err != nil {
+		return fmt.Errorf("metrics: %s: %w", s.metricName, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observations = observations
+	return nil
+}
+
+// Run refreshes the observations every interval until the context is done.
+func (s *HistogramSnapshot) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Print(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(value string) string { return labelEscaper.Replace(value) }
+func escapeHelp(help string) string   { return helpEscaper.Replace(help) }
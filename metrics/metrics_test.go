@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests served.", "route", "status")
+	requests.Inc("/records/{id}", "200")
+	requests.Add(2, "/records/{id}", "200")
+	requests.Inc("/a\"b", "404")
+	latency := r.NewHistogramVec("latency_seconds", "Request latency.", []float64{0.1, 1}, "route")
+	latency.Observe(0.05, "/records/{id}")
+	latency.Observe(0.5, "/records/{id}")
+	r.NewGaugeFunc("size_bytes", "Size.\nOf the file.", func() float64 { return 4096 })
+	r.NewHistogramFunc("versions", "Versions per record.", []float64{1, 10}, func() (map[float64]uint64, error) {
+		return map[float64]uint64{1: 3, 4: 1, 20: 1}, nil
+	})
+	r.NewCounterVec("busy_total", "Busy errors.")
+
+	var out bytes.Buffer
+	assert.NoError(t, r.Write(&out))
+	assert.Equal(t, `# HELP busy_total Busy errors.
+# TYPE busy_total counter
+busy_total 0
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/records/{id}",le="0.1"} 1
+latency_seconds_bucket{route="/records/{id}",le="1"} 2
+latency_seconds_bucket{route="/records/{id}",le="+Inf"} 2
+latency_seconds_sum{route="/records/{id}"} 0.55
+latency_seconds_count{route="/records/{id}"} 2
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/a\"b",status="404"} 1
+requests_total{route="/records/{id}",status="200"} 3
+# HELP size_bytes Size.\nOf the file.
+# TYPE size_bytes gauge
+size_bytes 4096
+# HELP versions Versions per record.
+# TYPE versions histogram
+versions_bucket{le="1"} 3
+versions_bucket{le="10"} 4
+versions_bucket{le="+Inf"} 5
+versions_sum 27
+versions_count 5
+`, out.String())
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("up", "Always one.", func() float64 { return 1 })
+
+	rr := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, 200, rr.Code)
+	assert.Equal(t, CONTENT_TYPE, rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), "up 1\n")
+}
+
+func TestHistogramSnapshot(t *testing.T) {
+	r := NewRegistry()
+	reads := 0
+	failing := false
+	s := r.NewHistogramSnapshot("versions", "Versions per record.", []float64{1, 10}, func(ctx context.Context) (map[float64]uint64, error) {
+		if failing {
+			return nil, errors.New("database is locked")
+		}
+		reads++
+		return map[float64]uint64{1: 3, 4: 1}, nil
+	})
+
+	// empty until refreshed, and scrapes do not read
+	var out bytes.Buffer
+	assert.NoError(t, r.Write(&out))
+	assert.Contains(t, out.String(), "versions_count 0\n")
+	assert.NoError(t, s.Refresh(context.Background()))
+	out.Reset()
+	assert.NoError(t, r.Write(&out))
+	assert.NoError(t, r.Write(&out))
+	assert.Equal(t, 1, reads)
+	assert.Contains(t, out.String(), "versions_bucket{le=\"1\"} 3\nversions_bucket{le=\"10\"} 4\n")
+
+	// a failed read keeps the last one
+	failing = true
+	assert.Error(t, s.Refresh(context.Background()))
+	out.Reset()
+	assert.NoError(t, r.Write(&out))
+	assert.Contains(t, out.String(), "versions_count 4\n")
+}
@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+)
+
+// KeyStore keeps the api keys in Postgres, it is the auth.KeyStore of the Postgres backend.
+//
+// Keys are not data of a tenant, the api_keys queries are not scoped to the tenant of ctx.
+type KeyStore struct {
+	db *sql.DB
+}
+
+func NewKeyStore(db *sql.DB) KeyStore {
+	return KeyStore{db}
+}
+
+// InsertAPIKey stores the hash of a new key, it fails with ErrConflict if the name is taken.
+// A key bound to no tenant, "", acts on the default tenant, or on any tenant if its role
+// grants auth.PERMISSION_ANY_TENANT.
+func (s KeyStore) InsertAPIKey(ctx context.Context, name string, hash string, role string, tenantID string) error {
+	defer observeQuery("insert_api_key", time.Now())
+	var boundTo interface{}
+	if tenantID != "" {
+		boundTo = tenantID
+	}
+	_, err := s.db.ExecContext(ctx, "INSERT INTO api_keys (name, hash, role, tenant) VALUES ($1, $2, $3, $4)",
+		name, hash, role, boundTo)
+	return translateError(err)
+}
+
+// GetAPIKeyByHash returns the key with that hash unless it is revoked, or ErrNotFound.
+func (s KeyStore) GetAPIKeyByHash(ctx context.Context, hash string) (*entity.APIKey, error) {
+	defer observeQuery("get_api_key_by_hash", time.Now())
+	row := s.db.QueryRowContext(ctx, "SELECT name, role, tenant, created_at, revoked_at FROM api_keys WHERE hash = $1 AND revoked_at IS NULL", hash)
+	return scanAPIKey(row)
+}
+
+// RevokeAPIKey revokes the key of that name for good.
+func (s KeyStore) RevokeAPIKey(ctx context.Context, name string) error {
+	defer observeQuery("revoke_api_key", time.Now())
+	res, err := s.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = date_trunc('second', now()) WHERE name = $1 AND revoked_at IS NULL", name)
+	if err != nil {
+		return translateError(err)
+	}
+	if revoked, err := res.RowsAffected(); err != nil {
+		return translateError(err)
+	} else if revoked == 0 {
+		return database.ErrAPIKeyDoesNotExist
+	}
+	return nil
+}
+
+// GetAPIKeys returns every key, revoked ones included, oldest first.
+func (s KeyStore) GetAPIKeys(ctx context.Context) ([]entity.APIKey, error) {
+	defer observeQuery("get_api_keys", time.Now())
+	rows, err := s.db.QueryContext(ctx, "SELECT name, role, tenant, created_at, revoked_at FROM api_keys ORDER BY id ASC")
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	keys := make([]entity.APIKey, 0)
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, translateError(rows.Err())
+}
+
+func scanAPIKey(row rowScanner) (*entity.APIKey, error) {
+	key := entity.APIKey{}
+	var tenantID sql.NullString
+	var createdAt time.Time
+	var revokedAt sql.NullTime
+	if err := row.Scan(&key.Name, &key.Role, &tenantID, &createdAt, &revokedAt); err != nil {
+		return nil, translateError(err)
+	}
+	key.Tenant = tenantID.String
+	key.CreatedAt = formatTime(createdAt)
+	if revokedAt.Valid {
+		key.RevokedAt = formatTime(revokedAt.Time)
+	}
+	return &key, nil
+}
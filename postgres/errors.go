@@ -0,0 +1,44 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/lib/pq"
+)
+
+// LEGAL_HOLD_SQLSTATE is the error the legal hold trigger of migrations/0001_create_schema.sql
+// raises when a held record is deleted or rewritten
+const LEGAL_HOLD_SQLSTATE pq.ErrorCode = "TT001"
+
+// translateError turns a driver error into one of the errors of the database package,
+// keeping the original.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %w", database.ErrNotFound, err)
+	}
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	switch {
+	case pqErr.Code == LEGAL_HOLD_SQLSTATE:
+		return database.ErrRecordOnHold
+	case pqErr.Code.Name() == "unique_violation":
+		return fmt.Errorf("%w: %w", database.ErrConflict, err)
+	case pqErr.Code.Class() == "23":
+		return fmt.Errorf("%w: %w", database.ErrConstraint, err)
+	// another transaction holds what this one needs, trying again later succeeds
+	case pqErr.Code.Name() == "serialization_failure", pqErr.Code.Name() == "deadlock_detected", pqErr.Code.Name() == "lock_not_available":
+		return fmt.Errorf("%w: %w", database.ErrBusy, err)
+	case pqErr.Code.Name() == "data_corrupted", pqErr.Code.Name() == "index_corrupted":
+		return fmt.Errorf("%w: %w", database.ErrCorrupt, err)
+	}
+	return err
+}
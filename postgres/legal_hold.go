@@ -0,0 +1,103 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/tenant"
+)
+
+// PlaceLegalHold puts the record under legal hold and records who placed it and why.
+func PlaceLegalHold(ctx context.Context, db *sql.DB, id int, actor string, reason string) error {
+	defer observeQuery("place_legal_hold", time.Now())
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO legal_holds (tenant, record_id, placed_by, reason) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING",
+		tenant.ID(ctx), id, actor, reason)
+	if err != nil {
+		return translateError(err)
+	}
+	if inserted, err := res.RowsAffected(); err != nil {
+		return translateError(err)
+	} else if inserted == 0 {
+		return database.ErrLegalHoldExists
+	}
+
+	if err := insertLegalHoldEvent(ctx, tx, id, database.LEGAL_HOLD_PLACED, actor, reason); err != nil {
+		return err
+	}
+	return translateError(tx.Commit())
+}
+
+// ReleaseLegalHold lifts the legal hold on the record and records who released it and why.
+func ReleaseLegalHold(ctx context.Context, db *sql.DB, id int, actor string, reason string) error {
+	defer observeQuery("release_legal_hold", time.Now())
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM legal_holds WHERE tenant = $1 AND record_id = $2", tenant.ID(ctx), id)
+	if err != nil {
+		return translateError(err)
+	}
+	if deleted, err := res.RowsAffected(); err != nil {
+		return translateError(err)
+	} else if deleted == 0 {
+		return database.ErrLegalHoldDoesNotExist
+	}
+
+	if err := insertLegalHoldEvent(ctx, tx, id, database.LEGAL_HOLD_RELEASED, actor, reason); err != nil {
+		return err
+	}
+	return translateError(tx.Commit())
+}
+
+func insertLegalHoldEvent(ctx context.Context, tx *sql.Tx, id int, action string, actor string, reason string) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO legal_hold_events (tenant, record_id, action, actor, reason) VALUES ($1, $2, $3, $4, $5)",
+		tenant.ID(ctx), id, action, actor, reason)
+	return translateError(err)
+}
+
+// GetLegalHold returns the current hold on the record, or ErrNotFound if it is not held.
+func GetLegalHold(ctx context.Context, db *sql.DB, id int) (*entity.LegalHold, error) {
+	defer observeQuery("get_legal_hold", time.Now())
+	row := db.QueryRowContext(ctx, "SELECT record_id, placed_by, reason, placed_at FROM legal_holds WHERE tenant = $1 AND record_id = $2", tenant.ID(ctx), id)
+	hold := entity.LegalHold{}
+	var placedAt time.Time
+	if err := row.Scan(&hold.RecordID, &hold.PlacedBy, &hold.Reason, &placedAt); err != nil {
+		return nil, translateError(err)
+	}
+	hold.PlacedAt = formatTime(placedAt)
+	return &hold, nil
+}
+
+// GetLegalHoldEvents returns every time a hold was placed on or released from the record, oldest first.
+func GetLegalHoldEvents(ctx context.Context, db *sql.DB, id int) ([]entity.LegalHoldEvent, error) {
+	defer observeQuery("get_legal_hold_events", time.Now())
+	rows, err := db.QueryContext(ctx, "SELECT record_id, action, actor, reason, timestamp FROM legal_hold_events WHERE tenant = $1 AND record_id = $2 ORDER BY id ASC", tenant.ID(ctx), id)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	events := make([]entity.LegalHoldEvent, 0)
+	for rows.Next() {
+		event := entity.LegalHoldEvent{}
+		var timestamp time.Time
+		if err := rows.Scan(&event.RecordID, &event.Action, &event.Actor, &event.Reason, &timestamp); err != nil {
+			return nil, translateError(err)
+		}
+		event.Timestamp = formatTime(timestamp)
+		events = append(events, event)
+	}
+	return events, translateError(rows.Err())
+}
@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/metrics"
+)
+
+var queryDuration = metrics.Default.NewHistogramVec("timetravel_postgres_query_duration_seconds",
+	"Duration of the postgres operations.", metrics.DURATION_BUCKETS, "op")
+
+// observeQuery records how long the operation op took since start, call it deferred
+func observeQuery(op string, start time.Time) {
+	queryDuration.Observe(time.Since(start).Seconds(), op)
+}
+
+// RegisterMetrics adds the metrics read from the database, the same as
+// database.RegisterMetrics but for the size of the file, which the server keeps.
+func RegisterMetrics(registry *metrics.Registry, db *sql.DB) *metrics.HistogramSnapshot {
+	registry.NewGaugeFunc("timetravel_db_open_connections", "Connections open to the database, in use or idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	registry.NewGaugeFunc("timetravel_db_in_use_connections", "Connections currently in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	registry.NewGaugeFunc("timetravel_db_wait_count", "Total number of times a query waited for a free connection.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	return registry.NewHistogramSnapshot("timetravel_record_versions", "Number of versions kept per record.", database.VERSION_BUCKETS, func(ctx context.Context) (map[float64]uint64, error) {
+		return GetVersionCounts(ctx, db)
+	})
+}
+
+// GetVersionCounts returns how many records have each number of versions.
+func GetVersionCounts(ctx context.Context, db *sql.DB) (map[float64]uint64, error) {
+	defer observeQuery("get_version_counts", time.Now())
+	rows, err := db.QueryContext(ctx, `SELECT versions, COUNT(*) FROM (SELECT COUNT(*) AS versions FROM records GROUP BY tenant, id) AS counts GROUP BY versions`)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	counts := map[float64]uint64{}
+	for rows.Next() {
+		var versions float64
+		var records uint64
+		if err := rows.Scan(&versions, &records); err != nil {
+			return nil, translateError(err)
+		}
+		counts[versions] = records
+	}
+	return counts, translateError(rows.Err())
+}
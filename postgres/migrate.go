@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/chauvm/timetravel/database"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const INIT_SCHEMA_VERSION string = `
+ CREATE TABLE IF NOT EXISTS schema_version (
+ version INTEGER NOT NULL PRIMARY KEY,
+ name TEXT NOT NULL,
+ applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+ );`
+
+// MIGRATION_LOCK is the advisory lock servers starting at once take to migrate one at a time.
+const MIGRATION_LOCK int64 = 0x74696d6574726176
+
+var migrationFileName = regexp.MustCompile(`^(\d{4})_(\w+)\.sql$`)
+
+// Migrations returns every migration embedded in this build, in order.
+func Migrations() ([]database.Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]database.Migration, 0, len(entries))
+	for _, entry := range entries {
+		matches := migrationFileName.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("migration %s must be named NNNN_name.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(matches[1])
+		content, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, database.Migration{Version: version, Name: matches[2], SQL: string(content)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration %04d_%s is out of sequence, expected version %d", migration.Version, migration.Name, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// schemaVersion returns the version of the last migration applied, 0 if none.
+func schemaVersion(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}) (int, error) {
+	var version sql.NullInt64
+	if err := q.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// pendingMigrations returns the migrations of this build not applied at version yet.
+func pendingMigrations(version int) ([]database.Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if version > len(migrations) {
+		return nil, fmt.Errorf("%w: database is at version %d, this build knows %d", database.ErrSchemaTooNew, version, len(migrations))
+	}
+	return migrations[version:], nil
+}
+
+// Migrate applies the pending migrations in order, all in one transaction holding
+// MIGRATION_LOCK, so servers starting together never apply a migration twice.
+//
+// Migrate refuses to touch a database migrated by a newer build.
+func Migrate(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", MIGRATION_LOCK); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(INIT_SCHEMA_VERSION); err != nil {
+		return err
+	}
+	version, err := schemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	pending, err := pendingMigrations(version)
+	if err != nil {
+		return err
+	}
+	for _, migration := range pending {
+		log.Printf("Applying postgres migration %04d_%s", migration.Version, migration.Name)
+		if _, err := tx.Exec(migration.SQL); err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_version (version, name) VALUES ($1, $2)", migration.Version, migration.Name); err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// CheckMigrations fails when the migrations of this build are not all applied.
+func CheckMigrations(ctx context.Context, db *sql.DB) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return translateError(err)
+	}
+	pending, err := pendingMigrations(version)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d to apply, next is %04d_%s", database.ErrMigrationsPending, len(pending), pending[0].Version, pending[0].Name)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+-- the schema of database/migrations/0001 to 0005 at once, with Postgres types: times are
+-- timestamptz recorded to the second like SQLite's CURRENT_TIMESTAMP, data is jsonb.
+CREATE TABLE records (
+ tenant TEXT NOT NULL DEFAULT 'default',
+ id BIGINT NOT NULL,
+ version INTEGER NOT NULL,
+ timestamp TIMESTAMPTZ NOT NULL DEFAULT date_trunc('second', now()),
+ data JSONB NOT NULL,
+ updates JSONB NOT NULL,
+ occurred_at TIMESTAMPTZ,
+ author TEXT,
+ PRIMARY KEY (tenant, id, version)
+);
+
+-- the latest version of every record. Writers lock the row of the record they write with
+-- SELECT ... FOR UPDATE, so versions are assigned one after the other and none is lost.
+CREATE TABLE record_heads (
+ tenant TEXT NOT NULL DEFAULT 'default',
+ id BIGINT NOT NULL,
+ version INTEGER NOT NULL,
+ PRIMARY KEY (tenant, id)
+);
+
+CREATE TABLE legal_holds (
+ tenant TEXT NOT NULL DEFAULT 'default',
+ record_id BIGINT NOT NULL,
+ placed_by TEXT NOT NULL,
+ reason TEXT NOT NULL,
+ placed_at TIMESTAMPTZ NOT NULL DEFAULT date_trunc('second', now()),
+ PRIMARY KEY (tenant, record_id)
+);
+
+CREATE TABLE legal_hold_events (
+ id BIGSERIAL PRIMARY KEY,
+ tenant TEXT NOT NULL DEFAULT 'default',
+ record_id BIGINT NOT NULL,
+ action TEXT NOT NULL,
+ actor TEXT NOT NULL,
+ reason TEXT NOT NULL,
+ timestamp TIMESTAMPTZ NOT NULL DEFAULT date_trunc('second', now())
+);
+
+CREATE INDEX legal_hold_events_tenant_record_id ON legal_hold_events (tenant, record_id);
+
+-- records under legal hold cannot be deleted or rewritten, appending new versions is still
+-- allowed. The SQLSTATE must match postgres.LEGAL_HOLD_SQLSTATE.
+CREATE FUNCTION records_legal_hold() RETURNS trigger AS $$
+BEGIN
+ IF EXISTS (SELECT 1 FROM legal_holds WHERE tenant = OLD.tenant AND record_id = OLD.id) THEN
+  RAISE EXCEPTION 'record is under legal hold' USING ERRCODE = 'TT001';
+ END IF;
+ IF TG_OP = 'DELETE' THEN
+  RETURN OLD;
+ END IF;
+ RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER records_legal_hold BEFORE UPDATE OR DELETE ON records
+FOR EACH ROW EXECUTE FUNCTION records_legal_hold();
+
+-- only the sha256 of a key is stored, the key itself is shown once when minted. tenant is
+-- the tenant the key is bound to, NULL for keys acting on the default tenant, or on any
+-- tenant for roles allowed to, see auth.Principal.ResolveTenant.
+CREATE TABLE api_keys (
+ id BIGSERIAL PRIMARY KEY,
+ name TEXT NOT NULL UNIQUE,
+ hash TEXT NOT NULL UNIQUE,
+ role TEXT NOT NULL,
+ tenant TEXT,
+ created_at TIMESTAMPTZ NOT NULL DEFAULT date_trunc('second', now()),
+ revoked_at TIMESTAMPTZ
+);
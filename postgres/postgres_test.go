@@ -0,0 +1,196 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/postgres/postgrestest"
+	"github.com/chauvm/timetravel/tenant"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// openTestDB opens a fresh schema of the test server
+func openTestDB(t *testing.T) *sql.DB {
+	options := DefaultOptions()
+	options.URL = postgrestest.Schema(t, postgrestest.Server(t), t.Name())
+	db, err := Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Close(db) })
+	return db
+}
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+	for i, migration := range migrations {
+		assert.Equal(t, i+1, migration.Version)
+		assert.NotEmpty(t, migration.SQL)
+	}
+}
+
+func TestTranslateError(t *testing.T) {
+	assert.Nil(t, translateError(nil))
+	assert.True(t, errors.Is(translateError(sql.ErrNoRows), database.ErrNotFound))
+	for code, expected := range map[pq.ErrorCode]error{
+		"23505":             database.ErrConflict,
+		"23502":             database.ErrConstraint,
+		"40001":             database.ErrBusy,
+		"40P01":             database.ErrBusy,
+		"XX001":             database.ErrCorrupt,
+		LEGAL_HOLD_SQLSTATE: database.ErrRecordOnHold,
+	} {
+		err := translateError(fmt.Errorf("query: %w", &pq.Error{Code: code}))
+		assert.True(t, errors.Is(err, expected), "code %s", code)
+	}
+	other := errors.New("connection refused")
+	assert.Equal(t, other, translateError(other))
+}
+
+func TestMigrate(t *testing.T) {
+	db := openTestDB(t)
+	// a second server starting finds nothing to do
+	assert.NoError(t, Migrate(db))
+	assert.NoError(t, CheckMigrations(context.Background(), db))
+	assert.NoError(t, Ping(context.Background(), db))
+}
+
+func TestRecordsAndLegalHolds(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	acme := tenant.WithID(ctx, "acme")
+
+	assert.NoError(t, InsertRecord(ctx, db, entity.Record{ID: 1, Version: 1, Data: map[string]string{"a": "1"}, Updates: map[string]string{"a": "1"}}))
+	assert.True(t, errors.Is(InsertRecord(ctx, db, entity.Record{ID: 1, Version: 1}), database.ErrConflict))
+	// ids are unique per tenant
+	assert.NoError(t, InsertRecord(acme, db, entity.Record{ID: 1, Version: 1, Data: map[string]string{"b": "1"}}))
+
+	for _, value := range []string{"2", "3"} {
+		_, err := UpdateRecord(ctx, db, 1, func(latest entity.Record, versions int) (entity.Record, error) {
+			assert.Equal(t, latest.Version, versions)
+			return entity.Record{ID: 1, Version: latest.Version + 1, Data: map[string]string{"a": value}, Updates: map[string]string{"a": value}}, nil
+		})
+		assert.NoError(t, err)
+	}
+	rejected := errors.New("rejected")
+	_, err := UpdateRecord(ctx, db, 1, func(latest entity.Record, versions int) (entity.Record, error) { return entity.Record{}, rejected })
+	assert.Equal(t, rejected, err)
+	_, err = UpdateRecord(ctx, db, 2, func(latest entity.Record, versions int) (entity.Record, error) { return latest, nil })
+	assert.True(t, errors.Is(err, database.ErrNotFound))
+
+	versions, err := GetRecordVersions(ctx, db, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 2, 1}, versions)
+	tenants, err := GetTenants(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"acme", tenant.DEFAULT}, tenants)
+
+	assert.NoError(t, PlaceLegalHold(ctx, db, 1, "legal@example.com", "litigation"))
+	assert.True(t, errors.Is(PlaceLegalHold(ctx, db, 1, "legal@example.com", "litigation"), database.ErrLegalHoldExists))
+	// the trigger protects the history of a held record from any writer
+	assert.True(t, errors.Is(DeleteRecordVersions(ctx, db, 1, []int{2}), database.ErrRecordOnHold))
+	_, err = DeleteTenant(ctx, db)
+	assert.True(t, errors.Is(err, database.ErrRecordOnHold))
+	// the hold only covers the record of its tenant
+	assert.NoError(t, DeleteRecordVersions(acme, db, 1, []int{}))
+
+	assert.NoError(t, ReleaseLegalHold(ctx, db, 1, "legal@example.com", "settled"))
+	assert.True(t, errors.Is(ReleaseLegalHold(ctx, db, 1, "legal@example.com", "settled"), database.ErrLegalHoldDoesNotExist))
+	events, err := GetLegalHoldEvents(ctx, db, 1)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	assert.NoError(t, DeleteRecordVersions(ctx, db, 1, []int{2}))
+	history, err := GetRecordHistory(ctx, db, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, map[string]string{"a": "3"}, history[1].Updates)
+	}
+
+	deleted, err := DeleteTenant(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	// the history of the holds outlives the records
+	events, err = GetLegalHoldEvents(ctx, db, 1)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	_, err = GetLatestRecord(ctx, db, 1)
+	assert.True(t, errors.Is(err, database.ErrNotFound))
+	// the id can be used again
+	assert.NoError(t, InsertRecord(ctx, db, entity.Record{ID: 1, Version: 1, Data: map[string]string{}}))
+}
+
+func TestUpdateRecordCountsUnderLock(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	assert.NoError(t, InsertRecord(ctx, db, entity.Record{ID: 1, Version: 1, Data: map[string]string{}}))
+
+	// writers racing for the last versions below the limit see the count of each other
+	const maxVersions = 3
+	tooMany := errors.New("too many versions")
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := UpdateRecord(ctx, db, 1, func(latest entity.Record, versions int) (entity.Record, error) {
+				if versions >= maxVersions {
+					return entity.Record{}, tooMany
+				}
+				return entity.Record{ID: 1, Version: latest.Version + 1, Data: map[string]string{}, Updates: map[string]string{}}, nil
+			})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	updated := 0
+	for err := range errs {
+		if err == nil {
+			updated++
+		} else {
+			assert.Equal(t, tooMany, err)
+		}
+	}
+	assert.Equal(t, maxVersions-1, updated)
+	versions, err := GetRecordVersions(ctx, db, 1)
+	assert.NoError(t, err)
+	assert.Len(t, versions, maxVersions)
+}
+
+func TestKeyStore(t *testing.T) {
+	keys := NewKeyStore(openTestDB(t))
+	ctx := context.Background()
+
+	assert.NoError(t, keys.InsertAPIKey(ctx, "alice", "hash-a", "writer", ""))
+	assert.NoError(t, keys.InsertAPIKey(ctx, "bob", "hash-b", "reader", "acme"))
+	assert.True(t, errors.Is(keys.InsertAPIKey(ctx, "alice", "hash-c", "reader", ""), database.ErrConflict))
+
+	key, err := keys.GetAPIKeyByHash(ctx, "hash-b")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", key.Name)
+	assert.Equal(t, "acme", key.Tenant)
+	assert.NotEmpty(t, key.CreatedAt)
+
+	assert.NoError(t, keys.RevokeAPIKey(ctx, "bob"))
+	assert.True(t, errors.Is(keys.RevokeAPIKey(ctx, "bob"), database.ErrAPIKeyDoesNotExist))
+	_, err = keys.GetAPIKeyByHash(ctx, "hash-b")
+	assert.True(t, errors.Is(err, database.ErrNotFound))
+
+	all, err := keys.GetAPIKeys(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, all, 2) {
+		assert.Equal(t, "alice", all[0].Name)
+		assert.NotEmpty(t, all[1].RevokedAt)
+	}
+}
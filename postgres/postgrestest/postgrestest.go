@@ -0,0 +1,113 @@
+// Package postgrestest gives tests a Postgres server to run against: the one at
+// TIMETRAVEL_TEST_POSTGRES_URL or, when initdb and pg_ctl are on the PATH, a throwaway
+// one started for the test. Tests are skipped when neither is available.
+package postgrestest
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+// URL_ENV names the environment variable of the server to use instead of starting one.
+const URL_ENV = "TIMETRAVEL_TEST_POSTGRES_URL"
+
+// Server returns the url of a Postgres server, a throwaway one is stopped when the test ends.
+func Server(t *testing.T) string {
+	t.Helper()
+	if serverURL := os.Getenv(URL_ENV); serverURL != "" {
+		return serverURL
+	}
+	for _, tool := range []string{"initdb", "pg_ctl"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("postgres is unavailable: set %s or put initdb and pg_ctl on the PATH", URL_ENV)
+		}
+	}
+
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	// initdb refuses to run as root, among other things the environment may not allow
+	if out, err := exec.Command("initdb", "-D", data, "-U", "postgres", "-A", "trust", "--no-sync").CombinedOutput(); err != nil {
+		t.Skipf("postgres is unavailable, initdb failed: %v\n%s", err, out)
+	}
+	port, err := freePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := fmt.Sprintf("-h 127.0.0.1 -p %d -k %s -c fsync=off", port, dir)
+	if out, err := exec.Command("pg_ctl", "-D", data, "-o", options, "-l", filepath.Join(dir, "postgres.log"), "-w", "start").CombinedOutput(); err != nil {
+		t.Skipf("postgres is unavailable, pg_ctl start failed: %v\n%s", err, out)
+	}
+	t.Cleanup(func() {
+		if out, err := exec.Command("pg_ctl", "-D", data, "-m", "immediate", "-w", "stop").CombinedOutput(); err != nil {
+			t.Errorf("pg_ctl stop: %v\n%s", err, out)
+		}
+	})
+	return fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port)
+}
+
+// Schema returns a url of the server whose connections work in a schema of their own,
+// named after name: the same name is the same schema, so reopening it finds the tables
+// left there. The schema is dropped when the test ends.
+func Schema(t *testing.T, serverURL string, name string) string {
+	t.Helper()
+	sum := sha256.Sum256([]byte(name))
+	schema := "timetravel_" + hex.EncodeToString(sum[:8])
+
+	db, err := sql.Open("postgres", serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", schema)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db, err := sql.Open("postgres", serverURL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer db.Close()
+		if _, err := db.Exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", schema)); err != nil {
+			t.Error(err)
+		}
+	})
+	return withSearchPath(serverURL, schema)
+}
+
+// withSearchPath sets the search_path run-time parameter, which lib/pq passes on to the server
+func withSearchPath(serverURL string, schema string) string {
+	if !strings.HasPrefix(serverURL, "postgres://") && !strings.HasPrefix(serverURL, "postgresql://") {
+		// a key=value connection string
+		return serverURL + " search_path=" + schema
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return serverURL
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// freePort returns a port nothing listens on right now
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
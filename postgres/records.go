@@ -0,0 +1,296 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/logging"
+	"github.com/chauvm/timetravel/tenant"
+)
+
+// RECORD_COLUMNS are the columns scanRecord reads, in order
+const RECORD_COLUMNS string = "id, timestamp, data, updates, version, occurred_at, author"
+
+// InsertRecord creates a record with its first version, it fails with ErrConflict if the
+// record already exists.
+func InsertRecord(ctx context.Context, db *sql.DB, record entity.Record) error {
+	defer observeQuery("insert_record", time.Now())
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	// a racing create of the same id fails on the primary key
+	if _, err := tx.ExecContext(ctx, "INSERT INTO record_heads (tenant, id, version) VALUES ($1, $2, $3)", tenant.ID(ctx), record.ID, record.Version); err != nil {
+		return translateError(err)
+	}
+	if err := insertVersion(ctx, tx, record); err != nil {
+		return err
+	}
+	return translateError(tx.Commit())
+}
+
+// UpdateRecord appends the version apply derives from the latest one and the number of
+// versions kept. The record is locked from reading the latest version to writing the next,
+// so concurrent updates wait for each other instead of conflicting. An error of apply is
+// returned as is and nothing is written.
+func UpdateRecord(ctx context.Context, db *sql.DB, id int, apply func(latest entity.Record, versions int) (entity.Record, error)) (*entity.Record, error) {
+	defer observeQuery("update_record", time.Now())
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer tx.Rollback()
+
+	var version int
+	err = tx.QueryRowContext(ctx, "SELECT version FROM record_heads WHERE tenant = $1 AND id = $2 FOR UPDATE", tenant.ID(ctx), id).Scan(&version)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	latest, err := scanRecord(tx.QueryRowContext(ctx, "SELECT "+RECORD_COLUMNS+" FROM records WHERE tenant = $1 AND id = $2 AND version = $3", tenant.ID(ctx), id, version))
+	if err != nil {
+		return nil, err
+	}
+	var versions int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM records WHERE tenant = $1 AND id = $2", tenant.ID(ctx), id).Scan(&versions)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	next, err := apply(*latest, versions)
+	if err != nil {
+		return nil, err
+	}
+	if err := insertVersion(ctx, tx, next); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE record_heads SET version = $3 WHERE tenant = $1 AND id = $2", tenant.ID(ctx), id, next.Version); err != nil {
+		return nil, translateError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, translateError(err)
+	}
+	return &next, nil
+}
+
+// insertVersion writes one version of a record, recorded now
+func insertVersion(ctx context.Context, tx *sql.Tx, record entity.Record) error {
+	logging.FromContext(ctx).Debug("insert record", "record_id", record.ID, "version", record.Version, "data", logging.Payload(record.Data))
+	// lib/pq sends []byte as bytea, jsonb is sent as text
+	dataJson, err := json.Marshal(record.Data)
+	if err != nil {
+		return translateError(err)
+	}
+	updatesJson, err := json.Marshal(record.Updates)
+	if err != nil {
+		return translateError(err)
+	}
+	// occurred_at is only known when the client reported it
+	var occurredAt interface{}
+	if record.OccurredAt != "" {
+		at, err := time.Parse(time.RFC3339, record.OccurredAt)
+		if err != nil {
+			return translateError(err)
+		}
+		occurredAt = at.UTC().Truncate(time.Second)
+	}
+	var author interface{}
+	if record.Author != "" {
+		author = record.Author
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO records (tenant, id, version, data, updates, occurred_at, author) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		tenant.ID(ctx), record.ID, record.Version, string(dataJson), string(updatesJson), occurredAt, author)
+	return translateError(err)
+}
+
+func GetLatestRecord(ctx context.Context, db *sql.DB, id int) (*entity.Record, error) {
+	defer observeQuery("get_latest_record", time.Now())
+	row := db.QueryRowContext(ctx, "SELECT "+RECORD_COLUMNS+" FROM records WHERE tenant = $1 AND id = $2 ORDER BY version DESC LIMIT 1", tenant.ID(ctx), id)
+	return scanRecord(row)
+}
+
+func GetRecordAtVersion(ctx context.Context, db *sql.DB, id int, version int) (*entity.Record, error) {
+	defer observeQuery("get_record_at_version", time.Now())
+	row := db.QueryRowContext(ctx, "SELECT "+RECORD_COLUMNS+" FROM records WHERE tenant = $1 AND id = $2 AND version = $3", tenant.ID(ctx), id, version)
+	return scanRecord(row)
+}
+
+// GetRecordAtTime returns the version of the record that was in force at the given time,
+// i.e. the latest version recorded at or before it. Times are recorded to the second.
+func GetRecordAtTime(ctx context.Context, db *sql.DB, id int, at time.Time) (*entity.Record, error) {
+	defer observeQuery("get_record_at_time", time.Now())
+	row := db.QueryRowContext(ctx, "SELECT "+RECORD_COLUMNS+" FROM records WHERE tenant = $1 AND id = $2 AND timestamp <= $3 ORDER BY version DESC LIMIT 1",
+		tenant.ID(ctx), id, at.UTC().Truncate(time.Second))
+	return scanRecord(row)
+}
+
+// GetRecordHistory returns every version of the record, oldest first.
+func GetRecordHistory(ctx context.Context, db *sql.DB, id int) ([]*entity.Record, error) {
+	defer observeQuery("get_record_history", time.Now())
+	return queryRecords(ctx, db, "SELECT "+RECORD_COLUMNS+" FROM records WHERE tenant = $1 AND id = $2 ORDER BY version ASC", tenant.ID(ctx), id)
+}
+
+// GetLateVersions returns every version whose client-reported occurrence time precedes
+// the time it was recorded by more than the threshold, oldest first.
+func GetLateVersions(ctx context.Context, db *sql.DB, threshold time.Duration) ([]*entity.Record, error) {
+	defer observeQuery("get_late_versions", time.Now())
+	return queryRecords(ctx, db, `SELECT `+RECORD_COLUMNS+` FROM records
+		WHERE tenant = $1 AND occurred_at IS NOT NULL AND EXTRACT(EPOCH FROM timestamp - occurred_at) > $2
+		ORDER BY timestamp ASC, id ASC, version ASC`, tenant.ID(ctx), threshold.Seconds())
+}
+
+func GetRecordVersions(ctx context.Context, db *sql.DB, id int) ([]int, error) {
+	defer observeQuery("get_record_versions", time.Now())
+	return queryInts(ctx, db, "SELECT version FROM records WHERE tenant = $1 AND id = $2 ORDER BY version DESC", tenant.ID(ctx), id)
+}
+
+// GetRecordIDs returns the ids of every record.
+func GetRecordIDs(ctx context.Context, db *sql.DB) ([]int, error) {
+	defer observeQuery("get_record_ids", time.Now())
+	return queryInts(ctx, db, "SELECT DISTINCT id FROM records WHERE tenant = $1 ORDER BY id ASC", tenant.ID(ctx))
+}
+
+// DeleteRecordVersions removes versions of a record in a single transaction, like
+// database.DeleteRecordVersions does, holding the lock of the record's writers.
+func DeleteRecordVersions(ctx context.Context, db *sql.DB, id int, versions []int) error {
+	defer observeQuery("delete_record_versions", time.Now())
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	var head int
+	err = tx.QueryRowContext(ctx, "SELECT version FROM record_heads WHERE tenant = $1 AND id = $2 FOR UPDATE", tenant.ID(ctx), id).Scan(&head)
+	if err != nil {
+		return translateError(err)
+	}
+	for _, version := range versions {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM records WHERE tenant = $1 AND id = $2 AND version = $3", tenant.ID(ctx), id, version); err != nil {
+			return translateError(err)
+		}
+	}
+
+	remaining, err := queryRecords(ctx, tx, "SELECT "+RECORD_COLUMNS+" FROM records WHERE tenant = $1 AND id = $2 ORDER BY version ASC", tenant.ID(ctx), id)
+	if err != nil {
+		return err
+	}
+	for i, record := range remaining {
+		if i == 0 || !removedBetween(versions, remaining[i-1].Version, record.Version) {
+			continue
+		}
+		updates := map[string]string{}
+		for key, value := range record.Data {
+			if previous, ok := remaining[i-1].Data[key]; !ok || previous != value {
+				updates[key] = value
+			}
+		}
+		updatesJson, err := json.Marshal(updates)
+		if err != nil {
+			return translateError(err)
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE records SET updates = $4 WHERE tenant = $1 AND id = $2 AND version = $3", tenant.ID(ctx), id, record.Version, string(updatesJson)); err != nil {
+			return translateError(err)
+		}
+	}
+
+	// the next update follows the latest version left
+	if len(remaining) == 0 {
+		_, err = tx.ExecContext(ctx, "DELETE FROM record_heads WHERE tenant = $1 AND id = $2", tenant.ID(ctx), id)
+	} else if latest := remaining[len(remaining)-1].Version; latest != head {
+		_, err = tx.ExecContext(ctx, "UPDATE record_heads SET version = $3 WHERE tenant = $1 AND id = $2", tenant.ID(ctx), id, latest)
+	}
+	if err != nil {
+		return translateError(err)
+	}
+	return translateError(tx.Commit())
+}
+
+// removedBetween reports whether any of the removed versions lies strictly between from and to
+func removedBetween(removed []int, from int, to int) bool {
+	for _, version := range removed {
+		if version > from && version < to {
+			return true
+		}
+	}
+	return false
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryRecords runs a query selecting RECORD_COLUMNS
+func queryRecords(ctx context.Context, q querier, query string, args ...interface{}) ([]*entity.Record, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	records := make([]*entity.Record, 0)
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, translateError(rows.Err())
+}
+
+// queryInts runs a query selecting a single integer column
+func queryInts(ctx context.Context, q querier, query string, args ...interface{}) ([]int, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	values := make([]int, 0)
+	for rows.Next() {
+		var value int
+		if err := rows.Scan(&value); err != nil {
+			return nil, translateError(err)
+		}
+		values = append(values, value)
+	}
+	return values, translateError(rows.Err())
+}
+
+// scanRecord parses a RECORD_COLUMNS row into a record
+func scanRecord(row rowScanner) (*entity.Record, error) {
+	record := entity.Record{}
+
+	var timestamp time.Time
+	var rawData string
+	var rawUpdates string
+	var occurredAt sql.NullTime
+	var author sql.NullString
+	err := row.Scan(&record.ID, &timestamp, &rawData, &rawUpdates, &record.Version, &occurredAt, &author)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	record.Timestamp = formatTime(timestamp)
+	if occurredAt.Valid {
+		record.OccurredAt = formatTime(occurredAt.Time)
+	}
+	record.Author = author.String
+
+	record.Data = map[string]string{}
+	if err := json.Unmarshal([]byte(rawData), &record.Data); err != nil {
+		return &record, fmt.Errorf("%w: data of record %d version %d: %w", database.ErrCorrupt, record.ID, record.Version, err)
+	}
+	record.Updates = map[string]string{}
+	if err := json.Unmarshal([]byte(rawUpdates), &record.Updates); err != nil {
+		return &record, fmt.Errorf("%w: updates of record %d version %d: %w", database.ErrCorrupt, record.ID, record.Version, err)
+	}
+	return &record, nil
+}
@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/tenant"
+)
+
+// GetTenants returns every tenant that has records. Unlike the other queries it is not
+// scoped to the tenant of ctx, it is how background jobs find the tenants to work on.
+func GetTenants(ctx context.Context, db *sql.DB) ([]string, error) {
+	defer observeQuery("get_tenants", time.Now())
+	rows, err := db.QueryContext(ctx, "SELECT DISTINCT tenant FROM records ORDER BY tenant ASC")
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	tenants := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, translateError(err)
+		}
+		tenants = append(tenants, id)
+	}
+	return tenants, translateError(rows.Err())
+}
+
+// GetTenantRecords returns every version of every record of the tenant, by id then oldest first.
+func GetTenantRecords(ctx context.Context, db *sql.DB) ([]*entity.Record, error) {
+	defer observeQuery("get_tenant_records", time.Now())
+	return queryRecords(ctx, db, "SELECT "+RECORD_COLUMNS+" FROM records WHERE tenant = $1 ORDER BY id ASC, version ASC", tenant.ID(ctx))
+}
+
+// DeleteTenant removes every record of the tenant, in a single transaction, and returns how
+// many versions were removed. It fails with ErrRecordOnHold, removing nothing, while any
+// record of the tenant is held. The history of its legal holds is kept for the audit trail.
+func DeleteTenant(ctx context.Context, db *sql.DB) (int, error) {
+	defer observeQuery("delete_tenant", time.Now())
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	defer tx.Rollback()
+
+	var held int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM legal_holds WHERE tenant = $1", tenant.ID(ctx)).Scan(&held); err != nil {
+		return 0, translateError(err)
+	}
+	if held > 0 {
+		return 0, database.ErrRecordOnHold
+	}
+	// writers of the tenant wait for the deletion, and find nothing to update after it
+	if _, err := tx.ExecContext(ctx, "DELETE FROM record_heads WHERE tenant = $1", tenant.ID(ctx)); err != nil {
+		return 0, translateError(err)
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM records WHERE tenant = $1", tenant.ID(ctx))
+	if err != nil {
+		return 0, translateError(err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return int(deleted), translateError(tx.Commit())
+}
@@ -0,0 +1,154 @@
+package rating
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/service"
+)
+
+var ErrWindowInvalid = errors.New("window must end after it starts")
+
+// premiums are annual, charges for a period are prorated over a 365 day year
+const YEAR time.Duration = 365 * 24 * time.Hour
+
+// Quote is the premium of a single version of a record.
+type Quote struct {
+	ID      int     `json:"id"`
+	Version int     `json:"version"`
+	Premium Premium `json:"premium"`
+}
+
+// Period is the part of a window during which a single version of a record was in force.
+type Period struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Version int       `json:"version"`
+	Premium Premium   `json:"premium"`
+	// Charge is the premium prorated to the length of the period.
+	Charge float64 `json:"charge"`
+}
+
+// Exposure is the rate of a record across a window, split by the versions in force.
+type Exposure struct {
+	ID      int       `json:"id"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Periods []Period  `json:"periods"`
+	Total   float64   `json:"total"`
+}
+
+// AtVersion rates a record as it was at the given version.
+func AtVersion(ctx context.Context, records service.RecordService, rater Rater, id int, version int) (Quote, error) {
+	record, err := records.GetRecordAtVersion(ctx, id, version)
+	if err != nil {
+		return Quote{}, err
+	}
+	return quote(rater, record)
+}
+
+// AtTime rates the version of a record that was in force at the given time.
+func AtTime(ctx context.Context, records service.RecordService, rater Rater, id int, at time.Time) (Quote, error) {
+	record, err := records.GetRecordAtTime(ctx, id, at)
+	if err != nil {
+		return Quote{}, err
+	}
+	return quote(rater, record)
+}
+
+// OverWindow rates a record across [from, to), with one period per version in force during the window.
+//
+// Time before the record's first version is not exposed, so it is not part of any period.
+func OverWindow(ctx context.Context, records service.RecordService, rater Rater, id int, from time.Time, to time.Time) (Exposure, error) {
+	if !to.After(from) {
+		return Exposure{}, ErrWindowInvalid
+	}
+
+	history, err := records.GetRecordHistory(ctx, id)
+	if err != nil {
+		return Exposure{}, err
+	}
+	if len(history) == 0 {
+		return Exposure{}, service.ErrRecordDoesNotExist
+	}
+
+	spans, err := exposurePeriods(history, from, to)
+	if err != nil {
+		return Exposure{}, err
+	}
+
+	exposure := Exposure{
+		ID:      id,
+		From:    from,
+		To:      to,
+		Periods: make([]Period, 0, len(spans)),
+	}
+	for _, span := range spans {
+		premium, err := rater.Rate(span.record)
+		if err != nil {
+			return Exposure{}, err
+		}
+		charge := roundCents(premium.Total * float64(span.to.Sub(span.from)) / float64(YEAR))
+		exposure.Periods = append(exposure.Periods, Period{
+			From:    span.from,
+			To:      span.to,
+			Version: span.record.Version,
+			Premium: premium,
+			Charge:  charge,
+		})
+		exposure.Total += charge
+	}
+	exposure.Total = roundCents(exposure.Total)
+
+	return exposure, nil
+}
+
+func quote(rater Rater, record entity.Record) (Quote, error) {
+	premium, err := rater.Rate(record)
+	if err != nil {
+		return Quote{}, err
+	}
+	return Quote{
+		ID:      record.ID,
+		Version: record.Version,
+		Premium: premium,
+	}, nil
+}
+
+type span struct {
+	from   time.Time
+	to     time.Time
+	record entity.Record
+}
+
+// exposurePeriods splits [from, to) by the versions of history (oldest first) in force during it.
+// Versions superseded within the same instant they were recorded cover no time and are skipped.
+func exposurePeriods(history []entity.Record, from time.Time, to time.Time) ([]span, error) {
+	spans := make([]span, 0)
+	for i, record := range history {
+		start, err := record.RecordedAt()
+		if err != nil {
+			return nil, err
+		}
+		end := to
+		if i+1 < len(history) {
+			next, err := history[i+1].RecordedAt()
+			if err != nil {
+				return nil, err
+			}
+			if next.Before(end) {
+				end = next
+			}
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if !end.After(start) {
+			continue
+		}
+		spans = append(spans, span{from: start, to: end, record: record})
+	}
+	return spans, nil
+}
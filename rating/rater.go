@@ -0,0 +1,101 @@
+package rating
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/chauvm/timetravel/entity"
+)
+
+var ErrRaterDoesNotExist = errors.New("rater with that name does not exist")
+var ErrRaterAlreadyExists = errors.New("rater already exists")
+var ErrInvalidField = errors.New("record field cannot be rated")
+
+// Line is a single component of a premium, e.g. the base rate or a surcharge.
+type Line struct {
+	Name   string  `json:"name"`
+	Amount float64 `json:"amount"`
+}
+
+// Premium is the annual premium of a record together with the lines it adds up from.
+type Premium struct {
+	Total     float64 `json:"total"`
+	Breakdown []Line  `json:"breakdown"`
+}
+
+// Rater computes the premium of a record from its fields.
+//
+// A Rater must only depend on the record it is given, so that the premium
+// of any version can be recomputed from history.
+type Rater interface {
+	Rate(record entity.Record) (Premium, error)
+}
+
+// Registry holds the raters available by name.
+type Registry struct {
+	mu     sync.RWMutex
+	raters map[string]Rater
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		raters: map[string]Rater{},
+	}
+}
+
+// Register makes a rater available under the given name.
+//
+// Register will fail if a rater with that name already exists.
+func (r *Registry) Register(name string, rater Rater) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.raters[name]; ok {
+		return ErrRaterAlreadyExists
+	}
+	r.raters[name] = rater
+	return nil
+}
+
+// Get returns the rater registered under the given name.
+func (r *Registry) Get(name string) (Rater, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rater, ok := r.raters[name]
+	if !ok {
+		return nil, ErrRaterDoesNotExist
+	}
+	return rater, nil
+}
+
+// Names returns the names of all registered raters, sorted.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.raters))
+	for name := range r.raters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultRegistry is the registry used by the api, it ships with the example rater.
+var DefaultRegistry = NewRegistry()
+
+// DEFAULT_RATER is the name the example rater is registered under.
+const DEFAULT_RATER string = "example"
+
+func init() {
+	if err := DefaultRegistry.Register(DEFAULT_RATER, ExampleRater); err != nil {
+		panic(err)
+	}
+}
+
+// Register makes a rater available in the default registry.
+func Register(name string, rater Rater) error {
+	return DefaultRegistry.Register(name, rater)
+}
@@ -0,0 +1,99 @@
+package rating
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/chauvm/timetravel/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableRater(t *testing.T) {
+	record := entity.Record{
+		ID:   1,
+		Data: map[string]string{"state": "CA", "industry": "retail", "employees": "3", "name": "acme"},
+	}
+
+	premium, err := ExampleRater.Rate(record)
+	assert.NoError(t, err)
+	assert.Equal(t, 1310.0, premium.Total)
+	assert.Equal(t, []Line{
+		{Name: "base", Amount: 500},
+		{Name: "industry=retail", Amount: 200},
+		{Name: "state=CA", Amount: 250},
+		{Name: "employees x 3", Amount: 360},
+	}, premium.Breakdown)
+
+	// values missing from the table add nothing
+	premium, err = ExampleRater.Rate(entity.Record{ID: 1, Data: map[string]string{"state": "WA"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 500.0, premium.Total)
+
+	// per unit fields must be numbers
+	_, err = ExampleRater.Rate(entity.Record{ID: 1, Data: map[string]string{"employees": "many"}})
+	assert.True(t, errors.Is(err, ErrInvalidField))
+	for _, value := range []string{"NaN", "Inf", "-Inf", "1e309", "1e307"} {
+		_, err = ExampleRater.Rate(entity.Record{ID: 1, Data: map[string]string{"employees": value}})
+		assert.True(t, errors.Is(err, ErrInvalidField), value)
+	}
+
+	// finite lines whose sum overflows
+	large := &TableRater{
+		Base:    math.MaxFloat64 * 0.75,
+		Table:   map[string]map[string]float64{"state": {"CA": math.MaxFloat64 * 0.75}},
+		PerUnit: map[string]float64{"employees": 1, "contractors": 1},
+	}
+	_, err = large.Rate(entity.Record{ID: 1, Data: map[string]string{"state": "CA"}})
+	assert.True(t, errors.Is(err, ErrInvalidField))
+	large.Base = 0
+	_, err = large.Rate(entity.Record{ID: 1, Data: map[string]string{"employees": "1e306", "contractors": "1e306"}})
+	assert.True(t, errors.Is(err, ErrInvalidField))
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	assert.NoError(t, registry.Register("table", ExampleRater))
+	assert.Equal(t, ErrRaterAlreadyExists, registry.Register("table", ExampleRater))
+
+	rater, err := registry.Get("table")
+	assert.NoError(t, err)
+	assert.Equal(t, ExampleRater, rater)
+
+	_, err = registry.Get("missing")
+	assert.Equal(t, ErrRaterDoesNotExist, err)
+
+	assert.Equal(t, []string{DEFAULT_RATER}, DefaultRegistry.Names())
+}
+
+func TestExposurePeriods(t *testing.T) {
+	history := []entity.Record{
+		{ID: 1, Version: 1, Timestamp: "2024-01-01T00:00:00Z"},
+		{ID: 1, Version: 2, Timestamp: "2024-03-01T00:00:00Z"},
+		// superseded in the same instant, never in force
+		{ID: 1, Version: 3, Timestamp: "2024-07-01T00:00:00Z"},
+		{ID: 1, Version: 4, Timestamp: "2024-07-01T00:00:00Z"},
+	}
+	from := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	spans, err := exposurePeriods(history, from, to)
+	assert.NoError(t, err)
+	assert.Len(t, spans, 3)
+
+	assert.Equal(t, 1, spans[0].record.Version)
+	assert.Equal(t, from, spans[0].from)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), spans[0].to)
+
+	assert.Equal(t, 2, spans[1].record.Version)
+	assert.Equal(t, time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), spans[1].to)
+
+	assert.Equal(t, 4, spans[2].record.Version)
+	assert.Equal(t, to, spans[2].to)
+
+	// a window before the record existed has no exposure
+	spans, err = exposurePeriods(history, from.AddDate(-1, 0, 0), from.AddDate(-1, 1, 0))
+	assert.NoError(t, err)
+	assert.Len(t, spans, 0)
+}
@@ -0,0 +1,104 @@
+package rating
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/chauvm/timetravel/entity"
+)
+
+// TableRater is a table-driven rater: a base premium, plus a flat amount for
+// matching field values, plus an amount per unit of numeric fields.
+type TableRater struct {
+	Base float64
+	// Table maps a field, then one of its values, to the amount added when the record matches it.
+	Table map[string]map[string]float64
+	// PerUnit maps a numeric field to the amount added per unit of its value.
+	PerUnit map[string]float64
+}
+
+// ExampleRater rates a small business policy from its state, industry and head count.
+var ExampleRater = &TableRater{
+	Base: 500,
+	Table: map[string]map[string]float64{
+		"state": {
+			"CA": 250,
+			"NY": 300,
+			"TX": 150,
+		},
+		"industry": {
+			"construction": 900,
+			"retail":       200,
+			"software":     100,
+		},
+	},
+	PerUnit: map[string]float64{
+		"employees": 120,
+	},
+}
+
+func (t *TableRater) Rate(record entity.Record) (Premium, error) {
+	premium := Premium{
+		Breakdown: []Line{{Name: "base", Amount: t.Base}},
+	}
+
+	// iterate in a fixed order so the breakdown is stable across calls
+	tableFields := make([]string, 0, len(t.Table))
+	for field := range t.Table {
+		tableFields = append(tableFields, field)
+	}
+	sort.Strings(tableFields)
+
+	for _, field := range tableFields {
+		value, ok := record.Data[field]
+		if !ok {
+			continue
+		}
+		if amount, ok := t.Table[field][value]; ok {
+			premium.Breakdown = append(premium.Breakdown, Line{
+				Name:   fmt.Sprintf("%s=%s", field, value),
+				Amount: amount,
+			})
+		}
+	}
+
+	unitFields := make([]string, 0, len(t.PerUnit))
+	for field := range t.PerUnit {
+		unitFields = append(unitFields, field)
+	}
+	sort.Strings(unitFields)
+
+	for _, field := range unitFields {
+		value, ok := record.Data[field]
+		if !ok {
+			continue
+		}
+		// ParseFloat accepts NaN, Inf and numbers too large to rate, which cannot be encoded
+		units, err := strconv.ParseFloat(value, 64)
+		amount := roundCents(units * t.PerUnit[field])
+		if err != nil || units < 0 || math.IsNaN(units) || math.IsInf(units, 0) || math.IsInf(amount, 0) {
+			return Premium{}, fmt.Errorf("%w: %q must be a non-negative number, got %q", ErrInvalidField, field, value)
+		}
+		premium.Breakdown = append(premium.Breakdown, Line{
+			Name:   fmt.Sprintf("%s x %s", field, value),
+			Amount: amount,
+		})
+	}
+
+	for _, line := range premium.Breakdown {
+		premium.Total += line.Amount
+	}
+	premium.Total = roundCents(premium.Total)
+	// lines that are each finite can still add up to more than a float64 holds
+	if math.IsInf(premium.Total, 0) {
+		return Premium{}, fmt.Errorf("%w: the premium is too large to rate", ErrInvalidField)
+	}
+
+	return premium, nil
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
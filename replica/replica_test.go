@@ -0,0 +1,233 @@
+package replica
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+// clock is the time of a replicator under test, it only moves when told to
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+// setUp opens a database in WAL mode and a replicator of it to a directory
+func setUp(t *testing.T) (*sql.DB, *Replicator, *clock, string) {
+	dir := t.TempDir()
+	options := database.DefaultOptions()
+	options.File = filepath.Join(dir, "rainbow.db")
+	options.JournalMode = "WAL"
+	db, err := database.CreateConnection(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	replicator, err := Open(options, filepath.Join(dir, "replica"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { replicator.Close() })
+	c := &clock{now: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	replicator.now = c.Now
+	return db, replicator, c, filepath.Join(dir, "replica")
+}
+
+// restoreRecord restores the replica at the time and reads the record from it
+func restoreRecord(t *testing.T, dir string, at time.Time, id int) (Point, *entity.Record, error) {
+	target := filepath.Join(t.TempDir(), "restored.db")
+	point, err := Restore(dir, target, at)
+	if err != nil {
+		return point, nil, err
+	}
+	_, err = database.VerifyDatabase(context.Background(), target)
+	assert.NoError(t, err)
+
+	options := database.DefaultOptions()
+	options.File = target
+	db, err := database.CreateConnection(options)
+	assert.NoError(t, err)
+	defer db.Close()
+	record, err := database.GetLatestRecord(context.Background(), db, id)
+	return point, record, err
+}
+
+func insert(t *testing.T, db *sql.DB, id int, version int, value string) {
+	_, err := database.InsertRecord(context.Background(), db, entity.Record{ID: id, Version: version, Data: map[string]string{"hello": value}})
+	assert.NoError(t, err)
+}
+
+func TestOpenRequiresWAL(t *testing.T) {
+	options := database.DefaultOptions()
+	options.File = filepath.Join(t.TempDir(), "rainbow.db")
+	_, err := Open(options, t.TempDir(), 0)
+	assert.True(t, errors.Is(err, ErrNotWAL))
+}
+
+func TestPointInTimeRestore(t *testing.T) {
+	ctx := context.Background()
+	db, replicator, c, dir := setUp(t)
+
+	_, err := Restore(dir, filepath.Join(t.TempDir(), "restored.db"), c.Now())
+	assert.True(t, errors.Is(err, ErrNoGeneration))
+
+	insert(t, db, 1, 1, "world")
+	assert.NoError(t, replicator.Sync(ctx))
+	started := c.Now()
+	insert(t, db, 1, 2, "again")
+	c.Advance(time.Second)
+	assert.NoError(t, replicator.Sync(ctx))
+	second := c.Now()
+	insert(t, db, 1, 3, "and again")
+	c.Advance(time.Second)
+	assert.NoError(t, replicator.Sync(ctx))
+	// nothing to ship
+	c.Advance(time.Second)
+	assert.NoError(t, replicator.Sync(ctx))
+
+	generations, err := Generations(dir)
+	assert.NoError(t, err)
+	assert.Len(t, generations, 1)
+	assert.Len(t, generations[0].Segments, 2)
+	assert.Equal(t, second.Add(time.Second), generations[0].End())
+
+	// the snapshot taken when the generation started
+	point, record, err := restoreRecord(t, dir, started, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, Point{Generation: generations[0].Name, Segments: 0, At: started}, point)
+	assert.Equal(t, 1, record.Version)
+
+	point, record, err = restoreRecord(t, dir, second.Add(500*time.Millisecond), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, point.Segments)
+	assert.Equal(t, 2, record.Version)
+
+	point, record, err = restoreRecord(t, dir, c.Now(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, point.Segments)
+	assert.Equal(t, "and again", record.Data["hello"])
+}
+
+func TestCheckpointsKeepTheGeneration(t *testing.T) {
+	ctx := context.Background()
+	db, replicator, c, dir := setUp(t)
+	replicator.checkpointFrames = 1
+
+	assert.NoError(t, replicator.Sync(ctx))
+	for version := 1; version <= 5; version++ {
+		insert(t, db, 1, version, "v")
+		c.Advance(time.Second)
+		// ships and checkpoints, the next write restarts the log
+		assert.NoError(t, replicator.Sync(ctx))
+	}
+
+	generations, err := Generations(dir)
+	assert.NoError(t, err)
+	assert.Len(t, generations, 1)
+	_, record, err := restoreRecord(t, dir, c.Now(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, record.Version)
+}
+
+func TestLostFramesStartANewGeneration(t *testing.T) {
+	ctx := context.Background()
+	db, replicator, c, dir := setUp(t)
+
+	assert.NoError(t, replicator.Sync(ctx))
+	insert(t, db, 1, 1, "world")
+	c.Advance(time.Second)
+	assert.NoError(t, replicator.Sync(ctx))
+
+	// something else checkpoints and restarts the log behind the replicator's back
+	replicator.release()
+	insert(t, db, 1, 2, "again")
+	result, err := database.Checkpoint(ctx, db, "TRUNCATE")
+	assert.NoError(t, err)
+	assert.False(t, result.Busy)
+	insert(t, db, 1, 3, "and again")
+	c.Advance(time.Second)
+	assert.NoError(t, replicator.Sync(ctx))
+
+	generations, err := Generations(dir)
+	assert.NoError(t, err)
+	assert.Len(t, generations, 2)
+	_, record, err := restoreRecord(t, dir, c.Now(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, record.Version)
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	db, replicator, c, dir := setUp(t)
+	replicator.checkpointFrames = 20
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for id := 1; id <= 200; id++ {
+			insert(t, db, id, 1, "v")
+		}
+	}()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for syncing := true; syncing; {
+		select {
+		case <-done:
+			syncing = false
+		default:
+		}
+		c.Advance(time.Millisecond)
+		assert.NoError(t, replicator.Sync(ctx))
+	}
+
+	_, record, err := restoreRecord(t, dir, c.Now(), 200)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, record.Version)
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	db, replicator, c, dir := setUp(t)
+	replicator.retention = time.Hour
+
+	assert.NoError(t, replicator.Sync(ctx))
+	for i := 0; i < 3; i++ {
+		c.Advance(time.Hour)
+		assert.NoError(t, replicator.startGeneration(ctx))
+	}
+	insert(t, db, 1, 1, "world")
+	c.Advance(time.Minute)
+	assert.NoError(t, replicator.Sync(ctx))
+
+	// the generation that was current an hour ago is kept
+	generations, err := Generations(dir)
+	assert.NoError(t, err)
+	assert.Len(t, generations, 2)
+	assert.Equal(t, c.Now().Add(-time.Hour-time.Minute), generations[0].Start)
+}
@@ -0,0 +1,377 @@
+// Package replica continuously copies a SQLite database in WAL mode to a directory, and
+// rebuilds the database as it was at a point in time from that copy.
+//
+// The directory holds generations, each one a snapshot of the database file followed by
+// segments: the frames of the write-ahead log committed since, in the order they were
+// written. A new generation starts whenever the replicator cannot tell it shipped every
+// frame, when it starts and when something else restarted the write-ahead log.
+//
+//	DIR/generations/20240102T030405.000Z/snapshot.db
+//	DIR/generations/20240102T030405.000Z/wal/00000001-20240102T030406.000Z.wal
+package replica
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/metrics"
+)
+
+// CHECKPOINT_FRAMES is the size of the write-ahead log, in frames, at which the replicator
+// copies it back into the database file, as SQLite's automatic checkpoint would
+const CHECKPOINT_FRAMES = 1000
+
+// TIME_FORMAT is the layout of the times in the names of generations and segments, UTC
+const TIME_FORMAT = database.BACKUP_TIME_FORMAT
+
+const GENERATIONS_DIR = "generations"
+const SNAPSHOT_FILE = "snapshot.db"
+const SEGMENTS_DIR = "wal"
+
+var ErrNotWAL = errors.New("replication requires the WAL journal mode")
+var errWALRestarted = errors.New("write-ahead log was restarted before all of it was shipped")
+
+// Replicator ships the write-ahead log of a database to a directory.
+//
+// While it runs it keeps a read transaction open, so no frame is copied into the database
+// file, and the log restarted, before the replicator has shipped it.
+type Replicator struct {
+	file      string
+	dir       string
+	retention time.Duration
+	db        *sql.DB
+	// snapshot is the read transaction held between syncs
+	snapshot *sql.Tx
+	now      func() time.Time
+	// checkpointFrames is CHECKPOINT_FRAMES but in tests
+	checkpointFrames int
+
+	// position in the current generation, "" before the first sync
+	generation string
+	pageSize   int
+	segment    int
+	// header is nil until the generation's write-ahead log has one
+	header   *walHeader
+	offset   int64
+	checksum [2]uint32
+	frames   int
+	// checkpointed is the frames of the log a checkpoint of the replicator copied back,
+	// while the log may be restarted by the next write; -1 otherwise
+	checkpointed int
+
+	mu       sync.Mutex
+	lastSync time.Time
+}
+
+// Open connects the replicator to the database, which must be in WAL mode, replicas are
+// written to dir. Generations older than retention are removed, none if it is 0.
+func Open(options database.Options, dir string, retention time.Duration) (*Replicator, error) {
+	if options.JournalMode != "WAL" {
+		return nil, fmt.Errorf("%w, the journal mode is %s", ErrNotWAL, options.JournalMode)
+	}
+	db, err := database.CreateConnection(options)
+	if err != nil {
+		return nil, err
+	}
+	// the read transaction and the checkpoints must share a connection
+	db.SetMaxOpenConns(1)
+	return &Replicator{
+		file:             options.File,
+		dir:              dir,
+		retention:        retention,
+		db:               db,
+		now:              time.Now,
+		checkpointFrames: CHECKPOINT_FRAMES,
+		checkpointed:     -1,
+		lastSync:         time.Now(),
+	}, nil
+}
+
+// Run syncs every interval until ctx is done, then syncs one last time.
+func (r *Replicator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Sync(ctx); err != nil {
+			log.Printf("replica: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := r.Sync(context.Background()); err != nil {
+				log.Printf("replica: %v", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync ships the transactions committed since the last sync, starting a new generation
+// when needed, and removes the generations past retention.
+func (r *Replicator) Sync(ctx context.Context) error {
+	err := r.sync(ctx)
+	if errors.Is(err, errWALRestarted) {
+		log.Printf("replica: %v, starting a new generation", err)
+		err = r.startGeneration(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.lastSync = r.now()
+	r.mu.Unlock()
+	return r.prune()
+}
+
+func (r *Replicator) sync(ctx context.Context) error {
+	if r.generation == "" {
+		return r.startGeneration(ctx)
+	}
+	if err := r.ship(); err != nil {
+		return err
+	}
+	if r.frames >= r.checkpointFrames {
+		return r.checkpoint(ctx)
+	}
+	return nil
+}
+
+// Lag is the time since the last successful sync.
+func (r *Replicator) Lag() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.now().Sub(r.lastSync)
+}
+
+// RegisterMetrics adds the lag of the replica to the registry.
+func (r *Replicator) RegisterMetrics(registry *metrics.Registry) {
+	registry.NewGaugeFunc("timetravel_replica_lag_seconds", "Seconds since the write-ahead log was last shipped to the replica.", func() float64 {
+		return r.Lag().Seconds()
+	})
+}
+
+// Close releases the read transaction and closes the replicator's connection, call it
+// before the database is checkpointed for good.
+func (r *Replicator) Close() error {
+	r.release()
+	return r.db.Close()
+}
+
+// hold starts the read transaction kept between syncs
+func (r *Replicator) hold() error {
+	// it outlives any one sync, it is only ended by release
+	snapshot, err := database.HoldSnapshot(context.Background(), r.db)
+	if err != nil {
+		return err
+	}
+	r.snapshot = snapshot
+	return nil
+}
+
+func (r *Replicator) release() {
+	if r.snapshot != nil {
+		r.snapshot.Rollback()
+		r.snapshot = nil
+	}
+}
+
+// startGeneration copies the database file, every frame of the write-ahead log after it
+// is shipped with the generation
+func (r *Replicator) startGeneration(ctx context.Context) error {
+	r.release()
+	r.generation = ""
+
+	// best effort, an empty log saves shipping frames already in the database file
+	if _, err := database.Checkpoint(ctx, r.db, "TRUNCATE"); err != nil {
+		return err
+	}
+	if err := r.hold(); err != nil {
+		return err
+	}
+
+	// a checkpoint may copy frames into the file while it is read, those frames are
+	// shipped after it and restored over whatever was read
+	name := r.now().UTC().Format(TIME_FORMAT)
+	dir := filepath.Join(r.dir, GENERATIONS_DIR, name)
+	if err := os.MkdirAll(filepath.Join(dir, SEGMENTS_DIR), 0o755); err != nil {
+		return err
+	}
+	content, err := os.ReadFile(r.file)
+	if err != nil {
+		return err
+	}
+	pageSize, err := databasePageSize(content)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(dir, SNAPSHOT_FILE), content); err != nil {
+		return err
+	}
+
+	r.generation = name
+	r.pageSize = pageSize
+	r.segment = 0
+	r.header = nil
+	r.offset = 0
+	r.frames = 0
+	r.checkpointed = -1
+	log.Printf("replica: started generation %s", name)
+	return r.ship()
+}
+
+// ship writes the frames committed since the last ship to a new segment
+func (r *Replicator) ship() error {
+	wal, err := os.Open(r.file + "-wal")
+	if errors.Is(err, os.ErrNotExist) {
+		if r.header == nil {
+			return nil
+		}
+		return errWALRestarted
+	}
+	if err != nil {
+		return err
+	}
+	defer wal.Close()
+
+	buf := make([]byte, WAL_HEADER_SIZE)
+	if _, err := wal.ReadAt(buf, 0); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	header, err := parseWALHeader(buf)
+	if err != nil {
+		// nothing was written since the generation started, or the header is being written
+		if r.header == nil {
+			return nil
+		}
+		return fmt.Errorf("%w: %w", errWALRestarted, err)
+	}
+
+	switch {
+	case r.header == nil:
+		r.adopt(header)
+	case header.salt1 != r.header.salt1 || header.salt2 != r.header.salt2:
+		// only the replicator's own checkpoint restarts the log without losing frames,
+		// the next log then increments the first salt
+		if r.checkpointed < 0 || r.frames != r.checkpointed || header.salt1 != r.header.salt1+1 {
+			return errWALRestarted
+		}
+		r.adopt(header)
+	}
+	if header.pageSize != r.pageSize {
+		return fmt.Errorf("%w: page size changed from %d to %d", errWALRestarted, r.pageSize, header.pageSize)
+	}
+
+	committed, err := readCommittedFrames(wal, header, r.offset, r.checksum)
+	if err != nil {
+		return err
+	}
+	if committed.frames == 0 {
+		return nil
+	}
+	name := fmt.Sprintf("%08d-%s.wal", r.segment+1, r.now().UTC().Format(TIME_FORMAT))
+	if err := writeFile(filepath.Join(r.dir, GENERATIONS_DIR, r.generation, SEGMENTS_DIR, name), committed.data); err != nil {
+		return err
+	}
+	r.segment++
+	r.offset = committed.offset
+	r.checksum = committed.checksum
+	r.frames += committed.frames
+	// the log grew past the checkpoint instead of restarting
+	r.checkpointed = -1
+	return nil
+}
+
+// adopt continues the generation with a new write-ahead log
+func (r *Replicator) adopt(header walHeader) {
+	r.header = &header
+	r.offset = WAL_HEADER_SIZE
+	r.checksum = header.checksum
+	r.frames = 0
+	r.checkpointed = -1
+}
+
+// checkpoint copies the log back into the database file, so the next write restarts it
+func (r *Replicator) checkpoint(ctx context.Context) error {
+	r.release()
+	result, err := database.Checkpoint(ctx, r.db, "RESTART")
+	if err != nil {
+		return err
+	}
+	if !result.Busy {
+		r.checkpointed = result.Frames
+	}
+	// the frames committed since the last ship are still in the log until it restarts
+	if err := r.ship(); err != nil {
+		return err
+	}
+	if !result.Busy && r.frames < result.Frames {
+		return errWALRestarted
+	}
+	return r.hold()
+}
+
+// prune removes the generations superseded before the retention period
+func (r *Replicator) prune() error {
+	if r.retention == 0 {
+		return nil
+	}
+	generations, err := Generations(r.dir)
+	if err != nil {
+		return err
+	}
+	cutoff := r.now().Add(-r.retention)
+	for i := 0; i+1 < len(generations); i++ {
+		if generations[i+1].Start.After(cutoff) || generations[i].Name == r.generation {
+			break
+		}
+		if err := os.RemoveAll(filepath.Join(r.dir, GENERATIONS_DIR, generations[i].Name)); err != nil {
+			return err
+		}
+		log.Printf("replica: removed generation %s", generations[i].Name)
+	}
+	return nil
+}
+
+// databasePageSize reads the page size from the header of a database file
+func databasePageSize(content []byte) (int, error) {
+	if len(content) < 100 {
+		return 0, fmt.Errorf("database file of %d bytes has no header", len(content))
+	}
+	return pageSize(uint32(content[16])<<8 | uint32(content[17])), nil
+}
+
+// writeFile writes the file whole or not at all
+func writeFile(path string, content []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
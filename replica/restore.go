@@ -0,0 +1,167 @@
+package replica
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNoGeneration = errors.New("no generation of the replica covers the time")
+
+// Generation is a snapshot of the database and the segments shipped after it.
+type Generation struct {
+	Name  string
+	Start time.Time
+	// Segments are ordered as they were shipped
+	Segments []Segment
+}
+
+// Segment is a file of whole transactions of the write-ahead log.
+type Segment struct {
+	File      string
+	Index     int
+	ShippedAt time.Time
+}
+
+// End is when the last segment of the generation was shipped, its start without any.
+func (g Generation) End() time.Time {
+	if len(g.Segments) == 0 {
+		return g.Start
+	}
+	return g.Segments[len(g.Segments)-1].ShippedAt
+}
+
+// Generations lists the complete generations of the replica in dir, oldest first.
+func Generations(dir string) ([]Generation, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, GENERATIONS_DIR))
+	if errors.Is(err, os.ErrNotExist) {
+		return []Generation{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	generations := make([]Generation, 0, len(entries))
+	for _, entry := range entries {
+		start, err := time.Parse(TIME_FORMAT, entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		// a generation whose snapshot is still being written
+		if _, err := os.Stat(filepath.Join(dir, GENERATIONS_DIR, entry.Name(), SNAPSHOT_FILE)); err != nil {
+			continue
+		}
+		segments, err := segments(filepath.Join(dir, GENERATIONS_DIR, entry.Name(), SEGMENTS_DIR))
+		if err != nil {
+			return nil, err
+		}
+		generations = append(generations, Generation{Name: entry.Name(), Start: start, Segments: segments})
+	}
+	sort.Slice(generations, func(i, j int) bool { return generations[i].Start.Before(generations[j].Start) })
+	return generations, nil
+}
+
+// segments lists the segments in dir, NNNNNNNN-TIME.wal, in order
+func segments(dir string) ([]Segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]Segment, 0, len(entries))
+	for _, entry := range entries {
+		index, shippedAt, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".wal"), "-")
+		if !ok || !strings.HasSuffix(entry.Name(), ".wal") {
+			continue
+		}
+		segment := Segment{File: filepath.Join(dir, entry.Name())}
+		if segment.Index, err = strconv.Atoi(index); err != nil {
+			continue
+		}
+		if segment.ShippedAt, err = time.Parse(TIME_FORMAT, shippedAt); err != nil {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Index < segments[j].Index })
+	return segments, nil
+}
+
+// Point is the state of the database a restore rebuilt.
+type Point struct {
+	Generation string
+	Segments   int
+	// At is when the last segment applied was shipped, or the generation started
+	At time.Time
+}
+
+// Restore rebuilds the database as it was at the given time into the file target, which
+// is replaced. It starts from the snapshot of the last generation started by then and
+// applies the segments shipped by then, so it is as recent as the last sync before it.
+func Restore(dir string, target string, at time.Time) (Point, error) {
+	generations, err := Generations(dir)
+	if err != nil {
+		return Point{}, err
+	}
+	var generation *Generation
+	for i := range generations {
+		if !generations[i].Start.After(at) {
+			generation = &generations[i]
+		}
+	}
+	if generation == nil {
+		return Point{}, fmt.Errorf("%w: none in %s started by %s", ErrNoGeneration, dir, at.UTC().Format(time.RFC3339))
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, GENERATIONS_DIR, generation.Name, SNAPSHOT_FILE))
+	if err != nil {
+		return Point{}, err
+	}
+	pageSize, err := databasePageSize(content)
+	if err != nil {
+		return Point{}, err
+	}
+	if err := os.WriteFile(target, content, 0o600); err != nil {
+		return Point{}, err
+	}
+	db, err := os.OpenFile(target, os.O_RDWR, 0)
+	if err != nil {
+		return Point{}, err
+	}
+	defer db.Close()
+
+	point := Point{Generation: generation.Name, At: generation.Start}
+	var pages int64
+	for _, segment := range generation.Segments {
+		if segment.ShippedAt.After(at) {
+			break
+		}
+		frames, err := os.ReadFile(segment.File)
+		if err != nil {
+			return Point{}, err
+		}
+		committed, err := applyFrames(db, pageSize, frames)
+		if err != nil {
+			return Point{}, fmt.Errorf("segment %s: %w", segment.File, err)
+		}
+		if committed > 0 {
+			pages = committed
+		}
+		point.Segments++
+		point.At = segment.ShippedAt
+	}
+	// the database may have shrunk since the snapshot
+	if pages > 0 {
+		if err := db.Truncate(pages * int64(pageSize)); err != nil {
+			return Point{}, err
+		}
+	}
+	if err := db.Sync(); err != nil {
+		return Point{}, err
+	}
+	return point, db.Close()
+}
@@ -0,0 +1,146 @@
+package replica
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// layout of SQLite's write-ahead log, see https://www.sqlite.org/fileformat.html#the_write_ahead_log
+const (
+	WAL_HEADER_SIZE       = 32
+	WAL_FRAME_HEADER_SIZE = 24
+	WAL_MAGIC_LITTLE      = 0x377f0682
+	WAL_MAGIC_BIG         = 0x377f0683
+	WAL_VERSION           = 3007000
+)
+
+var errWALHeaderInvalid = errors.New("write-ahead log header is invalid")
+
+// walHeader is the header of a write-ahead log. The salts change every time the log is
+// restarted, frames carry them so frames left over from an earlier log are recognized.
+type walHeader struct {
+	bigEndian bool
+	pageSize  int
+	salt1     uint32
+	salt2     uint32
+	checksum  [2]uint32
+}
+
+// parseWALHeader parses and checks the first WAL_HEADER_SIZE bytes of a write-ahead log.
+func parseWALHeader(b []byte) (walHeader, error) {
+	if len(b) < WAL_HEADER_SIZE {
+		return walHeader{}, fmt.Errorf("%w: %d bytes", errWALHeaderInvalid, len(b))
+	}
+	magic := binary.BigEndian.Uint32(b[0:])
+	if magic != WAL_MAGIC_LITTLE && magic != WAL_MAGIC_BIG {
+		return walHeader{}, fmt.Errorf("%w: magic %#x", errWALHeaderInvalid, magic)
+	}
+	if version := binary.BigEndian.Uint32(b[4:]); version != WAL_VERSION {
+		return walHeader{}, fmt.Errorf("%w: version %d", errWALHeaderInvalid, version)
+	}
+	header := walHeader{
+		bigEndian: magic == WAL_MAGIC_BIG,
+		pageSize:  pageSize(binary.BigEndian.Uint32(b[8:])),
+		salt1:     binary.BigEndian.Uint32(b[16:]),
+		salt2:     binary.BigEndian.Uint32(b[20:]),
+		checksum:  [2]uint32{binary.BigEndian.Uint32(b[24:]), binary.BigEndian.Uint32(b[28:])},
+	}
+	if walChecksum(header.bigEndian, b[:24], [2]uint32{}) != header.checksum {
+		return walHeader{}, fmt.Errorf("%w: checksum mismatch", errWALHeaderInvalid)
+	}
+	return header, nil
+}
+
+// pageSize decodes a page size field, 1 stands for 65536
+func pageSize(field uint32) int {
+	if field == 1 {
+		return 65536
+	}
+	return int(field)
+}
+
+// walChecksum continues the cumulative checksum s over b, whose length is a multiple of 8.
+func walChecksum(bigEndian bool, b []byte, s [2]uint32) [2]uint32 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	for i := 0; i+8 <= len(b); i += 8 {
+		s[0] += order.Uint32(b[i:]) + s[1]
+		s[1] += order.Uint32(b[i+4:]) + s[0]
+	}
+	return s
+}
+
+// walFrames are the frames of a write-ahead log read after a position, up to the last
+// frame that commits a transaction.
+type walFrames struct {
+	data     []byte
+	frames   int
+	offset   int64
+	checksum [2]uint32
+}
+
+// readCommittedFrames reads the frames of the log after offset, whose cumulative checksum
+// so far is checksum. It stops at the first frame that is torn, left over from an earlier
+// log or not followed by a commit, only whole transactions are returned.
+func readCommittedFrames(r io.ReaderAt, header walHeader, offset int64, checksum [2]uint32) (walFrames, error) {
+	committed := walFrames{data: []byte{}, offset: offset, checksum: checksum}
+	frameSize := int64(WAL_FRAME_HEADER_SIZE + header.pageSize)
+	pending := []byte{}
+	frames := 0
+
+	for {
+		frame := make([]byte, frameSize)
+		_, err := r.ReadAt(frame, offset)
+		if errors.Is(err, io.EOF) {
+			return committed, nil
+		}
+		if err != nil {
+			return committed, err
+		}
+		if binary.BigEndian.Uint32(frame[8:]) != header.salt1 || binary.BigEndian.Uint32(frame[12:]) != header.salt2 {
+			return committed, nil
+		}
+		checksum = walChecksum(header.bigEndian, frame[:8], checksum)
+		checksum = walChecksum(header.bigEndian, frame[WAL_FRAME_HEADER_SIZE:], checksum)
+		if checksum != [2]uint32{binary.BigEndian.Uint32(frame[16:]), binary.BigEndian.Uint32(frame[20:])} {
+			return committed, nil
+		}
+
+		pending = append(pending, frame...)
+		frames++
+		offset += frameSize
+		// the size of the database after the commit, 0 on frames within a transaction
+		if binary.BigEndian.Uint32(frame[4:]) != 0 {
+			committed.data = append(committed.data, pending...)
+			committed.frames += frames
+			committed.offset = offset
+			committed.checksum = checksum
+			pending = pending[:0]
+			frames = 0
+		}
+	}
+}
+
+// applyFrames writes the pages of the frames to the database file and returns the size
+// of the database, in pages, after the last commit among them, 0 if there is none.
+func applyFrames(db io.WriterAt, pageSize int, frames []byte) (int64, error) {
+	frameSize := WAL_FRAME_HEADER_SIZE + pageSize
+	if len(frames)%frameSize != 0 {
+		return 0, fmt.Errorf("segment of %d bytes is not made of %d byte frames", len(frames), frameSize)
+	}
+	var pages int64
+	for i := 0; i < len(frames); i += frameSize {
+		page := int64(binary.BigEndian.Uint32(frames[i:]))
+		if _, err := db.WriteAt(frames[i+WAL_FRAME_HEADER_SIZE:i+frameSize], (page-1)*int64(pageSize)); err != nil {
+			return 0, err
+		}
+		if commit := binary.BigEndian.Uint32(frames[i+4:]); commit != 0 {
+			pages = int64(commit)
+		}
+	}
+	return pages, nil
+}
@@ -0,0 +1,152 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/service"
+	"github.com/chauvm/timetravel/tenant"
+)
+
+// Store is the history a compactor enforces policies on. Every method but GetTenants
+// works on the tenant of the context, see the tenant package.
+type Store interface {
+	// GetTenants lists every tenant that has records.
+	GetTenants(ctx context.Context) ([]string, error)
+	GetRecordIDs(ctx context.Context) ([]int, error)
+	GetRecordHistory(ctx context.Context, id int) ([]entity.Record, error)
+	// IsOnLegalHold reports whether the record's history must be left untouched.
+	IsOnLegalHold(ctx context.Context, id int) (bool, error)
+	// DeleteRecordVersions removes versions of a record, keeping the versions left consistent.
+	DeleteRecordVersions(ctx context.Context, id int, versions []int) error
+}
+
+// Removal lists the versions of one record a compaction removed, or would remove.
+type Removal struct {
+	ID       int   `json:"id"`
+	Versions []int `json:"versions"`
+}
+
+// Report is the outcome of a compaction.
+type Report struct {
+	DryRun  bool      `json:"dry_run"`
+	Removed []Removal `json:"removed"`
+	Total   int       `json:"total"`
+	// Held lists the records skipped because they are under legal hold.
+	Held []int `json:"held"`
+}
+
+// Compactor removes the versions that retention policies no longer keep.
+type Compactor struct {
+	store    Store
+	policies []Policy
+	now      func() time.Time
+}
+
+func NewCompactor(store Store, policies []Policy) *Compactor {
+	return &Compactor{
+		store:    store,
+		policies: policies,
+		now:      time.Now,
+	}
+}
+
+// Compact enforces the policies on every record of the tenant of ctx once. With dryRun
+// it only reports what would be removed.
+func (c *Compactor) Compact(ctx context.Context, dryRun bool) (Report, error) {
+	report := Report{
+		DryRun:  dryRun,
+		Removed: make([]Removal, 0),
+		Held:    make([]int, 0),
+	}
+	if len(c.policies) == 0 {
+		return report, nil
+	}
+
+	ids, err := c.store.GetRecordIDs(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	now := c.now()
+	for _, id := range ids {
+		policy, ok := policyFor(ctx, c.policies, id)
+		if !ok {
+			continue
+		}
+
+		history, err := c.store.GetRecordHistory(ctx, id)
+		if err != nil {
+			return report, err
+		}
+		versions, err := Plan(policy, history, now)
+		if err != nil {
+			return report, err
+		}
+		if len(versions) == 0 {
+			continue
+		}
+
+		held, err := c.store.IsOnLegalHold(ctx, id)
+		if err != nil {
+			return report, err
+		}
+		if held {
+			report.Held = append(report.Held, id)
+			continue
+		}
+
+		if !dryRun {
+			err := c.store.DeleteRecordVersions(ctx, id, versions)
+			if errors.Is(err, service.ErrRecordOnHold) { // placed since we checked
+				report.Held = append(report.Held, id)
+				continue
+			}
+			if err != nil {
+				return report, err
+			}
+		}
+		report.Removed = append(report.Removed, Removal{ID: id, Versions: versions})
+		report.Total += len(versions)
+	}
+	return report, nil
+}
+
+// Run compacts every tenant every interval until the context is done.
+func (c *Compactor) Run(ctx context.Context, interval time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.compactTenants(ctx, dryRun)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// compactTenants compacts each tenant in turn, logging the outcome, a failing tenant
+// does not stop the others from being compacted
+func (c *Compactor) compactTenants(ctx context.Context, dryRun bool) {
+	tenants, err := c.store.GetTenants(ctx)
+	if err != nil {
+		log.Printf("compactor: %v", err)
+		return
+	}
+	for _, id := range tenants {
+		report, err := c.Compact(tenant.WithID(ctx, id), dryRun)
+		if err != nil {
+			log.Printf("compactor: tenant %s: %v", id, err)
+		} else if dryRun {
+			log.Printf("compactor: tenant %s: would remove %d versions: %v", id, report.Total, report.Removed)
+		} else {
+			log.Printf("compactor: tenant %s: removed %d versions: %v", id, report.Total, report.Removed)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/tenant"
+)
+
+var ErrPolicyInvalid = errors.New("invalid retention policy")
+
+// Thinning decides which versions survive once they are older than a policy's KeepFor.
+type Thinning string
+
+const (
+	// THIN_MONTH_END keeps the state each record was in at the end of every month.
+	THIN_MONTH_END Thinning = "month_end"
+	// THIN_ALL keeps none of the old versions, only the current state of the record.
+	THIN_ALL Thinning = "all"
+)
+
+// Policy keeps every version of a record for KeepFor, then thins older versions out.
+// The latest version of a record is always kept.
+type Policy struct {
+	// Tenant the policy applies to. A policy without a tenant applies to every tenant, but
+	// its RecordIDs, if any, are records of tenant.DEFAULT: ids are only unique per tenant.
+	Tenant string `json:"tenant,omitempty"`
+	// RecordIDs the policy applies to, a policy without ids applies to every other record.
+	RecordIDs []int    `json:"record_ids,omitempty"`
+	KeepFor   Age      `json:"keep_for"`
+	Then      Thinning `json:"then"`
+}
+
+// recordsTenant is the tenant of the RecordIDs of the policy
+func (p Policy) recordsTenant() string {
+	if p.Tenant == "" {
+		return tenant.DEFAULT
+	}
+	return p.Tenant
+}
+
+// Age is a calendar length of time such as 7y, 18m or 90d, or a combination like 1y6m.
+type Age struct {
+	Years  int
+	Months int
+	Days   int
+}
+
+var agePattern = regexp.MustCompile(`^(?:(\d+)y)?(?:(\d+)m)?(?:(\d+)d)?$`)
+
+func ParseAge(value string) (Age, error) {
+	matches := agePattern.FindStringSubmatch(value)
+	if value == "" || matches == nil {
+		return Age{}, fmt.Errorf("%w: age %q must look like 7y, 18m, 90d or 1y6m", ErrPolicyInvalid, value)
+	}
+	parts := make([]int, 3)
+	for i, match := range matches[1:] {
+		if match != "" {
+			parts[i], _ = strconv.Atoi(match)
+		}
+	}
+	return Age{Years: parts[0], Months: parts[1], Days: parts[2]}, nil
+}
+
+// Before returns the time that is this age before t.
+func (a Age) Before(t time.Time) time.Time {
+	return t.AddDate(-a.Years, -a.Months, -a.Days)
+}
+
+func (a Age) String() string {
+	value := ""
+	if a.Years > 0 {
+		value += fmt.Sprintf("%dy", a.Years)
+	}
+	if a.Months > 0 {
+		value += fmt.Sprintf("%dm", a.Months)
+	}
+	if a.Days > 0 || value == "" {
+		value += fmt.Sprintf("%dd", a.Days)
+	}
+	return value
+}
+
+func (a Age) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+func (a *Age) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	age, err := ParseAge(value)
+	if err != nil {
+		return err
+	}
+	*a = age
+	return nil
+}
+
+// ValidatePolicies checks every policy is well formed, that no record is covered by
+// more than one policy and that there is at most one policy for all other records, for
+// every tenant and for each tenant.
+func ValidatePolicies(policies []Policy) error {
+	global := map[string]bool{}
+	covered := map[string]map[int]bool{}
+	for _, policy := range policies {
+		if policy.Then != THIN_MONTH_END && policy.Then != THIN_ALL {
+			return fmt.Errorf("%w: then must be %q or %q, got %q", ErrPolicyInvalid, THIN_MONTH_END, THIN_ALL, policy.Then)
+		}
+		if policy.Tenant != "" && !tenant.Valid(policy.Tenant) {
+			return fmt.Errorf("%w: %w, got %q", ErrPolicyInvalid, tenant.ErrTenantInvalid, policy.Tenant)
+		}
+		if len(policy.RecordIDs) == 0 {
+			if global[policy.Tenant] {
+				return fmt.Errorf("%w: only one policy may apply to all records of a tenant, or of every tenant", ErrPolicyInvalid)
+			}
+			global[policy.Tenant] = true
+		}
+		tenantID := policy.recordsTenant()
+		if covered[tenantID] == nil {
+			covered[tenantID] = map[int]bool{}
+		}
+		for _, id := range policy.RecordIDs {
+			if id <= 0 {
+				return fmt.Errorf("%w: record id %d must be a positive number", ErrPolicyInvalid, id)
+			}
+			if covered[tenantID][id] {
+				return fmt.Errorf("%w: record %d of tenant %s is covered by more than one policy", ErrPolicyInvalid, id, tenantID)
+			}
+			covered[tenantID][id] = true
+		}
+	}
+	return nil
+}
+
+// LoadPolicies reads a JSON list of policies from a file, e.g.
+//
+//	[{"keep_for": "7y", "then": "month_end"}, {"tenant": "acme", "record_ids": [42], "keep_for": "90d", "then": "all"}]
+func LoadPolicies(path string) ([]Policy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policies []Policy
+	if err := json.Unmarshal(content, &policies); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := ValidatePolicies(policies); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return policies, nil
+}
+
+// policyFor returns the policy covering the record of the tenant of the context: a policy
+// naming the record wins over the policy for all records of its tenant, which wins over the
+// policy for all records. Records no policy covers keep every version.
+func policyFor(ctx context.Context, policies []Policy, id int) (Policy, bool) {
+	tenantID := tenant.ID(ctx)
+	var global, tenantWide *Policy
+	for i, policy := range policies {
+		if policy.Tenant != "" && policy.Tenant != tenantID {
+			continue
+		}
+		if len(policy.RecordIDs) == 0 {
+			if policy.Tenant == "" {
+				global = &policies[i]
+			} else {
+				tenantWide = &policies[i]
+			}
+			continue
+		}
+		if policy.recordsTenant() != tenantID {
+			continue
+		}
+		for _, recordID := range policy.RecordIDs {
+			if recordID == id {
+				return policy, true
+			}
+		}
+	}
+	if tenantWide != nil {
+		return *tenantWide, true
+	}
+	if global != nil {
+		return *global, true
+	}
+	return Policy{}, false
+}
+
+// Plan returns the versions of the history (oldest first) that the policy removes at now.
+func Plan(policy Policy, history []entity.Record, now time.Time) ([]int, error) {
+	cutoff := policy.KeepFor.Before(now)
+
+	// the last version recorded before the cutoff in each month is the month-end state
+	monthEnd := map[string]int{}
+	old := make([]entity.Record, 0)
+	for i, record := range history {
+		if i == len(history)-1 {
+			break // the current state is always kept
+		}
+		recordedAt, err := record.RecordedAt()
+		if err != nil {
+			return nil, err
+		}
+		if !recordedAt.Before(cutoff) {
+			break
+		}
+		old = append(old, record)
+		monthEnd[recordedAt.UTC().Format("2006-01")] = record.Version
+	}
+
+	remove := make([]int, 0)
+	for _, record := range old {
+		if policy.Then == THIN_MONTH_END {
+			recordedAt, _ := record.RecordedAt()
+			if monthEnd[recordedAt.UTC().Format("2006-01")] == record.Version {
+				continue
+			}
+		}
+		remove = append(remove, record.Version)
+	}
+	return remove, nil
+}
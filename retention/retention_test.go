@@ -0,0 +1,161 @@
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/tenant"
+	"github.com/stretchr/testify/assert"
+)
+
+// history of record 1: two versions in January 2010, one in February 2010, one recent
+func history() []entity.Record {
+	return []entity.Record{
+		{ID: 1, Version: 1, Timestamp: "2010-01-05T00:00:00Z"},
+		{ID: 1, Version: 2, Timestamp: "2010-01-20T00:00:00Z"},
+		{ID: 1, Version: 3, Timestamp: "2010-02-10T00:00:00Z"},
+		{ID: 1, Version: 4, Timestamp: "2024-06-01T00:00:00Z"},
+	}
+}
+
+var now = time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+
+func TestParseAge(t *testing.T) {
+	age, err := ParseAge("7y")
+	assert.NoError(t, err)
+	assert.Equal(t, Age{Years: 7}, age)
+
+	age, err = ParseAge("1y6m")
+	assert.NoError(t, err)
+	assert.Equal(t, Age{Years: 1, Months: 6}, age)
+	assert.Equal(t, "1y6m", age.String())
+
+	for _, value := range []string{"", "7", "7w", "y"} {
+		_, err = ParseAge(value)
+		assert.True(t, errors.Is(err, ErrPolicyInvalid), value)
+	}
+}
+
+func TestValidatePolicies(t *testing.T) {
+	var policies []Policy
+	err := json.Unmarshal([]byte(`[{"keep_for":"7y","then":"month_end"},{"record_ids":[1],"keep_for":"90d","then":"all"}]`), &policies)
+	assert.NoError(t, err)
+	assert.NoError(t, ValidatePolicies(policies))
+
+	// one record, two policies
+	policies = append(policies, Policy{RecordIDs: []int{1}, Then: THIN_ALL})
+	assert.True(t, errors.Is(ValidatePolicies(policies), ErrPolicyInvalid))
+
+	// two policies for all records
+	assert.True(t, errors.Is(ValidatePolicies([]Policy{{Then: THIN_ALL}, {Then: THIN_ALL}}), ErrPolicyInvalid))
+
+	assert.True(t, errors.Is(ValidatePolicies([]Policy{{Then: "weekly"}}), ErrPolicyInvalid))
+
+	// ids and policies for all records are per tenant, ids without a tenant are of the default one
+	assert.NoError(t, ValidatePolicies([]Policy{
+		{Then: THIN_ALL},
+		{Tenant: "acme", Then: THIN_ALL},
+		{RecordIDs: []int{1}, Then: THIN_ALL},
+		{Tenant: "acme", RecordIDs: []int{1}, Then: THIN_ALL},
+	}))
+	assert.True(t, errors.Is(ValidatePolicies([]Policy{{Tenant: "acme", Then: THIN_ALL}, {Tenant: "acme", Then: THIN_ALL}}), ErrPolicyInvalid))
+	assert.True(t, errors.Is(ValidatePolicies([]Policy{{RecordIDs: []int{1}, Then: THIN_ALL}, {Tenant: tenant.DEFAULT, RecordIDs: []int{1}, Then: THIN_ALL}}), ErrPolicyInvalid))
+	assert.True(t, errors.Is(ValidatePolicies([]Policy{{Tenant: "acme/..", Then: THIN_ALL}}), ErrPolicyInvalid))
+}
+
+func TestPlan(t *testing.T) {
+	// month-end states are kept, the rest of the old versions go
+	versions, err := Plan(Policy{KeepFor: Age{Years: 7}, Then: THIN_MONTH_END}, history(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, versions)
+
+	versions, err = Plan(Policy{KeepFor: Age{Years: 7}, Then: THIN_ALL}, history(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, versions)
+
+	// nothing is old enough
+	versions, err = Plan(Policy{KeepFor: Age{Years: 20}, Then: THIN_ALL}, history(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{}, versions)
+
+	// the current state is kept however old it is
+	versions, err = Plan(Policy{KeepFor: Age{Days: 1}, Then: THIN_ALL}, history(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, versions)
+}
+
+type fakeStore struct {
+	records map[int][]entity.Record
+	held    map[int]bool
+	deleted map[int][]int
+}
+
+func (s *fakeStore) GetTenants(ctx context.Context) ([]string, error) {
+	return []string{tenant.DEFAULT}, nil
+}
+
+func (s *fakeStore) GetRecordIDs(ctx context.Context) ([]int, error) {
+	return []int{1, 2}, nil
+}
+
+func (s *fakeStore) GetRecordHistory(ctx context.Context, id int) ([]entity.Record, error) {
+	return s.records[id], nil
+}
+
+func (s *fakeStore) IsOnLegalHold(ctx context.Context, id int) (bool, error) {
+	return s.held[id], nil
+}
+
+func (s *fakeStore) DeleteRecordVersions(ctx context.Context, id int, versions []int) error {
+	s.deleted[id] = versions
+	return nil
+}
+
+func TestCompact(t *testing.T) {
+	store := &fakeStore{
+		records: map[int][]entity.Record{1: history(), 2: history()},
+		deleted: map[int][]int{},
+	}
+	compactor := NewCompactor(store, []Policy{
+		{KeepFor: Age{Years: 7}, Then: THIN_MONTH_END},
+		{RecordIDs: []int{2}, KeepFor: Age{Years: 7}, Then: THIN_ALL},
+	})
+	compactor.now = func() time.Time { return now }
+
+	// a dry run removes nothing
+	report, err := compactor.Compact(context.Background(), true)
+	assert.NoError(t, err)
+	assert.Equal(t, Report{DryRun: true, Removed: []Removal{{ID: 1, Versions: []int{1}}, {ID: 2, Versions: []int{1, 2, 3}}}, Total: 4, Held: []int{}}, report)
+	assert.Empty(t, store.deleted)
+
+	report, err = compactor.Compact(context.Background(), false)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, report.Total)
+	assert.Equal(t, map[int][]int{1: {1}, 2: {1, 2, 3}}, store.deleted)
+
+	// held records are left untouched
+	store.held = map[int]bool{1: true}
+	store.deleted = map[int][]int{}
+	report, err = compactor.Compact(context.Background(), false)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, report.Held)
+	assert.Equal(t, map[int][]int{2: {1, 2, 3}}, store.deleted)
+
+	// the policy of record 2 of the default tenant does not cover record 2 of another tenant,
+	// a policy for all records of that tenant does
+	store.held = map[int]bool{}
+	store.deleted = map[int][]int{}
+	acme := tenant.WithID(context.Background(), "acme")
+	report, err = compactor.Compact(acme, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []Removal{{ID: 1, Versions: []int{1}}, {ID: 2, Versions: []int{1}}}, report.Removed)
+
+	compactor.policies = append(compactor.policies, Policy{Tenant: "acme", KeepFor: Age{Years: 7}, Then: THIN_ALL})
+	report, err = compactor.Compact(acme, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []Removal{{ID: 1, Versions: []int{1, 2, 3}}, {ID: 2, Versions: []int{1, 2, 3}}}, report.Removed)
+}
@@ -1,17 +1,56 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/chauvm/timetravel/api"
+	"github.com/chauvm/timetravel/auth"
+	"github.com/chauvm/timetravel/config"
 	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/grpcapi"
+	"github.com/chauvm/timetravel/health"
+	"github.com/chauvm/timetravel/logging"
+	"github.com/chauvm/timetravel/metrics"
+	"github.com/chauvm/timetravel/postgres"
+	"github.com/chauvm/timetravel/replica"
+	"github.com/chauvm/timetravel/retention"
 	"github.com/chauvm/timetravel/service"
 	"github.com/gorilla/mux"
 )
 
+// exit codes of the server process
+const (
+	EXIT_OK = iota
+	// the server could not start or stopped serving on its own
+	EXIT_SERVER_ERROR
+	// the configuration is invalid
+	EXIT_CONFIG_ERROR
+	// in-flight requests did not finish within the shutdown timeout
+	EXIT_SHUTDOWN_TIMEOUT
+	// the database could not be checkpointed or closed cleanly
+	EXIT_DATABASE_ERROR
+)
+
+// recordStore is everything the server uses of a record service, PersistentRecordService,
+// PostgresRecordService and InMemoryRecordService all provide it.
+type recordStore interface {
+	service.RecordService
+	service.LegalHoldService
+	service.TenantService
+	retention.Store
+}
+
 // logError logs all non-nil errors
 func logError(err error) {
 	if err != nil {
@@ -20,49 +59,251 @@ func logError(err error) {
 }
 
 func main() {
-	log.Println("main: starting server...")
+	os.Exit(run())
+}
+
+func run() int {
+	cfg, err := config.Load(os.Args[1:], config.ENV_FILES...)
+	if errors.Is(err, flag.ErrHelp) {
+		return EXIT_OK
+	}
+	if err != nil {
+		log.Println(err)
+		return EXIT_CONFIG_ERROR
+	}
+
+	// log.Printf goes through the default slog handler, at the info level
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.SlogLevel()})))
+	logging.SetLogPayloads(cfg.LogPayloads)
+
+	log.Println("main: starting server with config:")
+	logError(cfg.Print(log.Writer()))
 	router := mux.NewRouter()
+	router.Use(api.InstrumentRequests)
+	router.Path("/metrics").Handler(metrics.Default.Handler()).Methods("GET")
 
-	// apiV1 := api.NewAPI(&imMemoryService)
+	// stop on SIGINT or SIGTERM, a second signal kills the process right away
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	// apiV1.CreateRoutes(apiRoute)
+	var store recordStore
+	// authenticator stays nil when the records are kept in memory, there are no keys then
+	var authenticator *auth.Authenticator
+	// closeStore waits for the queries still running and closes the database
+	closeStore := func() error { return nil }
+	// replicator stays nil unless the SQLite write-ahead log is shipped to a replica
+	var replicator *replica.Replicator
+	// the versions per record, read on a timer rather than when scraped
+	var versionCounts *metrics.HistogramSnapshot
+	checks := []health.Check{}
+	// only SQLite is backed up online, the other stores are backed up with their own tools
+	var backups service.BackupService
+	switch cfg.Store {
+	case config.STORE_MEMORY:
+		log.Println("main: records are kept in memory, they are lost when the server stops")
+		store = service.NewInMemoryRecordService(cfg.Limits)
+	case config.STORE_POSTGRES:
+		log.Println("main: create postgres connection")
+		db, err := postgres.Open(cfg.Postgres)
+		if err != nil {
+			log.Println(err)
+			return EXIT_DATABASE_ERROR
+		}
+		log.Println("main: postgres connection created")
 
-	// v2
-	log.Println("main: create database connection")
-	db, err := database.CreateConnection()
-	log.Println("main: database connection created")
+		versionCounts = postgres.RegisterMetrics(metrics.Default, db)
+		postgresService := service.NewPostgresRecordService(db, cfg.Limits)
+		store = &postgresService
+		authenticator = auth.NewKeyStoreAuthenticator(postgres.NewKeyStore(db))
+		closeStore = func() error { return postgres.Close(db) }
+		checks = append(checks,
+			health.Check{Name: "database", Run: func(ctx context.Context) error { return postgres.Ping(ctx, db) }},
+			health.Check{Name: "migrations", Run: func(ctx context.Context) error { return postgres.CheckMigrations(ctx, db) }},
+		)
+	default:
+		log.Println("main: create database connection")
+		db, err := database.CreateConnection(cfg.Database)
+		log.Println("main: database connection created")
 
-	if err != nil {
-		log.Fatal(err)
+		if err != nil {
+			log.Println(err)
+			return EXIT_DATABASE_ERROR
+		}
+
+		versionCounts = database.RegisterMetrics(metrics.Default, db, cfg.Database.File)
+		persistentService := service.NewPersistentRecordService(db, cfg.Limits)
+		store = &persistentService
+		backups = service.NewPersistentBackupService(db, cfg.Database.File, cfg.BackupDir)
+		authenticator = auth.NewAuthenticator(db)
+		closeStore = func() error { return database.Close(db) }
+		checks = append(checks,
+			health.Check{Name: "database", Run: func(ctx context.Context) error { return database.Ping(ctx, db) }},
+			health.Check{Name: "migrations", Run: func(ctx context.Context) error { return database.CheckMigrations(ctx, db) }},
+			health.DiskHeadroom(filepath.Dir(cfg.Database.File), cfg.MinFreeDisk),
+		)
+
+		if cfg.ReplicaDir != "" {
+			replicator, err = replica.Open(cfg.Database, cfg.ReplicaDir, cfg.ReplicaRetention)
+			if err != nil {
+				log.Println(err)
+				logError(closeStore())
+				return EXIT_DATABASE_ERROR
+			}
+			replicator.RegisterMetrics(metrics.Default)
+			// the replicator's read transaction would keep the last checkpoint from completing
+			closeStore = func() error {
+				logError(replicator.Close())
+				return database.Close(db)
+			}
+			log.Printf("main: shipping the write-ahead log to %s every %s", cfg.ReplicaDir, cfg.ReplicaInterval)
+		}
+	}
+
+	// the configuration only enables authentication with a database to keep the keys in
+	if cfg.AuthEnabled {
+		router.Use(api.Authenticate(authenticator))
+	} else {
+		log.Println("main: authentication is disabled, anyone reaching the server can read and write every record")
+	}
+	// after Authenticate, a key bound to a tenant decides the tenant
+	router.Use(api.ResolveTenant)
+
+	// retention policies are optional, without them every version is kept
+	policies := []retention.Policy{}
+	if _, err := os.Stat(cfg.RetentionPoliciesFile); err == nil {
+		policies, err = retention.LoadPolicies(cfg.RetentionPoliciesFile)
+		if err != nil {
+			log.Println(err)
+			logError(closeStore())
+			return EXIT_CONFIG_ERROR
+		}
+		log.Printf("main: loaded %d retention policies", len(policies))
 	}
+	compactor := retention.NewCompactor(store, policies)
+	compactorDone := make(chan struct{})
+	go func() {
+		defer close(compactorDone)
+		if len(policies) > 0 {
+			compactor.Run(ctx, cfg.CompactionInterval, cfg.CompactionDryRun)
+		}
+	}()
+
+	versionCountsDone := make(chan struct{})
+	go func() {
+		defer close(versionCountsDone)
+		if versionCounts != nil {
+			versionCounts.Run(ctx, database.VERSION_COUNTS_INTERVAL)
+		}
+	}()
 
-	persistentService := service.NewPersistentRecordService(db)
+	// the replica is stopped after the last request, so every write it saw is shipped
+	replicaCtx, stopReplica := context.WithCancel(context.Background())
+	defer stopReplica()
+	replicaDone := make(chan struct{})
+	go func() {
+		defer close(replicaDone)
+		if replicator != nil {
+			replicator.Run(replicaCtx, cfg.ReplicaInterval)
+		}
+	}()
 
-	newAPI := api.NewAPI(&persistentService)
-	newAPIV2 := api.NewAPIV2(&persistentService)
+	newAPI := api.NewAPI(store)
+	newAPIV2 := api.NewAPIV2(store)
+	adminAPI := api.NewAdminAPI(compactor, store, store, backups)
+
+	checker := health.NewChecker(health.DEFAULT_TIMEOUT, checks...)
+	healthAPI := api.NewHealthAPI(checker)
 
 	apiRouteV1 := router.PathPrefix("/api/v1").Subrouter()
-	apiRouteV1.Path("/health").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		err := json.NewEncoder(w).Encode(map[string]bool{"ok": true})
-		logError(err)
-	})
 	apiRouteV2 := router.PathPrefix("/api/v2").Subrouter()
-	apiRouteV2.Path("/health").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		err := json.NewEncoder(w).Encode(map[string]bool{"ok": true})
-		logError(err)
-	})
+	healthAPI.CreateRoutes(apiRouteV1)
+	healthAPI.CreateRoutes(apiRouteV2)
 
 	newAPI.CreateRoutes(apiRouteV1)
 	newAPIV2.CreateRoutes(apiRouteV2)
+	adminAPI.CreateRoutes(apiRouteV2.PathPrefix("/admin").Subrouter())
 
-	address := "127.0.0.1:8000"
 	srv := &http.Server{
-		Handler:      router,
-		Addr:         address,
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+		// assigns request ids and logs every request, those no route matches too
+		Handler:      api.LogRequests(router),
+		Addr:         cfg.ListenAddress,
+		WriteTimeout: cfg.WriteTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+
+	serveErr := make(chan error, 2)
+	go func() {
+		log.Printf("listening on %s", cfg.ListenAddress)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	// the gRPC server shares the record service, and the api keys unless they are disabled
+	var grpcServer *grpcapi.Server
+	if cfg.GRPCListenAddress != "" {
+		grpcAuthenticator := authenticator
+		if !cfg.AuthEnabled {
+			grpcAuthenticator = nil
+		}
+		grpcServer = grpcapi.NewServer(store, grpcAuthenticator)
+		go func() {
+			listener, err := net.Listen("tcp", cfg.GRPCListenAddress)
+			if err != nil {
+				serveErr <- err
+				return
+			}
+			log.Printf("listening for gRPC on %s", cfg.GRPCListenAddress)
+			serveErr <- grpcServer.Serve(listener)
+		}()
+	}
+
+	code := EXIT_OK
+	select {
+	case err := <-serveErr:
+		log.Printf("main: server stopped: %v", err)
+		code = EXIT_SERVER_ERROR
+		stop()
+	case <-ctx.Done():
+		stop()
+		checker.SetShuttingDown()
+		// give load balancers time to see the server is not ready, a second signal kills it
+		if cfg.ReadinessDrain > 0 {
+			log.Printf("main: shutting down, reporting not ready for %s", cfg.ReadinessDrain)
+			time.Sleep(cfg.ReadinessDrain)
+		}
+		log.Printf("main: shutting down, waiting up to %s for in-flight requests", cfg.ShutdownTimeout)
+	}
+
+	// stop accepting connections and let the handlers in flight finish their writes
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("main: in-flight requests did not finish: %v", err)
+		if code == EXIT_OK {
+			code = EXIT_SHUTDOWN_TIMEOUT
+		}
+	}
+	if grpcServer != nil {
+		if err := grpcServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("main: in-flight gRPC calls did not finish: %v", err)
+			if code == EXIT_OK {
+				code = EXIT_SHUTDOWN_TIMEOUT
+			}
+		}
+	}
+	<-compactorDone
+	<-versionCountsDone
+	stopReplica()
+	<-replicaDone
+
+	// waits for the queries still running, then checkpoints and closes the database
+	if err := closeStore(); err != nil {
+		log.Printf("main: closing database: %v", err)
+		if code == EXIT_OK {
+			code = EXIT_DATABASE_ERROR
+		}
 	}
 
-	log.Printf("listening on %s", address)
-	log.Fatal(srv.ListenAndServe())
+	log.Printf("main: stopped with exit code %d", code)
+	return code
 }
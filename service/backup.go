@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+)
+
+// BackupService is implemented by storages that can be copied consistently while the
+// server keeps serving requests.
+type BackupService interface {
+
+	// Backup will write a copy of every tenant's records, legal holds and api keys to a new
+	// file, verify the copy and describe it.
+	Backup(ctx context.Context) (entity.Backup, error)
+}
+
+// PersistentBackupService backs up the SQLite database of PersistentRecordService to
+// timestamped files in a directory.
+type PersistentBackupService struct {
+	db   *sql.DB
+	file string
+	dir  string
+	// one backup at a time, each one reads the whole database
+	mu sync.Mutex
+}
+
+// NewPersistentBackupService backs up the database opened from file into dir.
+func NewPersistentBackupService(db *sql.DB, file string, dir string) *PersistentBackupService {
+	return &PersistentBackupService{db: db, file: file, dir: dir}
+}
+
+func (s *PersistentBackupService) Backup(ctx context.Context) (entity.Backup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	takenAt := time.Now()
+	file := database.BackupFile(s.dir, s.file, takenAt)
+	if err := database.Backup(ctx, s.db, file); err != nil {
+		return entity.Backup{}, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+	}
+	backup, err := database.VerifyDatabase(ctx, file)
+	if err != nil {
+		return entity.Backup{}, fmt.Errorf("%w: %w", ErrStorageCorrupt, err)
+	}
+	backup.TakenAt = takenAt.UTC().Format(time.RFC3339)
+	return backup, nil
+}
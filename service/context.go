@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/chauvm/timetravel/auth"
+)
+
+type contextKey int
+
+const (
+	occurredAtKey contextKey = iota
+)
+
+// WithOccurredAt attaches the client-reported time a change actually happened
+// to the context of a create or update.
+func WithOccurredAt(ctx context.Context, at time.Time) context.Context {
+	return context.WithValue(ctx, occurredAtKey, at)
+}
+
+// ParseOccurredAt parses a client-reported change time, either a full RFC 3339 timestamp
+// or a date such as 2024-03-01, which is taken as midnight UTC.
+func ParseOccurredAt(value string) (time.Time, error) {
+	occurredAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		occurredAt, err = time.Parse("2006-01-02", value)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if occurredAt.After(time.Now()) {
+		return time.Time{}, errors.New("occurred_at is in the future")
+	}
+	return occurredAt, nil
+}
+
+// occurredAt returns the client-reported time of the change in RFC 3339, or "" if none was reported.
+func occurredAt(ctx context.Context) string {
+	at, ok := ctx.Value(occurredAtKey).(time.Time)
+	if !ok {
+		return ""
+	}
+	return at.UTC().Format(time.RFC3339)
+}
+
+// author returns the name of the authenticated principal making the change, or "" if none.
+func author(ctx context.Context) string {
+	principal, ok := auth.PrincipalFrom(ctx)
+	if !ok {
+		return ""
+	}
+	return principal.Name
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/chauvm/timetravel/database"
+)
+
+var ErrRecordConflict = errors.New("record was changed by a concurrent update")
+var ErrUnavailable = errors.New("record storage is busy, try again")
+var ErrConstraintViolation = errors.New("record violates a storage constraint")
+var ErrStorageCorrupt = errors.New("record storage is corrupt")
+
+// translateError maps an error of the database package onto the errors of this package.
+// What a missing or an already existing row means depends on the operation, so the
+// caller picks the errors returned for those.
+func translateError(err error, notFound error, conflict error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, database.ErrNotFound):
+		return notFound
+	case errors.Is(err, database.ErrConflict):
+		return fmt.Errorf("%w: %w", conflict, err)
+	case errors.Is(err, database.ErrRecordOnHold):
+		return ErrRecordOnHold
+	case errors.Is(err, database.ErrBusy):
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	case errors.Is(err, database.ErrConstraint):
+		return fmt.Errorf("%w: %w", ErrConstraintViolation, err)
+	case errors.Is(err, database.ErrCorrupt):
+		return fmt.Errorf("%w: %w", ErrStorageCorrupt, err)
+	}
+	return err
+}
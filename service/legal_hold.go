@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+)
+
+var ErrRecordOnHold = errors.New("record is under legal hold")
+var ErrLegalHoldExists = errors.New("record is already under legal hold")
+var ErrLegalHoldDoesNotExist = errors.New("record is not under legal hold")
+var ErrLegalHoldReasonMissing = errors.New("placing or releasing a legal hold requires an actor and a reason")
+
+// LegalHoldService is implemented by record services that can place records under legal hold.
+//
+// While a record is held, its history cannot be deleted or rewritten.
+type LegalHoldService interface {
+
+	// PlaceLegalHold will put the record under legal hold on behalf of actor.
+	//
+	// PlaceLegalHold will error if the record does not exist or is already held.
+	PlaceLegalHold(ctx context.Context, id int, actor string, reason string) (entity.LegalHold, error)
+
+	// ReleaseLegalHold will lift the legal hold on the record on behalf of actor.
+	ReleaseLegalHold(ctx context.Context, id int, actor string, reason string) error
+
+	// GetLegalHold will retrieve the current hold on the record.
+	GetLegalHold(ctx context.Context, id int) (entity.LegalHold, error)
+
+	// GetLegalHoldEvents will retrieve every placement and release of a hold on the record.
+	GetLegalHoldEvents(ctx context.Context, id int) ([]entity.LegalHoldEvent, error)
+}
+
+func (s *PersistentRecordService) PlaceLegalHold(ctx context.Context, id int, actor string, reason string) (entity.LegalHold, error) {
+	if actor == "" || reason == "" {
+		return entity.LegalHold{}, ErrLegalHoldReasonMissing
+	}
+	if _, err := s.GetRecord(ctx, id); err != nil {
+		return entity.LegalHold{}, err
+	}
+
+	err := database.PlaceLegalHold(ctx, s.db, id, actor, reason)
+	if err != nil {
+		if errors.Is(err, database.ErrLegalHoldExists) {
+			return entity.LegalHold{}, ErrLegalHoldExists
+		}
+		return entity.LegalHold{}, translateError(err, ErrRecordDoesNotExist, ErrLegalHoldExists)
+	}
+	return s.GetLegalHold(ctx, id)
+}
+
+func (s *PersistentRecordService) ReleaseLegalHold(ctx context.Context, id int, actor string, reason string) error {
+	if actor == "" || reason == "" {
+		return ErrLegalHoldReasonMissing
+	}
+
+	err := database.ReleaseLegalHold(ctx, s.db, id, actor, reason)
+	if errors.Is(err, database.ErrLegalHoldDoesNotExist) {
+		return ErrLegalHoldDoesNotExist
+	}
+	return translateError(err, ErrLegalHoldDoesNotExist, ErrLegalHoldExists)
+}
+
+func (s *PersistentRecordService) GetLegalHold(ctx context.Context, id int) (entity.LegalHold, error) {
+	hold, err := database.GetLegalHold(ctx, s.db, id)
+	if err != nil {
+		return entity.LegalHold{}, translateError(err, ErrLegalHoldDoesNotExist, ErrLegalHoldExists)
+	}
+	return *hold, nil
+}
+
+func (s *PersistentRecordService) GetLegalHoldEvents(ctx context.Context, id int) ([]entity.LegalHoldEvent, error) {
+	events, err := database.GetLegalHoldEvents(ctx, s.db, id)
+	return events, translateError(err, ErrLegalHoldDoesNotExist, ErrLegalHoldExists)
+}
+
+// IsOnLegalHold reports whether the record is currently held.
+func (s *PersistentRecordService) IsOnLegalHold(ctx context.Context, id int) (bool, error) {
+	_, err := s.GetLegalHold(ctx, id)
+	if errors.Is(err, ErrLegalHoldDoesNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/chauvm/timetravel/database"
+)
+
+var ErrBodyTooLarge = errors.New("request body is too large")
+var ErrRecordTooLarge = errors.New("record is too large")
+var ErrTooManyKeys = errors.New("update has too many keys")
+var ErrKeyTooLong = errors.New("key is too long")
+var ErrValueTooLong = errors.New("value is too long")
+var ErrTooManyVersions = errors.New("record has too many versions")
+
+// Limits bound what a client can store, so a single request cannot hammer the database.
+// A limit of 0 is no limit.
+type Limits struct {
+	// MaxBodyBytes bounds the encoded request, it is enforced by the transports
+	MaxBodyBytes int
+	// MaxKeys bounds the keys of a single create or update, deletions included
+	MaxKeys int
+	// MaxKeyLength and MaxValueLength bound a single key and value, in bytes
+	MaxKeyLength   int
+	MaxValueLength int
+	// MaxRecordBytes bounds the keys and values of a version, together
+	MaxRecordBytes int
+	// MaxVersions bounds the versions kept of a record, versions removed by compaction excluded
+	MaxVersions int
+}
+
+// DefaultLimits are generous for any legitimate record and leave the number of versions unbounded.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxBodyBytes:   1 << 20,
+		MaxKeys:        1000,
+		MaxKeyLength:   256,
+		MaxValueLength: 64 << 10,
+		MaxRecordBytes: 1 << 20,
+		MaxVersions:    0,
+	}
+}
+
+// LimitedService is implemented by record services that bound what clients can store.
+// Transports read the limits they enforce themselves, such as the size of the request, from it.
+type LimitedService interface {
+
+	// Limits will return the limits the service enforces.
+	Limits() Limits
+}
+
+func exceeds(value int, limit int) bool {
+	return limit > 0 && value > limit
+}
+
+// checkUpdates enforces the limits on the keys and values a client sends, nil values
+// being deletions
+func (l Limits) checkUpdates(updates map[string]*string) error {
+	if exceeds(len(updates), l.MaxKeys) {
+		return fmt.Errorf("%w: %d keys, at most %d are allowed", ErrTooManyKeys, len(updates), l.MaxKeys)
+	}
+	for key, value := range updates {
+		if exceeds(len(key), l.MaxKeyLength) {
+			return fmt.Errorf("%w: a key of %d bytes, at most %d are allowed", ErrKeyTooLong, len(key), l.MaxKeyLength)
+		}
+		if value != nil && exceeds(len(*value), l.MaxValueLength) {
+			return fmt.Errorf("%w: the value of %q is %d bytes, at most %d are allowed", ErrValueTooLong, key, len(*value), l.MaxValueLength)
+		}
+	}
+	return nil
+}
+
+// checkData enforces the limits on the data of a new record
+func (l Limits) checkData(data map[string]string) error {
+	updates := make(map[string]*string, len(data))
+	for key, value := range data {
+		value := value
+		updates[key] = &value
+	}
+	if err := l.checkUpdates(updates); err != nil {
+		return err
+	}
+	return l.checkSize(data)
+}
+
+// checkSize enforces the limit on the keys and values of a version, together
+func (l Limits) checkSize(data map[string]string) error {
+	size := 0
+	for key, value := range data {
+		size += len(key) + len(value)
+	}
+	if exceeds(size, l.MaxRecordBytes) {
+		return fmt.Errorf("%w: %d bytes of keys and values, at most %d are allowed", ErrRecordTooLarge, size, l.MaxRecordBytes)
+	}
+	return nil
+}
+
+// checkVersions enforces the limit on the versions of the record, before one is added
+func (s *PersistentRecordService) checkVersions(ctx context.Context, id int) error {
+	if s.limits.MaxVersions <= 0 {
+		return nil
+	}
+	versions, err := database.CountRecordVersions(ctx, s.db, id)
+	if err != nil {
+		return translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+	}
+	return s.limits.checkVersionCount(versions)
+}
+
+// checkVersionCount fails when a record with that many versions may not get another one
+func (l Limits) checkVersionCount(versions int) error {
+	if l.MaxVersions > 0 && versions >= l.MaxVersions {
+		return fmt.Errorf("%w: it has %d, at most %d are kept", ErrTooManyVersions, versions, l.MaxVersions)
+	}
+	return nil
+}
+
+func (s *PersistentRecordService) Limits() Limits {
+	return s.limits
+}
@@ -0,0 +1,479 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/tenant"
+)
+
+// InMemoryRecordService keeps every version of every record in memory, for tests, demos
+// and ephemeral environments. It behaves exactly like PersistentRecordService, legal
+// holds, retroactive reports, tenants and compaction included, and is safe for
+// concurrent use. Everything is lost when the process exits.
+type InMemoryRecordService struct {
+	mu     sync.RWMutex
+	limits Limits
+	now    func() time.Time
+	// versions of the records by tenant and id, oldest first
+	records map[string]map[int][]entity.Record
+	holds   map[string]map[int]entity.LegalHold
+	events  map[string]map[int][]entity.LegalHoldEvent
+}
+
+func NewInMemoryRecordService(limits Limits) *InMemoryRecordService {
+	return &InMemoryRecordService{
+		limits:  limits,
+		now:     time.Now,
+		records: map[string]map[int][]entity.Record{},
+		holds:   map[string]map[int]entity.LegalHold{},
+		events:  map[string]map[int][]entity.LegalHoldEvent{},
+	}
+}
+
+// timestamp is the current time as the database reports the times it records
+func (s *InMemoryRecordService) timestamp() string {
+	return s.now().UTC().Format(time.RFC3339)
+}
+
+// versions returns the versions of the record of the tenant of ctx, callers hold the lock
+func (s *InMemoryRecordService) versions(ctx context.Context, id int) []entity.Record {
+	return s.records[tenant.ID(ctx)][id]
+}
+
+// copyRecord is necessary so modifications to a returned record don't change the stored one
+func copyRecord(record entity.Record) entity.Record {
+	record.Data = copyData(record.Data)
+	record.Updates = copyData(record.Updates)
+	return record
+}
+
+func copyData(data map[string]string) map[string]string {
+	if data == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(data))
+	for key, value := range data {
+		copied[key] = value
+	}
+	return copied
+}
+
+func (s *InMemoryRecordService) GetRecord(ctx context.Context, id int) (entity.Record, error) {
+	recordLookups.Inc(LOOKUP_LATEST)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.latest(ctx, id)
+}
+
+// latest returns the latest version of the record, callers hold the lock
+func (s *InMemoryRecordService) latest(ctx context.Context, id int) (entity.Record, error) {
+	versions := s.versions(ctx, id)
+	if len(versions) == 0 {
+		return entity.Record{}, ErrRecordDoesNotExist
+	}
+	return copyRecord(versions[len(versions)-1]), nil
+}
+
+func (s *InMemoryRecordService) CreateRecord(ctx context.Context, record entity.Record) error {
+	if record.ID <= 0 {
+		return ErrRecordIDInvalid
+	}
+	if err := s.limits.checkData(record.Data); err != nil {
+		return err
+	}
+	if record.OccurredAt == "" {
+		record.OccurredAt = occurredAt(ctx)
+	}
+	if record.Author == "" {
+		record.Author = author(ctx)
+	}
+	if record.OccurredAt != "" {
+		// the database keeps the occurrence in UTC, to the second
+		at, err := time.Parse(time.RFC3339, record.OccurredAt)
+		if err != nil {
+			return err
+		}
+		record.OccurredAt = at.UTC().Format(time.RFC3339)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.versions(ctx, record.ID)) > 0 {
+		return ErrRecordAlreadyExists
+	}
+	s.insert(ctx, record)
+	recordsCreated.Inc()
+	return nil
+}
+
+// insert appends a version of a record, callers hold the lock
+func (s *InMemoryRecordService) insert(ctx context.Context, record entity.Record) {
+	tenantID := tenant.ID(ctx)
+	if s.records[tenantID] == nil {
+		s.records[tenantID] = map[int][]entity.Record{}
+	}
+	record = copyRecord(record)
+	// json null and an empty map are both read back from the database as an empty map
+	if record.Data == nil {
+		record.Data = map[string]string{}
+	}
+	if record.Updates == nil {
+		record.Updates = map[string]string{}
+	}
+	record.Timestamp = s.timestamp()
+	s.records[tenantID][record.ID] = append(s.records[tenantID][record.ID], record)
+}
+
+func (s *InMemoryRecordService) UpdateRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error) {
+	if err := s.limits.checkUpdates(updates); err != nil {
+		return entity.Record{}, err
+	}
+
+	// the lock is held from reading the latest version to writing the next one, so
+	// concurrent updates never conflict
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	latestRecord, err := s.latest(ctx, id)
+	if err != nil {
+		return entity.Record{}, err
+	}
+	if versions := len(s.versions(ctx, id)); exceeds(versions+1, s.limits.MaxVersions) {
+		return entity.Record{}, fmt.Errorf("%w: it has %d, at most %d are kept", ErrTooManyVersions, versions, s.limits.MaxVersions)
+	}
+
+	newRecordData := copyData(latestRecord.Data)
+	for key, value := range updates {
+		if value == nil {
+			delete(newRecordData, key)
+		} else {
+			newRecordData[key] = *value
+		}
+	}
+	if err := s.limits.checkSize(newRecordData); err != nil {
+		return entity.Record{}, err
+	}
+
+	newRecord := entity.Record{
+		ID:         id,
+		Data:       newRecordData,
+		Updates:    latestRecord.Updates,
+		Version:    latestRecord.Version + 1,
+		OccurredAt: occurredAt(ctx),
+		Author:     author(ctx),
+	}
+	s.insert(ctx, newRecord)
+	recordsUpdated.Inc()
+
+	return newRecord, nil
+}
+
+func (s *InMemoryRecordService) GetRecordVersions(ctx context.Context, id int) ([]int, error) {
+	recordLookups.Inc(LOOKUP_VERSIONS)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stored := s.versions(ctx, id)
+	versions := make([]int, 0, len(stored))
+	for i := len(stored) - 1; i >= 0; i-- {
+		versions = append(versions, stored[i].Version)
+	}
+	return versions, nil
+}
+
+func (s *InMemoryRecordService) GetRecordAtVersion(ctx context.Context, id int, version int) (entity.Record, error) {
+	recordLookups.Inc(LOOKUP_VERSION)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, record := range s.versions(ctx, id) {
+		if record.Version == version {
+			return copyRecord(record), nil
+		}
+	}
+	return entity.Record{}, ErrRecordDoesNotExist
+}
+
+func (s *InMemoryRecordService) GetRecordAtTime(ctx context.Context, id int, at time.Time) (entity.Record, error) {
+	recordLookups.Inc(LOOKUP_TIME)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.atTime(ctx, id, at)
+}
+
+// atTime returns the latest version recorded at or before the time, callers hold the lock
+func (s *InMemoryRecordService) atTime(ctx context.Context, id int, at time.Time) (entity.Record, error) {
+	// times are recorded to the second
+	at = at.UTC().Truncate(time.Second)
+	versions := s.versions(ctx, id)
+	for i := len(versions) - 1; i >= 0; i-- {
+		recorded, err := versions[i].RecordedAt()
+		if err != nil {
+			return entity.Record{}, err
+		}
+		if !recorded.After(at) {
+			return copyRecord(versions[i]), nil
+		}
+	}
+	return entity.Record{}, ErrRecordDoesNotExist
+}
+
+func (s *InMemoryRecordService) GetRecordHistory(ctx context.Context, id int) ([]entity.Record, error) {
+	recordLookups.Inc(LOOKUP_HISTORY)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stored := s.versions(ctx, id)
+	records := make([]entity.Record, 0, len(stored))
+	for _, record := range stored {
+		records = append(records, copyRecord(record))
+	}
+	return records, nil
+}
+
+func (s *InMemoryRecordService) GetRecordIDs(ctx context.Context) ([]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]int, 0, len(s.records[tenant.ID(ctx)]))
+	for id, versions := range s.records[tenant.ID(ctx)] {
+		if len(versions) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (s *InMemoryRecordService) GetTenants(ctx context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tenants := make([]string, 0, len(s.records))
+	for id, records := range s.records {
+		for _, versions := range records {
+			if len(versions) > 0 {
+				tenants = append(tenants, id)
+				break
+			}
+		}
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+// DeleteRecordVersions removes versions of a record, rewriting the updates of the versions
+// left like database.DeleteRecordVersions does.
+func (s *InMemoryRecordService) DeleteRecordVersions(ctx context.Context, id int, versions []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, held := s.holds[tenant.ID(ctx)][id]; held {
+		return ErrRecordOnHold
+	}
+	removed := map[int]bool{}
+	for _, version := range versions {
+		removed[version] = true
+	}
+
+	remaining := make([]entity.Record, 0)
+	for _, record := range s.versions(ctx, id) {
+		if !removed[record.Version] {
+			remaining = append(remaining, record)
+		}
+	}
+	for i := 1; i < len(remaining); i++ {
+		if !removedBetween(versions, remaining[i-1].Version, remaining[i].Version) {
+			continue
+		}
+		updates := map[string]string{}
+		for key, value := range remaining[i].Data {
+			if previous, ok := remaining[i-1].Data[key]; !ok || previous != value {
+				updates[key] = value
+			}
+		}
+		remaining[i].Updates = updates
+	}
+	if len(remaining) == 0 {
+		delete(s.records[tenant.ID(ctx)], id)
+	} else {
+		s.records[tenant.ID(ctx)][id] = remaining
+	}
+	return nil
+}
+
+// removedBetween reports whether any of the removed versions lies strictly between from and to
+func removedBetween(removed []int, from int, to int) bool {
+	for _, version := range removed {
+		if version > from && version < to {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *InMemoryRecordService) Limits() Limits {
+	return s.limits
+}
+
+func (s *InMemoryRecordService) ExportTenant(ctx context.Context) ([]entity.Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]int, 0)
+	for id := range s.records[tenant.ID(ctx)] {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	records := make([]entity.Record, 0)
+	for _, id := range ids {
+		for _, record := range s.versions(ctx, id) {
+			records = append(records, copyRecord(record))
+		}
+	}
+	return records, nil
+}
+
+func (s *InMemoryRecordService) DeleteTenant(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tenantID := tenant.ID(ctx)
+	if len(s.holds[tenantID]) > 0 {
+		return 0, ErrRecordOnHold
+	}
+	deleted := 0
+	for _, versions := range s.records[tenantID] {
+		deleted += len(versions)
+	}
+	// the history of the holds is kept for the audit trail
+	delete(s.records, tenantID)
+	return deleted, nil
+}
+
+func (s *InMemoryRecordService) PlaceLegalHold(ctx context.Context, id int, actor string, reason string) (entity.LegalHold, error) {
+	if actor == "" || reason == "" {
+		return entity.LegalHold{}, ErrLegalHoldReasonMissing
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.latest(ctx, id); err != nil {
+		return entity.LegalHold{}, err
+	}
+	tenantID := tenant.ID(ctx)
+	if _, held := s.holds[tenantID][id]; held {
+		return entity.LegalHold{}, ErrLegalHoldExists
+	}
+	if s.holds[tenantID] == nil {
+		s.holds[tenantID] = map[int]entity.LegalHold{}
+	}
+	hold := entity.LegalHold{RecordID: id, PlacedBy: actor, Reason: reason, PlacedAt: s.timestamp()}
+	s.holds[tenantID][id] = hold
+	s.addLegalHoldEvent(ctx, id, database.LEGAL_HOLD_PLACED, actor, reason)
+	return hold, nil
+}
+
+func (s *InMemoryRecordService) ReleaseLegalHold(ctx context.Context, id int, actor string, reason string) error {
+	if actor == "" || reason == "" {
+		return ErrLegalHoldReasonMissing
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tenantID := tenant.ID(ctx)
+	if _, held := s.holds[tenantID][id]; !held {
+		return ErrLegalHoldDoesNotExist
+	}
+	delete(s.holds[tenantID], id)
+	s.addLegalHoldEvent(ctx, id, database.LEGAL_HOLD_RELEASED, actor, reason)
+	return nil
+}
+
+// addLegalHoldEvent records a hold being placed or released, callers hold the lock
+func (s *InMemoryRecordService) addLegalHoldEvent(ctx context.Context, id int, action string, actor string, reason string) {
+	tenantID := tenant.ID(ctx)
+	if s.events[tenantID] == nil {
+		s.events[tenantID] = map[int][]entity.LegalHoldEvent{}
+	}
+	event := entity.LegalHoldEvent{RecordID: id, Action: action, Actor: actor, Reason: reason, Timestamp: s.timestamp()}
+	s.events[tenantID][id] = append(s.events[tenantID][id], event)
+}
+
+func (s *InMemoryRecordService) GetLegalHold(ctx context.Context, id int) (entity.LegalHold, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hold, held := s.holds[tenant.ID(ctx)][id]
+	if !held {
+		return entity.LegalHold{}, ErrLegalHoldDoesNotExist
+	}
+	return hold, nil
+}
+
+func (s *InMemoryRecordService) GetLegalHoldEvents(ctx context.Context, id int) ([]entity.LegalHoldEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append(make([]entity.LegalHoldEvent, 0), s.events[tenant.ID(ctx)][id]...), nil
+}
+
+// IsOnLegalHold reports whether the record is currently held.
+func (s *InMemoryRecordService) IsOnLegalHold(ctx context.Context, id int) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, held := s.holds[tenant.ID(ctx)][id]
+	return held, nil
+}
+
+func (s *InMemoryRecordService) GetRetroactiveChanges(ctx context.Context, threshold time.Duration) ([]entity.RetroactiveChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	late := make([]*entity.Record, 0)
+	for _, versions := range s.records[tenant.ID(ctx)] {
+		for _, version := range versions {
+			if version.OccurredAt == "" {
+				continue
+			}
+			occurred, err := time.Parse(time.RFC3339, version.OccurredAt)
+			if err != nil {
+				return nil, err
+			}
+			recorded, err := version.RecordedAt()
+			if err != nil {
+				return nil, err
+			}
+			if recorded.Sub(occurred) > threshold {
+				version := copyRecord(version)
+				late = append(late, &version)
+			}
+		}
+	}
+	sort.Slice(late, func(i, j int) bool {
+		if late[i].Timestamp != late[j].Timestamp {
+			return late[i].Timestamp < late[j].Timestamp
+		}
+		if late[i].ID != late[j].ID {
+			return late[i].ID < late[j].ID
+		}
+		return late[i].Version < late[j].Version
+	})
+
+	// replacedData would take the lock again
+	return retroactiveChanges(late, func(id int, version int) (map[string]string, error) {
+		versions := s.versions(ctx, id)
+		for i := len(versions) - 1; i >= 0; i-- {
+			if versions[i].Version < version {
+				return copyRecord(versions[i]).Data, nil
+			}
+		}
+		return nil, ErrRecordDoesNotExist
+	})
+}
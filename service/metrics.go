@@ -0,0 +1,17 @@
+package service
+
+import "github.com/chauvm/timetravel/metrics"
+
+// kinds of lookups counted by recordLookups
+const (
+	LOOKUP_LATEST   = "latest"
+	LOOKUP_VERSION  = "version"
+	LOOKUP_TIME     = "time"
+	LOOKUP_HISTORY  = "history"
+	LOOKUP_VERSIONS = "versions"
+)
+
+var recordsCreated = metrics.Default.NewCounterVec("timetravel_records_created_total", "Records created.")
+var recordsUpdated = metrics.Default.NewCounterVec("timetravel_records_updated_total", "Versions appended to existing records.")
+var recordLookups = metrics.Default.NewCounterVec("timetravel_record_lookups_total",
+	"Record reads by kind: latest, version, time, history or versions.", "kind")
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/logging"
+	"github.com/chauvm/timetravel/postgres"
+)
+
+// PostgresRecordService keeps the records in PostgreSQL, with the same semantics as
+// PersistentRecordService. Updates of a record wait for each other on a row lock instead
+// of conflicting, see postgres.UpdateRecord.
+type PostgresRecordService struct {
+	db     *sql.DB
+	limits Limits
+}
+
+func NewPostgresRecordService(db *sql.DB, limits Limits) PostgresRecordService {
+	return PostgresRecordService{
+		db:     db,
+		limits: limits,
+	}
+}
+
+func (s *PostgresRecordService) GetRecord(ctx context.Context, id int) (entity.Record, error) {
+	recordLookups.Inc(LOOKUP_LATEST)
+	record, err := postgres.GetLatestRecord(ctx, s.db, id)
+	if err != nil {
+		return entity.Record{}, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+	}
+	return *record, nil
+}
+
+func (s *PostgresRecordService) CreateRecord(ctx context.Context, record entity.Record) error {
+	if record.ID <= 0 {
+		return ErrRecordIDInvalid
+	}
+	if err := s.limits.checkData(record.Data); err != nil {
+		return err
+	}
+	if record.OccurredAt == "" {
+		record.OccurredAt = occurredAt(ctx)
+	}
+	if record.Author == "" {
+		record.Author = author(ctx)
+	}
+	if err := postgres.InsertRecord(ctx, s.db, record); err != nil {
+		return translateError(err, ErrRecordDoesNotExist, ErrRecordAlreadyExists)
+	}
+	recordsCreated.Inc()
+	logging.FromContext(ctx).Debug("created record", "record_id", record.ID, "data", logging.Payload(record.Data))
+	return nil
+}
+
+func (s *PostgresRecordService) UpdateRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error) {
+	if err := s.limits.checkUpdates(updates); err != nil {
+		return entity.Record{}, err
+	}
+	// the versions are counted under the lock of the record, so concurrent writers cannot
+	// all pass the limit
+	newRecord, err := postgres.UpdateRecord(ctx, s.db, id, func(latestRecord entity.Record, versions int) (entity.Record, error) {
+		if err := s.limits.checkVersionCount(versions); err != nil {
+			return entity.Record{}, err
+		}
+		newRecordData := map[string]string{}
+		for key, value := range latestRecord.Data {
+			newRecordData[key] = value
+		}
+		for key, value := range updates {
+			if value == nil {
+				delete(newRecordData, key)
+			} else {
+				newRecordData[key] = *value
+			}
+		}
+		if err := s.limits.checkSize(newRecordData); err != nil {
+			return entity.Record{}, err
+		}
+		return entity.Record{
+			ID:         id,
+			Data:       newRecordData,
+			Updates:    latestRecord.Updates,
+			Version:    latestRecord.Version + 1,
+			OccurredAt: occurredAt(ctx),
+			Author:     author(ctx),
+		}, nil
+	})
+	if err != nil {
+		return entity.Record{}, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+	}
+	recordsUpdated.Inc()
+	return *newRecord, nil
+}
+
+func (s *PostgresRecordService) GetRecordVersions(ctx context.Context, id int) ([]int, error) {
+	recordLookups.Inc(LOOKUP_VERSIONS)
+	versions, err := postgres.GetRecordVersions(ctx, s.db, id)
+	if err != nil {
+		return versions, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+	}
+	return versions, nil
+}
+
+func (s *PostgresRecordService) GetRecordAtVersion(ctx context.Context, id int, version int) (entity.Record, error) {
+	recordLookups.Inc(LOOKUP_VERSION)
+	record, err := postgres.GetRecordAtVersion(ctx, s.db, id, version)
+	if err != nil {
+		return entity.Record{}, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+	}
+	return *record, nil
+}
+
+func (s *PostgresRecordService) GetRecordAtTime(ctx context.Context, id int, at time.Time) (entity.Record, error) {
+	recordLookups.Inc(LOOKUP_TIME)
+	record, err := postgres.GetRecordAtTime(ctx, s.db, id, at)
+	if err != nil {
+		return entity.Record{}, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+	}
+	return *record, nil
+}
+
+func (s *PostgresRecordService) GetRecordHistory(ctx context.Context, id int) ([]entity.Record, error) {
+	recordLookups.Inc(LOOKUP_HISTORY)
+	history, err := postgres.GetRecordHistory(ctx, s.db, id)
+	if err != nil {
+		return nil, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+	}
+	return dereference(history), nil
+}
+
+func (s *PostgresRecordService) GetRecordIDs(ctx context.Context) ([]int, error) {
+	ids, err := postgres.GetRecordIDs(ctx, s.db)
+	return ids, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+}
+
+func (s *PostgresRecordService) DeleteRecordVersions(ctx context.Context, id int, versions []int) error {
+	err := postgres.DeleteRecordVersions(ctx, s.db, id, versions)
+	return translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+}
+
+func (s *PostgresRecordService) Limits() Limits {
+	return s.limits
+}
+
+func (s *PostgresRecordService) GetRetroactiveChanges(ctx context.Context, threshold time.Duration) ([]entity.RetroactiveChange, error) {
+	versions, err := postgres.GetLateVersions(ctx, s.db, threshold)
+	if err != nil {
+		return nil, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+	}
+	return retroactiveChanges(versions, func(id int, version int) (map[string]string, error) {
+		return replacedData(ctx, s, id, version)
+	})
+}
+
+func (s *PostgresRecordService) ExportTenant(ctx context.Context) ([]entity.Record, error) {
+	versions, err := postgres.GetTenantRecords(ctx, s.db)
+	if err != nil {
+		return nil, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+	}
+	return dereference(versions), nil
+}
+
+func (s *PostgresRecordService) DeleteTenant(ctx context.Context) (int, error) {
+	deleted, err := postgres.DeleteTenant(ctx, s.db)
+	if err != nil {
+		return 0, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+	}
+	return deleted, nil
+}
+
+// GetTenants lists every tenant that has records, whatever the tenant of ctx.
+func (s *PostgresRecordService) GetTenants(ctx context.Context) ([]string, error) {
+	tenants, err := postgres.GetTenants(ctx, s.db)
+	return tenants, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+}
+
+func (s *PostgresRecordService) PlaceLegalHold(ctx context.Context, id int, actor string, reason string) (entity.LegalHold, error) {
+	if actor == "" || reason == "" {
+		return entity.LegalHold{}, ErrLegalHoldReasonMissing
+	}
+	if _, err := s.GetRecord(ctx, id); err != nil {
+		return entity.LegalHold{}, err
+	}
+
+	err := postgres.PlaceLegalHold(ctx, s.db, id, actor, reason)
+	if errors.Is(err, database.ErrLegalHoldExists) {
+		return entity.LegalHold{}, ErrLegalHoldExists
+	}
+	if err != nil {
+		return entity.LegalHold{}, translateError(err, ErrRecordDoesNotExist, ErrLegalHoldExists)
+	}
+	return s.GetLegalHold(ctx, id)
+}
+
+func (s *PostgresRecordService) ReleaseLegalHold(ctx context.Context, id int, actor string, reason string) error {
+	if actor == "" || reason == "" {
+		return ErrLegalHoldReasonMissing
+	}
+
+	err := postgres.ReleaseLegalHold(ctx, s.db, id, actor, reason)
+	if errors.Is(err, database.ErrLegalHoldDoesNotExist) {
+		return ErrLegalHoldDoesNotExist
+	}
+	return translateError(err, ErrLegalHoldDoesNotExist, ErrLegalHoldExists)
+}
+
+func (s *PostgresRecordService) GetLegalHold(ctx context.Context, id int) (entity.LegalHold, error) {
+	hold, err := postgres.GetLegalHold(ctx, s.db, id)
+	if err != nil {
+		return entity.LegalHold{}, translateError(err, ErrLegalHoldDoesNotExist, ErrLegalHoldExists)
+	}
+	return *hold, nil
+}
+
+func (s *PostgresRecordService) GetLegalHoldEvents(ctx context.Context, id int) ([]entity.LegalHoldEvent, error) {
+	events, err := postgres.GetLegalHoldEvents(ctx, s.db, id)
+	return events, translateError(err, ErrLegalHoldDoesNotExist, ErrLegalHoldExists)
+}
+
+// IsOnLegalHold reports whether the record is currently held.
+func (s *PostgresRecordService) IsOnLegalHold(ctx context.Context, id int) (bool, error) {
+	_, err := s.GetLegalHold(ctx, id)
+	if errors.Is(err, ErrLegalHoldDoesNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// dereference copies the records the postgres package returns
+func dereference(records []*entity.Record) []entity.Record {
+	copied := make([]entity.Record, 0, len(records))
+	for _, record := range records {
+		copied = append(copied, *record)
+	}
+	return copied
+}
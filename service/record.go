@@ -4,10 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"log"
+	"time"
 
 	"github.com/chauvm/timetravel/database"
 	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/logging"
 )
 
 var ErrRecordDoesNotExist = errors.New("record with that id does not exist")
@@ -36,122 +37,75 @@ type RecordService interface {
 
 	// GetRecordAtVersion will retrieve a record at a specific version.
 	GetRecordAtVersion(ctx context.Context, id int, version int) (entity.Record, error)
-}
 
-// // InMemoryRecordService is an in-memory implementation of RecordService.
-// type InMemoryRecordService struct {
-// 	data map[int]entity.Record
-// }
-
-// func NewInMemoryRecordService() InMemoryRecordService {
-// 	return InMemoryRecordService{
-// 		data: map[int]entity.Record{},
-// 	}
-// }
-
-// func (s *InMemoryRecordService) GetRecord(ctx context.Context, id int) (entity.Record, error) {
-// 	record := s.data[id]
-// 	if record.ID == 0 {
-// 		return entity.Record{}, ErrRecordDoesNotExist
-// 	}
-
-// 	record = record.Copy() // copy is necessary so modifations to the record don't change the stored record
-// 	return record, nil
-// }
-
-// func (s *InMemoryRecordService) CreateRecord(ctx context.Context, record entity.Record) error {
-// 	id := record.ID
-// 	if id <= 0 {
-// 		return ErrRecordIDInvalid
-// 	}
-
-// 	existingRecord := s.data[id]
-// 	if existingRecord.ID != 0 {
-// 		return ErrRecordAlreadyExists
-// 	}
-
-// 	s.data[id] = record
-// 	return nil
-// }
-
-// func (s *InMemoryRecordService) UpdateRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error) {
-// 	entry := s.data[id]
-// 	if entry.ID == 0 {
-// 		return entity.Record{}, ErrRecordDoesNotExist
-// 	}
-
-// 	for key, value := range updates {
-// 		if value == nil { // deletion update
-// 			delete(entry.Data, key)
-// 		} else {
-// 			entry.Data[key] = *value
-// 		}
-// 	}
-
-// 	return entry.Copy(), nil
-// }
-// func (s *InMemoryRecordService) GetRecordVersions(ctx context.Context, id int) ([]int, error) {
-// 	// v1 shouldn't have this method
-// 	return nil, nil
-// }
-
-// func (s *InMemoryRecordService) GetRecordAtVersion(ctx context.Context, id int, version int) (entity.Record, error) {
-// 	// v1 shouldn't have this method
-// 	return entity.Record{}, nil
-// }
+	// GetRecordAtTime will retrieve the version of a record that was in force at the given time.
+	//
+	// GetRecordAtTime will error if the record did not exist yet at that time.
+	GetRecordAtTime(ctx context.Context, id int, at time.Time) (entity.Record, error)
+
+	// GetRecordHistory will retrieve every version of a record, oldest first.
+	GetRecordHistory(ctx context.Context, id int) ([]entity.Record, error)
+}
 
 // PersistentRecordService is a persistent implementation of RecordService.
 type PersistentRecordService struct {
-	db *sql.DB
+	db     *sql.DB
+	limits Limits
 }
 
-func NewPersistentRecordService(db *sql.DB) PersistentRecordService {
+func NewPersistentRecordService(db *sql.DB, limits Limits) PersistentRecordService {
 	return PersistentRecordService{
-		db: db,
+		db:     db,
+		limits: limits,
 	}
 }
 
 func (s *PersistentRecordService) GetRecord(ctx context.Context, id int) (entity.Record, error) {
+	recordLookups.Inc(LOOKUP_LATEST)
 	// Approach 2.2 first, assume a row's accumulated_data has everything we need
-	latestRecord, err := database.GetLatestRecord(s.db, id)
+	latestRecord, err := database.GetLatestRecord(ctx, s.db, id)
 
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return entity.Record{}, ErrRecordDoesNotExist
-		}
-		return entity.Record{}, err
+		return entity.Record{}, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
 	}
 
 	return *latestRecord, nil
 }
 
 func (s *PersistentRecordService) CreateRecord(ctx context.Context, record entity.Record) error {
-	log.Printf("CreateRecord in PersistentRecordService %v", record)
-	id, err := database.InsertRecord(s.db, record)
-	log.Printf("CreateRecord in PersistentRecordService with id %v", id)
-	if err != nil {
-		log.Fatal(err)
+	if record.ID <= 0 {
+		return ErrRecordIDInvalid
+	}
+	if err := s.limits.checkData(record.Data); err != nil {
 		return err
 	}
-	// id := record.ID
-	// if id <= 0 {
-	// 	return ErrRecordIDInvalid
-	// }
-
-	// existingRecord := s.data[id]
-	// if existingRecord.ID != 0 {
-	// 	return ErrRecordAlreadyExists
-	// }
-
-	// s.data[id] = record
+	if record.OccurredAt == "" {
+		record.OccurredAt = occurredAt(ctx)
+	}
+	if record.Author == "" {
+		record.Author = author(ctx)
+	}
+	// a racing create of the same id fails on the primary key
+	_, err := database.InsertRecord(ctx, s.db, record)
+	if err != nil {
+		return translateError(err, ErrRecordDoesNotExist, ErrRecordAlreadyExists)
+	}
+	recordsCreated.Inc()
+	logging.FromContext(ctx).Debug("created record", "record_id", record.ID, "data", logging.Payload(record.Data))
 	return nil
 }
 
 func (s *PersistentRecordService) UpdateRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error) {
+	if err := s.limits.checkUpdates(updates); err != nil {
+		return entity.Record{}, err
+	}
 	latestRecord, err := s.GetRecord(ctx, id)
 	if err != nil {
 		return entity.Record{}, err
 	}
+	if err := s.checkVersions(ctx, id); err != nil {
+		return entity.Record{}, err
+	}
 	latestRecordVersion := latestRecord.Version
 
 	// newRecordData is a copy of the latestRecord's data
@@ -169,38 +123,78 @@ func (s *PersistentRecordService) UpdateRecord(ctx context.Context, id int, upda
 		}
 	}
 
+	if err := s.limits.checkSize(newRecordData); err != nil {
+		return entity.Record{}, err
+	}
+
 	// create a new record with the updated data
 	newRecord := entity.Record{
-		ID:      id,
-		Data:    newRecordData,
-		Updates: latestRecord.Updates,
-		Version: latestRecordVersion + 1,
+		ID:         id,
+		Data:       newRecordData,
+		Updates:    latestRecord.Updates,
+		Version:    latestRecordVersion + 1,
+		OccurredAt: occurredAt(ctx),
+		Author:     author(ctx),
 	}
 
-	_, err = database.InsertRecord(s.db, newRecord)
+	// a racing update that wrote the same version first fails on the primary key
+	_, err = database.InsertRecord(ctx, s.db, newRecord)
 
 	if err != nil {
-		return entity.Record{}, err
+		return entity.Record{}, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
 	}
+	recordsUpdated.Inc()
 
 	return newRecord, nil
 }
 
 func (s *PersistentRecordService) GetRecordVersions(ctx context.Context, id int) ([]int, error) {
-	versions, err := database.GetRecordVersions(s.db, id)
+	recordLookups.Inc(LOOKUP_VERSIONS)
+	versions, err := database.GetRecordVersions(ctx, s.db, id)
 	if err != nil {
-		return versions, err
+		return versions, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
 	}
 	return versions, nil
 }
 
 func (s *PersistentRecordService) GetRecordAtVersion(ctx context.Context, id int, version int) (entity.Record, error) {
-	record, err := database.GetRecordAtVersion(s.db, id, version)
+	recordLookups.Inc(LOOKUP_VERSION)
+	record, err := database.GetRecordAtVersion(ctx, s.db, id, version)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return entity.Record{}, ErrRecordDoesNotExist
-		}
-		return entity.Record{}, err
+		return entity.Record{}, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+	}
+	return *record, nil
+}
+
+func (s *PersistentRecordService) GetRecordAtTime(ctx context.Context, id int, at time.Time) (entity.Record, error) {
+	recordLookups.Inc(LOOKUP_TIME)
+	record, err := database.GetRecordAtTime(ctx, s.db, id, at)
+	if err != nil {
+		return entity.Record{}, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
 	}
 	return *record, nil
 }
+
+func (s *PersistentRecordService) GetRecordHistory(ctx context.Context, id int) ([]entity.Record, error) {
+	recordLookups.Inc(LOOKUP_HISTORY)
+	history, err := database.GetRecordHistory(ctx, s.db, id)
+	if err != nil {
+		return nil, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+	}
+
+	records := make([]entity.Record, 0, len(history))
+	for _, record := range history {
+		records = append(records, *record)
+	}
+	return records, nil
+}
+
+func (s *PersistentRecordService) GetRecordIDs(ctx context.Context) ([]int, error) {
+	ids, err := database.GetRecordIDs(ctx, s.db)
+	return ids, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+}
+
+func (s *PersistentRecordService) DeleteRecordVersions(ctx context.Context, id int, versions []int) error {
+	err := database.DeleteRecordVersions(ctx, s.db, id, versions)
+	return translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+}
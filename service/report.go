@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+)
+
+// RetroactiveReporter is implemented by record services that can report late-reported changes.
+type RetroactiveReporter interface {
+
+	// GetRetroactiveChanges will list every version whose client-reported occurrence
+	// precedes the time it was recorded by more than the threshold.
+	GetRetroactiveChanges(ctx context.Context, threshold time.Duration) ([]entity.RetroactiveChange, error)
+}
+
+func (s *PersistentRecordService) GetRetroactiveChanges(ctx context.Context, threshold time.Duration) ([]entity.RetroactiveChange, error) {
+	versions, err := database.GetLateVersions(ctx, s.db, threshold)
+	if err != nil {
+		return nil, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+	}
+
+	return retroactiveChanges(versions, func(id int, version int) (map[string]string, error) {
+		return replacedData(ctx, s, id, version)
+	})
+}
+
+// replacedData returns the data of the latest version kept before the version, the one it
+// replaced unless compaction removed it, ErrRecordDoesNotExist for the first version.
+func replacedData(ctx context.Context, records RecordService, id int, version int) (map[string]string, error) {
+	versions, err := records.GetRecordVersions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// newest first
+	for _, kept := range versions {
+		if kept >= version {
+			continue
+		}
+		replaced, err := records.GetRecordAtVersion(ctx, id, kept)
+		if err != nil {
+			return nil, err
+		}
+		return replaced.Data, nil
+	}
+	return nil, ErrRecordDoesNotExist
+}
+
+// retroactiveChanges reports the late versions, oldest recorded first, against the data
+// replaced returns for the version each one replaced.
+func retroactiveChanges(versions []*entity.Record, replaced func(id int, version int) (map[string]string, error)) ([]entity.RetroactiveChange, error) {
+	changes := make([]entity.RetroactiveChange, 0, len(versions))
+	for _, version := range versions {
+		occurred, err := time.Parse(time.RFC3339, version.OccurredAt)
+		if err != nil {
+			return nil, err
+		}
+		recorded, err := version.RecordedAt()
+		if err != nil {
+			return nil, err
+		}
+
+		// the state we billed on until the change was recorded is the version it replaced,
+		// versions recorded since it occurred included
+		before, err := replaced(version.ID, version.Version)
+		if errors.Is(err, ErrRecordDoesNotExist) {
+			before = map[string]string{}
+		} else if err != nil {
+			return nil, err
+		}
+
+		lag := recorded.Sub(occurred)
+		changes = append(changes, entity.RetroactiveChange{
+			ID:         version.ID,
+			Version:    version.Version,
+			OccurredAt: version.OccurredAt,
+			RecordedAt: version.Timestamp,
+			Lag:        lag.String(),
+			LagSeconds: int64(lag.Seconds()),
+			Before:     before,
+			After:      version.Data,
+		})
+	}
+	return changes, nil
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/tenant"
+	"github.com/stretchr/testify/assert"
+)
+
+func value(s string) *string {
+	return &s
+}
+
+// newInMemoryService returns a service whose clock the test moves with the returned func
+func newInMemoryService(limits Limits) (*InMemoryRecordService, func(time.Duration)) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewInMemoryRecordService(limits)
+	s.now = func() time.Time { return now }
+	return s, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestInMemoryRecordService(t *testing.T) {
+	ctx := context.Background()
+	s, advance := newInMemoryService(Limits{})
+
+	assert.True(t, errors.Is(s.CreateRecord(ctx, entity.Record{ID: 0}), ErrRecordIDInvalid))
+	_, err := s.GetRecord(ctx, 1)
+	assert.True(t, errors.Is(err, ErrRecordDoesNotExist))
+	_, err = s.UpdateRecord(ctx, 1, map[string]*string{"a": value("1")})
+	assert.True(t, errors.Is(err, ErrRecordDoesNotExist))
+	versions, err := s.GetRecordVersions(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{}, versions)
+
+	assert.NoError(t, s.CreateRecord(ctx, entity.Record{ID: 1, Version: 1, Data: map[string]string{"a": "1"}}))
+	assert.True(t, errors.Is(s.CreateRecord(ctx, entity.Record{ID: 1, Version: 1}), ErrRecordAlreadyExists))
+
+	advance(time.Minute)
+	updated, err := s.UpdateRecord(ctx, 1, map[string]*string{"a": value("2"), "b": value("x")})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, updated.Version)
+	assert.Equal(t, map[string]string{"a": "2", "b": "x"}, updated.Data)
+
+	advance(time.Minute)
+	_, err = s.UpdateRecord(ctx, 1, map[string]*string{"b": nil})
+	assert.NoError(t, err)
+
+	latest, err := s.GetRecord(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, latest.Version)
+	assert.Equal(t, map[string]string{"a": "2"}, latest.Data)
+	assert.Equal(t, "2024-01-01T00:02:00Z", latest.Timestamp)
+
+	versions, err = s.GetRecordVersions(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 2, 1}, versions)
+
+	second, err := s.GetRecordAtVersion(ctx, 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "2", "b": "x"}, second.Data)
+	_, err = s.GetRecordAtVersion(ctx, 1, 4)
+	assert.True(t, errors.Is(err, ErrRecordDoesNotExist))
+
+	// times are truncated to the second like the database does
+	atTime, err := s.GetRecordAtTime(ctx, 1, time.Date(2024, 1, 1, 0, 1, 59, 999, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, atTime.Version)
+	_, err = s.GetRecordAtTime(ctx, 1, time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC))
+	assert.True(t, errors.Is(err, ErrRecordDoesNotExist))
+
+	history, err := s.GetRecordHistory(ctx, 1)
+	assert.NoError(t, err)
+	assert.Len(t, history, 3)
+	assert.Equal(t, 1, history[0].Version)
+
+	// returned records are copies
+	latest.Data["a"] = "changed"
+	latest, err = s.GetRecord(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", latest.Data["a"])
+}
+
+func TestInMemoryTenants(t *testing.T) {
+	s, _ := newInMemoryService(Limits{})
+	acme := tenant.WithID(context.Background(), "acme")
+	globex := tenant.WithID(context.Background(), "globex")
+
+	assert.NoError(t, s.CreateRecord(acme, entity.Record{ID: 1, Data: map[string]string{"a": "1"}}))
+	assert.NoError(t, s.CreateRecord(globex, entity.Record{ID: 1, Data: map[string]string{"g": "1"}}))
+	_, err := s.UpdateRecord(acme, 1, map[string]*string{"a": value("2")})
+	assert.NoError(t, err)
+
+	record, err := s.GetRecord(globex, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"g": "1"}, record.Data)
+	_, err = s.GetRecord(context.Background(), 1)
+	assert.True(t, errors.Is(err, ErrRecordDoesNotExist))
+
+	tenants, err := s.GetTenants(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"acme", "globex"}, tenants)
+
+	exported, err := s.ExportTenant(acme)
+	assert.NoError(t, err)
+	assert.Len(t, exported, 2)
+
+	_, err = s.PlaceLegalHold(globex, 1, "legal@example.com", "litigation")
+	assert.NoError(t, err)
+	_, err = s.DeleteTenant(globex)
+	assert.True(t, errors.Is(err, ErrRecordOnHold))
+
+	_, err = s.PlaceLegalHold(acme, 1, "legal@example.com", "audit")
+	assert.NoError(t, err)
+	assert.NoError(t, s.ReleaseLegalHold(acme, 1, "legal@example.com", "done"))
+	deleted, err := s.DeleteTenant(acme)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	// the history of the holds is kept for the audit trail
+	events, err := s.GetLegalHoldEvents(acme, 1)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	tenants, err = s.GetTenants(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"globex"}, tenants)
+}
+
+func TestInMemoryLimits(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newInMemoryService(Limits{MaxKeys: 2, MaxValueLength: 4, MaxVersions: 2})
+	assert.Equal(t, 2, s.Limits().MaxVersions)
+
+	assert.True(t, errors.Is(s.CreateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"a": "too long"}}), ErrValueTooLong))
+	assert.NoError(t, s.CreateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"a": "1"}}))
+	_, err := s.UpdateRecord(ctx, 1, map[string]*string{"a": nil, "b": nil, "c": nil})
+	assert.True(t, errors.Is(err, ErrTooManyKeys))
+	_, err = s.UpdateRecord(ctx, 1, map[string]*string{"a": value("2")})
+	assert.NoError(t, err)
+	_, err = s.UpdateRecord(ctx, 1, map[string]*string{"a": value("3")})
+	assert.True(t, errors.Is(err, ErrTooManyVersions))
+}
+
+func TestInMemoryLegalHolds(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newInMemoryService(Limits{})
+
+	_, err := s.PlaceLegalHold(ctx, 1, "legal@example.com", "litigation")
+	assert.True(t, errors.Is(err, ErrRecordDoesNotExist))
+	assert.NoError(t, s.CreateRecord(ctx, entity.Record{ID: 1, Data: map[string]string{"a": "1"}}))
+	_, err = s.PlaceLegalHold(ctx, 1, "", "litigation")
+	assert.True(t, errors.Is(err, ErrLegalHoldReasonMissing))
+
+	hold, err := s.PlaceLegalHold(ctx, 1, "legal@example.com", "litigation")
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-01-01T00:00:00Z", hold.PlacedAt)
+	_, err = s.PlaceLegalHold(ctx, 1, "legal@example.com", "litigation")
+	assert.True(t, errors.Is(err, ErrLegalHoldExists))
+	held, err := s.IsOnLegalHold(ctx, 1)
+	assert.NoError(t, err)
+	assert.True(t, held)
+	assert.True(t, errors.Is(s.DeleteRecordVersions(ctx, 1, []int{0}), ErrRecordOnHold))
+
+	assert.NoError(t, s.ReleaseLegalHold(ctx, 1, "legal@example.com", "settled"))
+	assert.True(t, errors.Is(s.ReleaseLegalHold(ctx, 1, "legal@example.com", "settled"), ErrLegalHoldDoesNotExist))
+	_, err = s.GetLegalHold(ctx, 1)
+	assert.True(t, errors.Is(err, ErrLegalHoldDoesNotExist))
+
+	events, err := s.GetLegalHoldEvents(ctx, 1)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "settled", events[1].Reason)
+}
+
+func TestInMemoryDeleteRecordVersions(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newInMemoryService(Limits{})
+	assert.NoError(t, s.CreateRecord(ctx, entity.Record{ID: 1, Version: 1, Data: map[string]string{"a": "1"}}))
+	_, err := s.UpdateRecord(ctx, 1, map[string]*string{"b": value("1")})
+	assert.NoError(t, err)
+	_, err = s.UpdateRecord(ctx, 1, map[string]*string{"c": value("1")})
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.DeleteRecordVersions(ctx, 1, []int{2}))
+	history, err := s.GetRecordHistory(ctx, 1)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	// the updates of version 3 now describe the change from version 1
+	assert.Equal(t, map[string]string{"b": "1", "c": "1"}, history[1].Updates)
+
+	ids, err := s.GetRecordIDs(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, ids)
+}
+
+func TestInMemoryRetroactiveChanges(t *testing.T) {
+	s, advance := newInMemoryService(Limits{})
+	assert.NoError(t, s.CreateRecord(context.Background(), entity.Record{ID: 1, Version: 1, Data: map[string]string{"address": "old"}}))
+
+	// a version recorded between the occurrence and the late write is the one it replaced
+	advance(36 * time.Hour)
+	_, err := s.UpdateRecord(context.Background(), 1, map[string]*string{"address": value("interim")})
+	assert.NoError(t, err)
+	advance(12 * time.Hour)
+	late := WithOccurredAt(context.Background(), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))
+	_, err = s.UpdateRecord(late, 1, map[string]*string{"address": value("new")})
+	assert.NoError(t, err)
+
+	changes, err := s.GetRetroactiveChanges(context.Background(), time.Hour)
+	assert.NoError(t, err)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, 3, changes[0].Version)
+	assert.Equal(t, "24h0m0s", changes[0].Lag)
+	assert.Equal(t, map[string]string{"address": "interim"}, changes[0].Before)
+	assert.Equal(t, map[string]string{"address": "new"}, changes[0].After)
+
+	changes, err = s.GetRetroactiveChanges(context.Background(), 48*time.Hour)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestInMemoryConcurrentUpdates(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryRecordService(Limits{})
+	assert.NoError(t, s.CreateRecord(ctx, entity.Record{ID: 1, Version: 1, Data: map[string]string{}}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := s.UpdateRecord(ctx, 1, map[string]*string{fmt.Sprintf("k%d", i): value("v")})
+			assert.NoError(t, err)
+			_, err = s.GetRecordHistory(ctx, 1)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	// no update is lost and every version is distinct
+	latest, err := s.GetRecord(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 51, latest.Version)
+	assert.Len(t, latest.Data, 50)
+}
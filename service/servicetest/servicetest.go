@@ -0,0 +1,349 @@
+// Package servicetest is the behaviour every service.RecordService must share, written as
+// tests any implementation runs against itself:
+//
+//	func TestRecordService(t *testing.T) {
+//		servicetest.Run(t, servicetest.Backend{Open: openMyService})
+//	}
+package servicetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chauvm/timetravel/entity"
+	"github.com/chauvm/timetravel/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// WRITERS is how many goroutines the concurrency tests write with at once.
+const WRITERS = 20
+
+// Backend is a RecordService implementation under test.
+type Backend struct {
+	// Open returns a service over the storage kept in dir, which starts out empty, and
+	// a func releasing it. Opening the same dir again after the release must show the
+	// same records, as a restarted process would.
+	Open func(t *testing.T, dir string) (service.RecordService, func() error)
+	// Ephemeral backends keep nothing once released, persistence is not checked.
+	Ephemeral bool
+}
+
+// Run runs every conformance test against the backend, each one over its own storage.
+func Run(t *testing.T, backend Backend) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s service.RecordService)
+	}{
+		{"Create", testCreate},
+		{"Update", testUpdate},
+		{"DeleteKey", testDeleteKey},
+		{"VersionNumbering", testVersionNumbering},
+		{"HistoricalReads", testHistoricalReads},
+		{"NotFound", testNotFound},
+		{"ConcurrentCreates", testConcurrentCreates},
+		{"ConcurrentWriters", testConcurrentWriters},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			s, release := backend.Open(t, t.TempDir())
+			defer func() { assert.NoError(t, release()) }()
+			test.run(t, s)
+		})
+	}
+
+	t.Run("PersistenceAcrossReopen", func(t *testing.T) {
+		if backend.Ephemeral {
+			t.Skip("the backend keeps nothing across restarts")
+		}
+		testPersistence(t, backend)
+	})
+}
+
+func value(s string) *string {
+	return &s
+}
+
+// newRecord is a record as the v2 api creates it
+func newRecord(id int, data map[string]string) entity.Record {
+	return entity.Record{ID: id, Data: data, Updates: data, Version: 1}
+}
+
+func testCreate(t *testing.T, s service.RecordService) {
+	ctx := context.Background()
+	assert.NoError(t, s.CreateRecord(ctx, newRecord(1, map[string]string{"a": "1", "b": "2"})))
+
+	record, err := s.GetRecord(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, record.ID)
+	assert.Equal(t, 1, record.Version)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, record.Data)
+	_, err = record.RecordedAt()
+	assert.NoError(t, err, "the timestamp must be RFC 3339")
+
+	// an empty record is still a record
+	assert.NoError(t, s.CreateRecord(ctx, newRecord(2, map[string]string{})))
+	record, err = s.GetRecord(ctx, 2)
+	assert.NoError(t, err)
+	assert.Empty(t, record.Data)
+
+	assert.True(t, errors.Is(s.CreateRecord(ctx, newRecord(1, map[string]string{"c": "3"})), service.ErrRecordAlreadyExists))
+	assert.True(t, errors.Is(s.CreateRecord(ctx, newRecord(0, map[string]string{})), service.ErrRecordIDInvalid))
+	assert.True(t, errors.Is(s.CreateRecord(ctx, newRecord(-1, map[string]string{})), service.ErrRecordIDInvalid))
+
+	// the failed create left the record alone
+	record, err = s.GetRecord(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, record.Data)
+}
+
+func testUpdate(t *testing.T, s service.RecordService) {
+	ctx := context.Background()
+	assert.NoError(t, s.CreateRecord(ctx, newRecord(1, map[string]string{"a": "1", "b": "2"})))
+
+	updated, err := s.UpdateRecord(ctx, 1, map[string]*string{"a": value("10"), "c": value("3")})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, updated.Version)
+	assert.Equal(t, map[string]string{"a": "10", "b": "2", "c": "3"}, updated.Data)
+
+	record, err := s.GetRecord(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, updated.Version, record.Version)
+	assert.Equal(t, updated.Data, record.Data)
+
+	// an empty update still records a version
+	updated, err = s.UpdateRecord(ctx, 1, map[string]*string{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, updated.Version)
+	assert.Equal(t, map[string]string{"a": "10", "b": "2", "c": "3"}, updated.Data)
+
+	// changing a returned record changes nothing stored
+	record.Data["a"] = "changed"
+	record, err = s.GetRecord(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "10", record.Data["a"])
+}
+
+func testDeleteKey(t *testing.T, s service.RecordService) {
+	ctx := context.Background()
+	assert.NoError(t, s.CreateRecord(ctx, newRecord(1, map[string]string{"a": "1", "b": "2"})))
+
+	updated, err := s.UpdateRecord(ctx, 1, map[string]*string{"a": nil, "missing": nil, "c": value("3")})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"b": "2", "c": "3"}, updated.Data)
+
+	// deleting every key leaves an empty record, not a missing one
+	updated, err = s.UpdateRecord(ctx, 1, map[string]*string{"b": nil, "c": nil})
+	assert.NoError(t, err)
+	assert.Empty(t, updated.Data)
+	record, err := s.GetRecord(ctx, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, record.Data)
+	assert.Equal(t, 3, record.Version)
+
+	// the deleted keys are still in the history
+	first, err := s.GetRecordAtVersion(ctx, 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, first.Data)
+}
+
+func testVersionNumbering(t *testing.T, s service.RecordService) {
+	ctx := context.Background()
+	assert.NoError(t, s.CreateRecord(ctx, newRecord(1, map[string]string{"n": "1"})))
+	assert.NoError(t, s.CreateRecord(ctx, newRecord(2, map[string]string{"n": "1"})))
+	for n := 2; n <= 5; n++ {
+		updated, err := s.UpdateRecord(ctx, 1, map[string]*string{"n": value(fmt.Sprint(n))})
+		assert.NoError(t, err)
+		assert.Equal(t, n, updated.Version)
+	}
+
+	versions, err := s.GetRecordVersions(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{5, 4, 3, 2, 1}, versions, "versions are listed newest first")
+
+	// versions are numbered per record
+	versions, err = s.GetRecordVersions(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, versions)
+	updated, err := s.UpdateRecord(ctx, 2, map[string]*string{"n": value("2")})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, updated.Version)
+}
+
+func testHistoricalReads(t *testing.T, s service.RecordService) {
+	ctx := context.Background()
+	before := time.Now().Add(-time.Hour)
+	assert.NoError(t, s.CreateRecord(ctx, newRecord(1, map[string]string{"address": "1 old street"})))
+	_, err := s.UpdateRecord(ctx, 1, map[string]*string{"address": value("2 new street"), "employees": value("4")})
+	assert.NoError(t, err)
+	_, err = s.UpdateRecord(ctx, 1, map[string]*string{"employees": nil})
+	assert.NoError(t, err)
+
+	expected := []map[string]string{
+		{"address": "1 old street"},
+		{"address": "2 new street", "employees": "4"},
+		{"address": "2 new street"},
+	}
+	for i, data := range expected {
+		record, err := s.GetRecordAtVersion(ctx, 1, i+1)
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, record.Version)
+		assert.Equal(t, data, record.Data)
+	}
+
+	history, err := s.GetRecordHistory(ctx, 1)
+	assert.NoError(t, err)
+	if !assert.Len(t, history, len(expected)) {
+		return
+	}
+	for i, record := range history {
+		assert.Equal(t, i+1, record.Version, "history is oldest first")
+		assert.Equal(t, expected[i], record.Data)
+	}
+
+	// every version was recorded within the last second, the latest is in force now
+	record, err := s.GetRecordAtTime(ctx, 1, time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, record.Version)
+	_, err = s.GetRecordAtTime(ctx, 1, before)
+	assert.True(t, errors.Is(err, service.ErrRecordDoesNotExist), "the record did not exist an hour ago")
+}
+
+func testNotFound(t *testing.T, s service.RecordService) {
+	ctx := context.Background()
+	_, err := s.GetRecord(ctx, 1)
+	assert.True(t, errors.Is(err, service.ErrRecordDoesNotExist))
+	_, err = s.UpdateRecord(ctx, 1, map[string]*string{"a": value("1")})
+	assert.True(t, errors.Is(err, service.ErrRecordDoesNotExist))
+	_, err = s.GetRecordAtVersion(ctx, 1, 1)
+	assert.True(t, errors.Is(err, service.ErrRecordDoesNotExist))
+	_, err = s.GetRecordAtTime(ctx, 1, time.Now())
+	assert.True(t, errors.Is(err, service.ErrRecordDoesNotExist))
+
+	// lists of a missing record are empty rather than errors
+	versions, err := s.GetRecordVersions(ctx, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, versions)
+	history, err := s.GetRecordHistory(ctx, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, history)
+
+	// the update created nothing
+	_, err = s.GetRecord(ctx, 1)
+	assert.True(t, errors.Is(err, service.ErrRecordDoesNotExist))
+
+	assert.NoError(t, s.CreateRecord(ctx, newRecord(1, map[string]string{})))
+	_, err = s.GetRecordAtVersion(ctx, 1, 2)
+	assert.True(t, errors.Is(err, service.ErrRecordDoesNotExist))
+	_, err = s.GetRecordAtVersion(ctx, 1, 0)
+	assert.True(t, errors.Is(err, service.ErrRecordDoesNotExist))
+}
+
+// retryable errors are the ones a writer losing a race may see
+func retryable(err error) bool {
+	return errors.Is(err, service.ErrRecordConflict) || errors.Is(err, service.ErrUnavailable)
+}
+
+func testConcurrentCreates(t *testing.T, s service.RecordService) {
+	ctx := context.Background()
+	errs := make(chan error, WRITERS)
+	var wg sync.WaitGroup
+	for i := 0; i < WRITERS; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				err := s.CreateRecord(ctx, newRecord(1, map[string]string{"writer": fmt.Sprint(i)}))
+				if !errors.Is(err, service.ErrUnavailable) {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		if err == nil {
+			created++
+		} else {
+			assert.True(t, errors.Is(err, service.ErrRecordAlreadyExists))
+		}
+	}
+	assert.Equal(t, 1, created, "exactly one create of the same id succeeds")
+	versions, err := s.GetRecordVersions(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, versions)
+}
+
+func testConcurrentWriters(t *testing.T, s service.RecordService) {
+	ctx := context.Background()
+	assert.NoError(t, s.CreateRecord(ctx, newRecord(1, map[string]string{})))
+
+	// every writer sets its own key, retrying when it loses a race like a client would
+	var wg sync.WaitGroup
+	for i := 0; i < WRITERS; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				_, err := s.UpdateRecord(ctx, 1, map[string]*string{fmt.Sprintf("writer%d", i): value("done")})
+				if !retryable(err) {
+					assert.NoError(t, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// no update is lost and no version is written twice
+	record, err := s.GetRecord(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, WRITERS+1, record.Version)
+	assert.Len(t, record.Data, WRITERS)
+
+	versions, err := s.GetRecordVersions(ctx, 1)
+	assert.NoError(t, err)
+	sort.Ints(versions)
+	for i, version := range versions {
+		assert.Equal(t, i+1, version)
+	}
+	history, err := s.GetRecordHistory(ctx, 1)
+	assert.NoError(t, err)
+	for i, record := range history {
+		assert.Len(t, record.Data, i, "version %d adds exactly one key", record.Version)
+	}
+}
+
+func testPersistence(t *testing.T, backend Backend) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, release := backend.Open(t, dir)
+	assert.NoError(t, s.CreateRecord(ctx, newRecord(1, map[string]string{"a": "1"})))
+	_, err := s.UpdateRecord(ctx, 1, map[string]*string{"a": value("2"), "b": value("1")})
+	assert.NoError(t, err)
+	history, err := s.GetRecordHistory(ctx, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, release())
+
+	s, release = backend.Open(t, dir)
+	defer func() { assert.NoError(t, release()) }()
+	reopened, err := s.GetRecordHistory(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, history, reopened)
+
+	// writing carries on from the versions kept
+	updated, err := s.UpdateRecord(ctx, 1, map[string]*string{"a": nil})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, updated.Version)
+	assert.Equal(t, map[string]string{"b": "1"}, updated.Data)
+}
@@ -0,0 +1,47 @@
+package servicetest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/postgres"
+	"github.com/chauvm/timetravel/postgres/postgrestest"
+	"github.com/chauvm/timetravel/service"
+)
+
+func TestPersistentRecordService(t *testing.T) {
+	Run(t, Backend{Open: func(t *testing.T, dir string) (service.RecordService, func() error) {
+		options := database.DefaultOptions()
+		options.File = filepath.Join(dir, "records.db")
+		db, err := database.CreateConnection(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := service.NewPersistentRecordService(db, service.DefaultLimits())
+		return &s, func() error { return database.Close(db) }
+	}})
+}
+
+func TestPostgresRecordService(t *testing.T) {
+	server := postgrestest.Server(t)
+	Run(t, Backend{Open: func(t *testing.T, dir string) (service.RecordService, func() error) {
+		options := postgres.DefaultOptions()
+		options.URL = postgrestest.Schema(t, server, dir)
+		db, err := postgres.Open(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := service.NewPostgresRecordService(db, service.DefaultLimits())
+		return &s, func() error { return postgres.Close(db) }
+	}})
+}
+
+func TestInMemoryRecordService(t *testing.T) {
+	Run(t, Backend{
+		Open: func(t *testing.T, dir string) (service.RecordService, func() error) {
+			return service.NewInMemoryRecordService(service.DefaultLimits()), func() error { return nil }
+		},
+		Ephemeral: true,
+	})
+}
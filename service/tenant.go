@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+
+	"github.com/chauvm/timetravel/database"
+	"github.com/chauvm/timetravel/entity"
+)
+
+// TenantService is implemented by record services that can export and delete all the
+// records of a tenant at once. The tenant is the one of the context, see the tenant package.
+type TenantService interface {
+
+	// ExportTenant will retrieve every version of every record of the tenant, by id then oldest first.
+	ExportTenant(ctx context.Context) ([]entity.Record, error)
+
+	// DeleteTenant will remove every record of the tenant and return how many versions were removed.
+	//
+	// DeleteTenant will error, removing nothing, while any record of the tenant is under legal hold.
+	// The history of the legal holds is kept.
+	DeleteTenant(ctx context.Context) (int, error)
+}
+
+func (s *PersistentRecordService) ExportTenant(ctx context.Context) ([]entity.Record, error) {
+	versions, err := database.GetTenantRecords(ctx, s.db)
+	if err != nil {
+		return nil, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+	}
+
+	records := make([]entity.Record, 0, len(versions))
+	for _, record := range versions {
+		records = append(records, *record)
+	}
+	return records, nil
+}
+
+func (s *PersistentRecordService) DeleteTenant(ctx context.Context) (int, error) {
+	deleted, err := database.DeleteTenant(ctx, s.db)
+	if err != nil {
+		return 0, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+	}
+	return deleted, nil
+}
+
+// GetTenants lists every tenant that has records, whatever the tenant of ctx.
+func (s *PersistentRecordService) GetTenants(ctx context.Context) ([]string, error) {
+	tenants, err := database.GetTenants(ctx, s.db)
+	return tenants, translateError(err, ErrRecordDoesNotExist, ErrRecordConflict)
+}
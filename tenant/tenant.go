@@ -0,0 +1,38 @@
+// Package tenant carries the tenant a request acts on through contexts. Every query of
+// the database package is scoped to the tenant of its context.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"regexp"
+)
+
+var ErrTenantInvalid = errors.New("tenant id must be 1 to 64 letters, digits, '-' or '_'")
+
+// DEFAULT is the tenant of contexts carrying none, and of records written before tenants existed
+const DEFAULT = "default"
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type contextKey int
+
+const tenantKey contextKey = iota
+
+// Valid reports whether id can name a tenant.
+func Valid(id string) bool {
+	return validID.MatchString(id)
+}
+
+// WithID returns a context acting on the tenant id.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey, id)
+}
+
+// ID returns the tenant of ctx, DEFAULT if it carries none.
+func ID(ctx context.Context) string {
+	if id, ok := ctx.Value(tenantKey).(string); ok && id != "" {
+		return id
+	}
+	return DEFAULT
+}
@@ -0,0 +1,26 @@
+package tenant
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestID(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, DEFAULT, ID(ctx))
+	assert.Equal(t, "acme", ID(WithID(ctx, "acme")))
+	assert.Equal(t, DEFAULT, ID(WithID(ctx, "")))
+}
+
+func TestValid(t *testing.T) {
+	assert.True(t, Valid("acme"))
+	assert.True(t, Valid("Acme_Corp-2"))
+	assert.True(t, Valid(strings.Repeat("a", 64)))
+	assert.False(t, Valid(""))
+	assert.False(t, Valid(strings.Repeat("a", 65)))
+	assert.False(t, Valid("acme corp"))
+	assert.False(t, Valid("acme/../other"))
+}